@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// checkBindings is the -check-bindings value: "group" compares the
+// descriptor bindings of the shaders in each group declared with
+// "// spv:group" or "// spv:link-group", "all" those of every shader, and ""
+// turns the check off.
+var checkBindings string
+
+// checkedModules holds the modules compiled in this run for the binding
+// check, which reads the others back from their generated files.
+var checkedModules = struct {
+	sync.Mutex
+	m map[string][]uint32
+}{m: make(map[string][]uint32)}
+
+// recordModule keeps the module compiled from src for the binding check.
+func recordModule(src string, words []uint32) {
+	checkedModules.Lock()
+	checkedModules.m[src] = words
+	checkedModules.Unlock()
+}
+
+// checkBindingsArg returns an error for an invalid -check-bindings value.
+func checkBindingsArg() error {
+	switch checkBindings {
+	case "", "group", "all":
+		return nil
+	}
+	return fmt.Errorf("invalid -check-bindings %q; expected group or all", checkBindings)
+}
+
+// bindingUse is a resource bound to a set and binding by a shader.
+type bindingUse struct {
+	src string
+	binding
+}
+
+// bindingConflicts returns a description of every set and binding that two
+// shaders checked together bind to different kinds of descriptors, such as a
+// uniform buffer in one and a storage buffer in the other, which the
+// pipeline layout they share can't satisfy.
+func bindingConflicts() ([]string, error) {
+	defer func() {
+		checkedModules.Lock()
+		checkedModules.m = make(map[string][]uint32)
+		checkedModules.Unlock()
+	}()
+
+	groups, err := bindingGroups()
+	if err != nil {
+		return nil, err
+	}
+	uses := make(map[string][]binding)
+	var conflicts []string
+	for _, g := range groups {
+		first := make(map[[2]uint32]bindingUse)
+		for _, src := range g.sources {
+			bs, found := uses[src]
+			if !found {
+				if bs, err = sourceBindings(src); err != nil {
+					return nil, fmt.Errorf("%s: %v", src, err)
+				}
+				uses[src] = bs
+			}
+			for _, b := range bs {
+				key := [2]uint32{b.set, b.binding}
+				u, found := first[key]
+				switch {
+				case !found:
+					first[key] = bindingUse{src, b}
+				case u.src == src:
+					// Aliased resources of one shader are left to the validator
+				case u.typ != "" && b.typ != "" && u.typ != b.typ:
+					conflicts = append(conflicts, fmt.Sprintf("%sset %d binding %d is a %s in %s (%s) but a %s in %s (%s)",
+						g.prefix, b.set, b.binding, u.typ, u.src, u.name, b.typ, src, b.name))
+				}
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// bindingGroup is a set of shaders whose bindings are checked together.
+type bindingGroup struct {
+	prefix  string // of the conflicts found in it, e.g. "group Lighting: "
+	sources []string
+}
+
+// bindingGroups returns the groups of shaders the -check-bindings mode
+// compares, sorted by name. Groups of a single shader can't conflict and are
+// left out.
+func bindingGroups() ([]bindingGroup, error) {
+	if checkBindings == "all" {
+		return []bindingGroup{{"", filesTotal}}, nil
+	}
+
+	byName := make(map[string][]string)
+	for _, src := range filesTotal {
+		group, err := shaderGroup(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", src, err)
+		}
+		if group != "" {
+			byName["group "+group] = append(byName["group "+group], src)
+		}
+		link, err := linkGroupOf(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", src, err)
+		}
+		if link != "" {
+			byName["link group "+link] = append(byName["link group "+link], src)
+		}
+	}
+	var groups []bindingGroup
+	for name, srcs := range byName {
+		if len(srcs) > 1 {
+			groups = append(groups, bindingGroup{name + ": ", srcs})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].prefix < groups[j].prefix })
+	return groups, nil
+}
+
+// sourceBindings returns the bindings of the module of src, compiled in this
+// run or read back from its generated file. Sources without one, such as
+// disabled sources that were never compiled, have none.
+func sourceBindings(src string) ([]binding, error) {
+	checkedModules.Lock()
+	words, found := checkedModules.m[src]
+	checkedModules.Unlock()
+	if !found {
+		var err error
+		if words, err = moduleIn(generatedName(src), src); os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	m, err := parseSPIRV(words)
+	if err != nil {
+		return nil, err
+	}
+	return m.bindings(), nil
+}
+
+// reportBindingConflicts prints the binding conflicts as warnings, or as
+// errors with -Werror, and returns the exit code.
+func reportBindingConflicts() int {
+	conflicts, err := bindingConflicts()
+	if err != nil {
+		fmt.Printf("%s error: Cannot check the bindings: %v\n", os.Args[0], err)
+		return 1
+	}
+	kind := "warning"
+	if werror {
+		kind = "error"
+	}
+	for _, c := range conflicts {
+		fmt.Printf("%s %s: conflicting bindings: %s\n", os.Args[0], kind, c)
+	}
+	if werror && len(conflicts) > 0 {
+		printSummary("ERROR", fmt.Sprintf("%d conflicting bindings", len(conflicts)))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package main
+
+import "sort"
+
+const (
+	opTypeImage                 = 25
+	opTypeSampler               = 26
+	opTypeSampledImage          = 27
+	opTypeAccelerationStructure = 5341
+
+	decorationBufferBlock   = 3
+	decorationBinding       = 33
+	decorationDescriptorSet = 34
+
+	storageClassUniformConstant = 0
+	storageClassUniform         = 2
+	storageClassStorageBuffer   = 12
+
+	dimBuffer      = 5
+	dimSubpassData = 6
+)
+
+// autoMapBindings and autoMapLocations pass --auto-map-bindings and
+// --auto-map-locations to the compiler, which then assigns the bindings and
+// locations that sources leave out.
+var autoMapBindings, autoMapLocations bool
+
+// autoMapArgs returns the compiler arguments for -auto-map-bindings and
+// -auto-map-locations.
+func autoMapArgs() []string {
+	var args []string
+	if autoMapBindings {
+		args = append(args, "--auto-map-bindings")
+	}
+	if autoMapLocations {
+		args = append(args, "--auto-map-locations")
+	}
+	return args
+}
+
+// binding is a resource variable of a module bound to a descriptor.
+type binding struct {
+	set, binding uint32
+	name         string // of the variable, or of its block if the variable has none
+	typ          string // kind of descriptor; see descriptorType
+}
+
+// bindings returns the descriptor bindings of the module's resources sorted
+// by set and binding, as assigned in the source or by -auto-map-bindings.
+func (m *spirvModule) bindings() []binding {
+	names := make(map[uint32]string)
+	for _, in := range m.instrs {
+		if in.opcode == opName && len(in.operands) > 1 {
+			names[in.operands[0]] = spirvString(in.operands[1:])
+		}
+	}
+
+	var bs []binding
+	for _, v := range m.variables {
+		id := v.operands[1]
+		b, found := m.decoration(id, decorationBinding)
+		if !found {
+			continue
+		}
+		set, _ := m.decoration(id, decorationDescriptorSet)
+		name := names[id]
+		if ptr := m.types[v.operands[0]]; name == "" && ptr.opcode == opTypePointer && len(ptr.operands) > 2 {
+			name = names[ptr.operands[2]]
+		}
+		bs = append(bs, binding{set, b, name, m.descriptorType(v)})
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		if bs[i].set != bs[j].set {
+			return bs[i].set < bs[j].set
+		}
+		return bs[i].binding < bs[j].binding
+	})
+	return bs
+}
+
+// descriptorType returns the kind of descriptor the resource variable v is
+// bound to, e.g. "uniform buffer" or "combined image sampler", as in the
+// VkDescriptorType names, or "" if it is none of those.
+func (m *spirvModule) descriptorType(v instruction) string {
+	ptr := m.types[v.operands[0]]
+	if ptr.opcode != opTypePointer || len(ptr.operands) < 3 {
+		return ""
+	}
+	t := m.types[ptr.operands[2]]
+	for (t.opcode == opTypeArray || t.opcode == opTypeRuntimeArray) && len(t.operands) > 1 {
+		t = m.types[t.operands[1]]
+	}
+	if len(t.operands) == 0 {
+		return ""
+	}
+
+	switch v.operands[2] {
+	case storageClassUniform:
+		if _, found := m.decorations[t.operands[0]][decorationBufferBlock]; found {
+			return "storage buffer" // the pre-SPIR-V 1.3 form of buffer blocks
+		}
+		return "uniform buffer"
+	case storageClassStorageBuffer:
+		return "storage buffer"
+	case storageClassUniformConstant:
+	default:
+		return ""
+	}
+	switch t.opcode {
+	case opTypeSampler:
+		return "sampler"
+	case opTypeSampledImage:
+		return "combined image sampler"
+	case opTypeAccelerationStructure:
+		return "acceleration structure"
+	case opTypeImage:
+		if len(t.operands) < 7 {
+			return ""
+		}
+		dim, sampled := t.operands[2], t.operands[6]
+		switch {
+		case dim == dimSubpassData:
+			return "input attachment"
+		case dim == dimBuffer && sampled == 2:
+			return "storage texel buffer"
+		case dim == dimBuffer:
+			return "uniform texel buffer"
+		case sampled == 2:
+			return "storage image"
+		}
+		return "sampled image"
+	}
+	return ""
+}
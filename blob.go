@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// blobFilename is the file that holds the modules of every shader with
+// -as blob, in the output directory.
+const blobFilename = "spv_blob.gen.go"
+
+func blobPath() string {
+	return path.Join(outputDir(), blobFilename)
+}
+
+// inBlob returns true if the module of src goes into the blob, i.e. with -as
+// blob unless a "// spv:output" directive keeps it elsewhere.
+func inBlob(src string) bool {
+	return outputMode == "blob" && outputDirective(src) == ""
+}
+
+// blobModules holds the modules compiled in this run by source, until the
+// blob is written with the manifest. They are kept in module files in the
+// temp directory rather than in memory. A nil entry marks a source compiled in
+// this run whose module isn't in the blob.
+var blobModules = struct {
+	sync.Mutex
+	m map[string]*blobEntry
+}{m: make(map[string]*blobEntry)}
+
+// stashBlobModule writes the module compiled from src into a module file for
+// writeBlob.
+func stashBlobModule(src string, words []uint32) error {
+	var e *blobEntry
+	if inBlob(src) {
+		e = &blobEntry{source: src, file: filepath.Join(tempDir, fmt.Sprintf("%s_%d.blob", strings.ReplaceAll(src, "/", "_"), rand.Int())), length: len(words)}
+		err := writeAtomic(e.file, func(w io.Writer) error {
+			_, err := w.Write(spirvBytes(words))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	blobModules.Lock()
+	blobModules.m[src] = e
+	blobModules.Unlock()
+	return nil
+}
+
+// blobEntry is a module in the blob, either in a module file or one of the
+// modules of the old blob.
+type blobEntry struct {
+	source string
+	file   string
+	words  []uint32 // if there is no file
+	length int
+}
+
+// writeWords writes the words of the module for writeBlob, continuing the
+// lines of perLine words with the i-th word of the blob out of total.
+func (e *blobEntry) writeWords(w *bufio.Writer, i, total, perLine int) error {
+	words := e.words
+	var r *bufio.Reader
+	if e.file != "" {
+		f, err := os.Open(e.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = bufio.NewReader(f)
+	}
+	var b [4]byte
+	for j := 0; j < e.length; j, i = j+1, i+1 {
+		var word uint32
+		if r != nil {
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return err
+			}
+			word = binary.LittleEndian.Uint32(b[:])
+		} else {
+			word = words[j]
+		}
+		if i%perLine == 0 {
+			w.WriteByte('\t')
+		} else {
+			w.WriteByte(' ')
+		}
+		fmt.Fprintf(w, "0x%08x,", word)
+		if i%perLine == perLine-1 || i == total-1 {
+			w.WriteByte('\n')
+		}
+	}
+	return nil
+}
+
+// blobEntries returns the modules of the blob sorted by source: those compiled
+// in this run, and the old modules of the sources that weren't.
+func blobEntries() ([]*blobEntry, error) {
+	old, err := readBlob(blobPath())
+	if err != nil {
+		return nil, err
+	}
+	blobModules.Lock()
+	defer blobModules.Unlock()
+	var entries []*blobEntry
+	for _, src := range filesTotal {
+		e, compiled := blobModules.m[src]
+		if words := old[src]; !compiled && words != nil {
+			e = &blobEntry{source: src, words: words, length: len(words)}
+		}
+		if e != nil {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].source < entries[j].source })
+	return entries, nil
+}
+
+// writeBlob writes the blob file with the entries: an index mapping every
+// source to the offset and length of its module, in words, a single array
+// with all the modules one after another and blobModule, which returns a
+// module as a subslice of the array. As the array holds words, every module
+// starts at a multiple of 4 bytes, so the subslices can be reinterpreted as
+// words. Only the index is run through gofmt, which aligns it; the modules
+// are streamed into w.
+func writeBlob(w *bufio.Writer, entries []*blobEntry) error {
+	writeHeader(w)
+	w.WriteString(genComment)
+	fmt.Fprintf(w, "\n\npackage %s\n\n", dataPackage())
+	w.WriteString(`import "unsafe"
+
+`)
+	var index bytes.Buffer
+	index.WriteString(`package index
+
+// blobIndex maps the sources to the offset and length of their modules in
+// blob, in words.
+var blobIndex = map[string][2]uint32{
+`)
+	var total int
+	for _, e := range entries {
+		fmt.Fprintf(&index, "\t%s: {%d, %d},\n", strconv.Quote(e.source), total, e.length)
+		total += e.length
+	}
+	index.WriteString("}\n")
+	formatted, err := format.Source(index.Bytes())
+	if err != nil {
+		return err
+	}
+	w.Write(bytes.TrimPrefix(formatted, []byte("package index\n\n")))
+
+	w.WriteString(`
+// blob holds the modules of the shaders one after another.
+var blob = [...]uint32{
+`)
+	perLine := wordsPerLine
+	if perLine <= 0 {
+		perLine = total
+	}
+	var i int
+	for _, e := range entries {
+		if err := e.writeWords(w, i, total, perLine); err != nil {
+			return err
+		}
+		i += e.length
+	}
+	w.WriteString(`}
+
+// blobModule returns the module compiled from the named source as a subslice
+// of blob, without copying it.
+func blobModule(name string) []byte {
+	e := blobIndex[name]
+	if e[1] == 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(unsafe.Pointer(&blob[e[0]]))[: 4*e[1] : 4*e[1]]
+}
+`)
+	return nil
+}
+
+// updateBlob writes the blob file with -as blob, or else removes the one left
+// from an earlier run.
+func updateBlob() error {
+	if outputMode != "blob" {
+		if err := os.Remove(blobPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	entries, err := blobEntries()
+	if err != nil {
+		return err
+	}
+	written, err := writeFileIfChanged(blobPath(), func(w *bufio.Writer) error {
+		return writeBlob(w, entries)
+	})
+	if err != nil {
+		return err
+	}
+	reportWritten(blobPath(), written)
+	return nil
+}
+
+// verifyBlob compares the blob file with a fresh build.
+func verifyBlob() error {
+	entries, err := blobEntries()
+	if err != nil {
+		return err
+	}
+	return verifyFile(blobPath(), func(w *bufio.Writer) error {
+		return writeBlob(w, entries)
+	})
+}
+
+// readBlob returns the modules in the blob file by source. A missing file has
+// none.
+func readBlob(filename string) (map[string][]uint32, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var index, data *ast.CompositeLit
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok || len(vs.Names) != 1 || len(vs.Values) != 1 {
+				continue
+			}
+			lit, _ := vs.Values[0].(*ast.CompositeLit)
+			switch vs.Names[0].Name {
+			case "blobIndex":
+				index = lit
+			case "blob":
+				data = lit
+			}
+		}
+	}
+	if index == nil || data == nil {
+		return nil, fmt.Errorf("%s has no blob; regenerate it with -force", filename)
+	}
+	words, err := literalWords(data)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string][]uint32)
+	for _, elt := range index.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, fmt.Errorf("unexpected blob index in %s", filename)
+		}
+		key, ok := kv.Key.(*ast.BasicLit)
+		pos, _ := kv.Value.(*ast.CompositeLit)
+		if !ok || pos == nil {
+			return nil, fmt.Errorf("unexpected blob index in %s", filename)
+		}
+		src, err := strconv.Unquote(key.Value)
+		if err != nil {
+			return nil, err
+		}
+		nums, err := literalWords(pos)
+		if err != nil || len(nums) != 2 || int(nums[0])+int(nums[1]) > len(words) {
+			return nil, fmt.Errorf("invalid blob index of %s in %s", src, filename)
+		}
+		modules[src] = words[nums[0] : nums[0]+nums[1]]
+	}
+	return modules, nil
+}
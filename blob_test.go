@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingWriter counts the bytes written into it.
+type countingWriter struct{ n int }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+
+func TestBlobRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(name string, perLine int) { pkg, wordsPerLine = name, perLine }(pkg, wordsPerLine)
+	pkg, wordsPerLine = "x", 8
+
+	entries := []*blobEntry{
+		{source: "a.frag", words: testModule(5, false)},
+		{source: "b.vert", words: testModule(101, true)},
+		{source: "sub/c.comp", words: testModule(1000, false)},
+	}
+	for _, e := range entries {
+		e.length = len(e.words)
+	}
+	name := filepath.Join(dir, blobFilename)
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	if err := writeBlob(w, entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	modules, err := readBlob(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != len(entries) {
+		t.Errorf("read %d modules, want %d", len(modules), len(entries))
+	}
+	for _, e := range entries {
+		if got, want := fmt.Sprint(modules[e.source]), fmt.Sprint(e.words); got != want {
+			t.Errorf("%s: read %d words differing from the %d written", e.source, len(modules[e.source]), len(e.words))
+		}
+	}
+}
+
+// TestBlobByteType checks that -byte-type converts the modules of -as blob and
+// that the manifest imports the package of the type.
+func TestBlobByteType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"a.frag": "void main() {}\n"})
+
+	if code := runSPV(t, dir, "-as", "blob", "-byte-type", "example.com/vk.ShaderCode"); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	for name, want := range map[string]string{
+		"a.frag" + genExtension: `vk.ShaderCode(blobModule("a.frag"))`,
+		manifestFilename:        `"example.com/vk"`,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), want) {
+			t.Errorf("%s has no %s:\n%s", name, want, b)
+		}
+	}
+}
+
+// BenchmarkBlobOutput writes the modules of 500 shaders as a single blob and
+// as a variable each in the default words mode, reporting the bytes of Go
+// source written as out-B/op. The blob is written both from modules in
+// memory, as those of the old blob are, and from module files, as those
+// compiled in the run are.
+func BenchmarkBlobOutput(b *testing.B) {
+	const shaders, words = 500, 2048
+	dir, err := ioutil.TempDir("", "spv-bench-*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(mode, name string, perLine int) { outputMode, pkg, wordsPerLine = mode, name, perLine }(outputMode, pkg, wordsPerLine)
+	pkg, wordsPerLine = "x", 8
+
+	var inMemory, inFiles []*blobEntry
+	for i := 0; i < shaders; i++ {
+		src := fmt.Sprintf("shader%d.frag", i)
+		module := testModule(words, true)
+		file := filepath.Join(dir, src+".blob")
+		if err := ioutil.WriteFile(file, spirvBytes(module), 0644); err != nil {
+			b.Fatal(err)
+		}
+		inMemory = append(inMemory, &blobEntry{source: src, words: module, length: words})
+		inFiles = append(inFiles, &blobEntry{source: src, file: file, length: words})
+	}
+
+	run := func(b *testing.B, write func(w *bufio.Writer) error) {
+		b.ReportAllocs()
+		var out countingWriter
+		for i := 0; i < b.N; i++ {
+			w := bufio.NewWriter(&out)
+			if err := write(w); err != nil {
+				b.Fatal(err)
+			}
+			w.Flush()
+		}
+		b.ReportMetric(float64(out.n)/float64(b.N), "out-B/op")
+	}
+	b.Run("per var", func(b *testing.B) {
+		outputMode = "words"
+		run(b, func(w *bufio.Writer) error {
+			for i, e := range inMemory {
+				writeBinaryData(w, fmt.Sprintf("spv_Shader%d", i), e.source, e.words)
+			}
+			return nil
+		})
+	})
+	blob := func(entries []*blobEntry) func(w *bufio.Writer) error {
+		return func(w *bufio.Writer) error {
+			if err := writeBlob(w, entries); err != nil {
+				return err
+			}
+			for i, e := range entries {
+				writeBinaryData(w, fmt.Sprintf("spv_Shader%d", i), e.source, nil)
+			}
+			return nil
+		}
+	}
+	b.Run("blob", func(b *testing.B) {
+		outputMode = "blob"
+		run(b, blob(inMemory))
+	})
+	b.Run("blob from module files", func(b *testing.B) {
+		outputMode = "blob"
+		run(b, blob(inFiles))
+	})
+}
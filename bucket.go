@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bucketCount is the number of files -bucket generates the shaders into, or 0
+// for a file per shader.
+var bucketCount int
+
+// bucketPrefix starts the names of the bucket files, e.g. "spv_bucket3.gen.go".
+const bucketPrefix = "spv_bucket"
+
+// sectionPrefix starts the comment before the declarations of each source in
+// a bucket file.
+const sectionPrefix = metaPrefix + "section "
+
+// bucketName returns the generated file of bucket k.
+func bucketName(k uint32) string {
+	return path.Join(outputDir(), bucketPrefix+strconv.FormatUint(uint64(k), 10)+genExtension)
+}
+
+// bucketOf returns the bucket of the source src. It is a hash of the logical
+// path, so that adding or removing a source only rewrites its own bucket.
+func bucketOf(src string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(src))
+	return h.Sum32() % uint32(bucketCount)
+}
+
+// isBucketFile returns true if filename is named like a bucket file, of any
+// -bucket count.
+func isBucketFile(filename string) bool {
+	name := path.Base(filename)
+	if !strings.HasPrefix(name, bucketPrefix) || !strings.HasSuffix(name, genExtension) {
+		return false
+	}
+	_, err := strconv.ParseUint(strings.TrimSuffix(name[len(bucketPrefix):], genExtension), 10, 32)
+	return err == nil
+}
+
+// bucketEntry is what a bucket file holds for one of its sources. The
+// declarations are not held in memory but copied from a file when the bucket
+// is written: from the section file stashSection wrote for a fresh entry, or
+// from the old bucket file.
+type bucketEntry struct {
+	source       string
+	meta         []string // metadata lines, or nil for a fresh entry
+	file         string   // the file holding the declarations
+	start, end   int64    // the offsets of the declarations in file
+	unterminated bool     // the declarations lack the final newline
+}
+
+// bucketSections holds the section files of the sources compiled in this run,
+// until their buckets are written.
+var bucketSections = struct {
+	sync.Mutex
+	m map[string]*bucketEntry
+}{m: make(map[string]*bucketEntry)}
+
+// stashSection writes the declarations of the source src with write into a
+// section file in the temp directory for writeBuckets, so that they don't
+// have to be kept in memory.
+func stashSection(src string, write func(*bufio.Writer) error) error {
+	name := filepath.Join(tempDir, fmt.Sprintf("%s_%d.section", strings.ReplaceAll(src, "/", "_"), rand.Int()))
+	err := writeAtomic(name, func(f io.Writer) error {
+		w := bufio.NewWriter(f)
+		if err := write(w); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	bucketSections.Lock()
+	bucketSections.m[src] = &bucketEntry{source: src, file: name, end: fi.Size()}
+	bucketSections.Unlock()
+	return nil
+}
+
+// readBucket returns the entries of the bucket file by source. A missing file
+// has none. The file is read a line at a time, recording where the
+// declarations of each source are.
+func readBucket(filename string) (map[string]*bucketEntry, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]*bucketEntry)
+	entry := func(src string) *bucketEntry {
+		if entries[src] == nil {
+			entries[src] = &bucketEntry{source: src, file: filename}
+		}
+		return entries[src]
+	}
+	var cur *bucketEntry
+	var offset, start, end int64 // of the line, the section and its last non-blank line
+	var unterminated bool
+	endSection := func() {
+		if cur != nil && end > start {
+			cur.start, cur.end, cur.unterminated = start, end, unterminated
+		}
+	}
+	header := true
+	r := bufio.NewReader(f)
+	for {
+		raw, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if raw == "" {
+			break
+		}
+		offset += int64(len(raw))
+		line := strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+		switch {
+		case header && strings.HasPrefix(line, "package "):
+			header, cur = false, nil
+		case header && strings.HasPrefix(line, metaPrefix+"source "):
+			cur = entry(line[len(metaPrefix+"source "):])
+			cur.meta = append(cur.meta, line)
+		case header && strings.HasPrefix(line, metaPrefix) && cur != nil:
+			cur.meta = append(cur.meta, line)
+		case !header && strings.HasPrefix(line, sectionPrefix):
+			endSection()
+			cur = entry(line[len(sectionPrefix):])
+			start, end = offset, offset
+		case !header && cur != nil && line != "":
+			end, unterminated = offset, !strings.HasSuffix(raw, "\n")
+		}
+	}
+	endSection()
+	return entries, nil
+}
+
+// bucketSources returns the sources of each bucket file, sorted.
+func bucketSources() map[string][]string {
+	buckets := make(map[string][]string)
+	for _, src := range filesTotal {
+		gen := generatedName(src)
+		buckets[gen] = append(buckets[gen], src)
+	}
+	for _, srcs := range buckets {
+		sort.Strings(srcs)
+	}
+	return buckets
+}
+
+// bucketEntries returns the entries to write into the bucket file gen for its
+// sources srcs: the fresh declarations of the sources compiled in this run,
+// and the old entries of the others. Sources with neither, such as new ones
+// that failed to compile, are left out until they compile.
+func bucketEntries(gen string, srcs []string) ([]*bucketEntry, error) {
+	old, err := readBucket(gen)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*bucketEntry
+	bucketSections.Lock()
+	defer bucketSections.Unlock()
+	for _, src := range srcs {
+		if e, found := bucketSections.m[src]; found {
+			entries = append(entries, e)
+		} else if e := old[src]; e != nil && e.meta != nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// writeBucket writes a bucket file with the entries, in the same layout as
+// the file of a single shader: the metadata of every source comes before the
+// package clause and the declarations after it, each preceded by a section
+// comment naming its source.
+func writeBucket(outFile *bufio.Writer, entries []*bucketEntry) error {
+	var embedded bool
+	for _, e := range entries {
+		embedded = embedded || isEmbedded(e.source)
+	}
+	if c := requireGo(nil, embedded, embedMinor); c != nil {
+		if err := writeBuildConstraint(outFile, c); err != nil {
+			return err
+		}
+	}
+	writeHeader(outFile)
+	outFile.WriteString(genComment)
+	outFile.WriteString("\n\n")
+	for _, e := range entries {
+		if e.meta == nil {
+			writeMeta(outFile, e.source)
+			continue
+		}
+		for _, line := range e.meta {
+			outFile.WriteString(line + "\n")
+		}
+	}
+	fmt.Fprintf(outFile, "\npackage %s\n\n", dataPackage())
+	writeImports(outFile)
+	for i, e := range entries {
+		if i > 0 {
+			outFile.WriteString("\n")
+		}
+		outFile.WriteString(sectionPrefix + e.source + "\n")
+		if err := e.copySection(outFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySection copies the declarations of the entry from its file into w.
+func (e *bucketEntry) copySection(w *bufio.Writer) error {
+	f, err := os.Open(e.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, io.NewSectionReader(f, e.start, e.end-e.start)); err != nil {
+		return err
+	}
+	if e.unterminated {
+		w.WriteString("\n")
+	}
+	return nil
+}
+
+// writeBuckets rewrites the bucket files holding a source compiled in this
+// run or having lost one, as listed in staleBuckets.
+func writeBuckets() error {
+	bucketSections.Lock()
+	for src := range bucketSections.m {
+		staleBuckets[generatedName(src)] = true
+	}
+	bucketSections.Unlock()
+
+	for gen, srcs := range bucketSources() {
+		if !staleBuckets[gen] {
+			continue
+		}
+		entries, err := bucketEntries(gen, srcs)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		written, err := writeFileIfChanged(gen, func(w *bufio.Writer) error {
+			return writeBucket(w, entries)
+		})
+		if err != nil {
+			return err
+		}
+		reportWritten(gen, written)
+	}
+	return nil
+}
+
+// verifyBuckets compares every bucket file with a fresh build, reporting the
+// ones that differ, and returns their number.
+func verifyBuckets() int {
+	var failed int
+	for gen, srcs := range bucketSources() {
+		entries, err := bucketEntries(gen, srcs)
+		if err == nil {
+			err = verifyFile(gen, func(w *bufio.Writer) error {
+				return writeBucket(w, entries)
+			})
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", os.Args[0], err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// staleBuckets holds the bucket files to rewrite even if none of their
+// sources is compiled, because a source they hold is gone or moved to another
+// bucket.
+var staleBuckets map[string]bool
+
+// findStaleBuckets fills staleBuckets and returns the sources held by each of
+// the existing bucket files in generated. It also drops the declarations kept
+// from an earlier run, e.g. with -watch.
+func findStaleBuckets(generated map[string]e) (map[string]map[string]bool, error) {
+	staleBuckets = make(map[string]bool)
+	bucketSections.m = make(map[string]*bucketEntry)
+	held := make(map[string]map[string]bool)
+	if bucketCount == 0 {
+		return held, nil
+	}
+	current := make(map[string]bool)
+	for _, src := range filesTotal {
+		current[src] = true
+	}
+	for gen := range generated {
+		if !isBucketFile(gen) {
+			continue
+		}
+		metas, err := readMetas(gen)
+		if err != nil {
+			return nil, err
+		}
+		held[gen] = make(map[string]bool)
+		for _, m := range metas {
+			held[gen][m.Source] = true
+			if !current[m.Source] || generatedName(m.Source) != gen {
+				staleBuckets[gen] = true
+			}
+		}
+	}
+	return held, nil
+}
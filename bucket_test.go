@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBucket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		file     string
+		sections map[string]string
+	}{
+		{
+			"two sources",
+			"//spv:source a.frag\n//spv:stage frag\n//spv:source b.vert\n\npackage x\n\n" +
+				"//spv:section a.frag\nvar A = 1\n\n//spv:section b.vert\nvar B = 2\n",
+			map[string]string{"a.frag": "var A = 1\n", "b.vert": "var B = 2\n"},
+		},
+		{
+			"trailing blank lines",
+			"//spv:source a.frag\n\npackage x\n\n//spv:section a.frag\nvar A = 1\n\nvar A2 = 1\n\n\n",
+			map[string]string{"a.frag": "var A = 1\n\nvar A2 = 1\n"},
+		},
+		{
+			"CRLF",
+			"//spv:source a.frag\r\n\r\npackage x\r\n\r\n//spv:section a.frag\r\nvar A = 1\r\n\r\n",
+			map[string]string{"a.frag": "var A = 1\n"},
+		},
+		{
+			"unterminated",
+			"//spv:source a.frag\n\npackage x\n\n//spv:section a.frag\nvar A = 1",
+			map[string]string{"a.frag": "var A = 1\n"},
+		},
+		{
+			"empty section",
+			"//spv:source a.frag\n\npackage x\n\n//spv:section a.frag\n\n",
+			map[string]string{"a.frag": ""},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, bucketPrefix+string(rune('0'+i))+genExtension)
+			if err := ioutil.WriteFile(name, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := readBucket(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.sections) {
+				t.Errorf("%d entries, want %d", len(entries), len(tt.sections))
+			}
+			for src, want := range tt.sections {
+				e := entries[src]
+				if e == nil || e.meta == nil {
+					t.Errorf("no entry with metadata for %s", src)
+					continue
+				}
+				got, err := renderFile(e.copySection)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, []byte(want)) {
+					t.Errorf("section of %s is %q, want %q", src, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStashSection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(td string) { tempDir = td }(tempDir)
+	tempDir = dir
+	defer func() { bucketSections.m = make(map[string]*bucketEntry) }()
+
+	want := "var A = 1\n\nvar B = 2\n"
+	if err := stashSection("sub/a.frag", func(w *bufio.Writer) error {
+		_, err := w.WriteString(want)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e := bucketSections.m["sub/a.frag"]
+	if e == nil || e.meta != nil {
+		t.Fatalf("stashed entry %+v", e)
+	}
+	got, err := renderFile(e.copySection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("section is %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxBuildConstraints is the most distinct "// spv:build" constraints the
+// sources can have, as the manifest is written for every combination of them.
+const maxBuildConstraints = 4
+
+// buildExpr is a build constraint expression in the //go:build syntax.
+type buildExpr struct {
+	op   string // "tag", "!", "&&" or "||"
+	tag  string
+	args []*buildExpr
+}
+
+func (e *buildExpr) String() string {
+	switch e.op {
+	case "tag":
+		return e.tag
+	case "!":
+		return "!" + e.arg(0)
+	}
+	parts := make([]string, len(e.args))
+	for i := range e.args {
+		parts[i] = e.arg(i)
+	}
+	return strings.Join(parts, " "+e.op+" ")
+}
+
+// arg returns the i-th operand of e as a string, in parentheses where the
+// precedence of the operators needs them, the way gofmt writes //go:build
+// lines.
+func (e *buildExpr) arg(i int) string {
+	a := e.args[i]
+	if a.op != e.op && (a.op == "&&" || a.op == "||") {
+		return "(" + a.String() + ")"
+	}
+	return a.String()
+}
+
+// pushNot returns e, or its negation if not is set, with the negations moved
+// down to the tags.
+func (e *buildExpr) pushNot(not bool) *buildExpr {
+	switch e.op {
+	case "tag":
+		if not {
+			return &buildExpr{op: "!", args: []*buildExpr{e}}
+		}
+		return e
+	case "!":
+		return e.args[0].pushNot(!not)
+	}
+	op := e.op
+	if not {
+		op = map[string]string{"&&": "||", "||": "&&"}[op]
+	}
+	pushed := &buildExpr{op: op}
+	for _, a := range e.args {
+		pushed.args = append(pushed.args, a.pushNot(not))
+	}
+	return pushed
+}
+
+// split appends the operands of e, and of its operands in turn, that aren't
+// joined by op.
+func (e *buildExpr) split(list []*buildExpr, op string) []*buildExpr {
+	if e.op != op {
+		return append(list, e)
+	}
+	for _, a := range e.args {
+		list = a.split(list, op)
+	}
+	return list
+}
+
+// plusBuildLines returns e as "// +build" lines, for Go versions before 1.17.
+// They are the ones go/build/constraint.PlusBuildLines gives, which go vet
+// compares them against: a line for each operand of the top-level &&, or a
+// single line if none of them has an ||.
+func (e *buildExpr) plusBuildLines() ([]string, error) {
+	var split [][][]*buildExpr
+	maxOr := 0
+	for _, or := range e.pushNot(false).split(nil, "&&") {
+		var ands [][]*buildExpr
+		for _, and := range or.split(nil, "||") {
+			lits := and.split(nil, "&&")
+			for _, lit := range lits {
+				if lit.op != "tag" && lit.op != "!" {
+					return nil, fmt.Errorf("build constraint %q is too complex for the // +build lines Go 1.%d needs", e, goMinor)
+				}
+			}
+			ands = append(ands, lits)
+		}
+		if len(ands) > maxOr {
+			maxOr = len(ands)
+		}
+		split = append(split, ands)
+	}
+	if maxOr == 1 {
+		var lits []*buildExpr
+		for _, or := range split {
+			lits = append(lits, or[0]...)
+		}
+		split = [][][]*buildExpr{{lits}}
+	}
+
+	var lines []string
+	for _, or := range split {
+		line := "// +build"
+		for _, and := range or {
+			clause := make([]string, len(and))
+			for i, lit := range and {
+				clause[i] = lit.String()
+			}
+			line += " " + strings.Join(clause, ",")
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// tags adds the tags e refers to to set.
+func (e *buildExpr) tags(set map[string]bool) {
+	if e.op == "tag" {
+		set[e.tag] = true
+	}
+	for _, a := range e.args {
+		a.tags(set)
+	}
+}
+
+// eval reports whether e holds in a build with the tags set in tags.
+func (e *buildExpr) eval(tags map[string]bool) bool {
+	switch e.op {
+	case "tag":
+		return tags[e.tag]
+	case "!":
+		return !e.args[0].eval(tags)
+	case "&&":
+		for _, a := range e.args {
+			if !a.eval(tags) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, a := range e.args {
+		if a.eval(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// The GOOS and GOARCH values the go command knows, as in go/build, of which a
+// build has exactly one each. The GOOS tags of unixOS also set "unix", and
+// those of impliedOS another GOOS tag.
+var (
+	knownOS   = strings.Fields("aix android darwin dragonfly freebsd hurd illumos ios js linux nacl netbsd openbsd plan9 solaris wasip1 windows zos")
+	knownArch = strings.Fields("386 amd64 amd64p32 arm armbe arm64 arm64be loong64 mips mipsle mips64 mips64le mips64p32 mips64p32le ppc ppc64 ppc64le riscv riscv64 s390 s390x sparc sparc64 wasm")
+	unixOS    = strings.Fields("aix android darwin dragonfly freebsd hurd illumos ios linux netbsd openbsd solaris")
+	impliedOS = map[string]string{"android": "linux", "illumos": "solaris", "ios": "darwin"}
+)
+
+// maxFreeTags is the most tags other than GOOS and GOARCH ones that
+// satisfiable tries every combination of; expressions with more are assumed
+// to be satisfiable.
+const maxFreeTags = 8
+
+// satisfiable reports whether some build configuration satisfies e, knowing
+// that a build has a single GOOS and GOARCH. Other tags can be set in any
+// combination.
+func (e *buildExpr) satisfiable() bool {
+	set := make(map[string]bool)
+	e.tags(set)
+	isOS, isArch, isUnix := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	for _, goos := range knownOS {
+		isOS[goos] = true
+	}
+	for _, goarch := range knownArch {
+		isArch[goarch] = true
+	}
+	for _, goos := range unixOS {
+		isUnix[goos] = true
+	}
+
+	// Only try every GOOS or GOARCH if e refers to one; "" stands for any
+	oses, arches := []string{""}, []string{""}
+	var free []string
+	for tag := range set {
+		switch {
+		case isOS[tag] || tag == "unix":
+			oses = knownOS
+		case isArch[tag]:
+			arches = knownArch
+		default:
+			free = append(free, tag)
+		}
+	}
+	if len(free) > maxFreeTags {
+		return true
+	}
+
+	for _, goos := range oses {
+		for _, goarch := range arches {
+			for mask := 0; mask < 1<<len(free); mask++ {
+				tags := map[string]bool{goos: true, impliedOS[goos]: true, goarch: true, "unix": isUnix[goos]}
+				for i, tag := range free {
+					tags[tag] = mask&(1<<i) != 0
+				}
+				if e.eval(tags) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseBuildExpr parses a build constraint like "linux && !android".
+func parseBuildExpr(s string) (*buildExpr, error) {
+	p := &buildParser{s: s}
+	e, err := p.or()
+	if err == nil && p.next() != "" {
+		err = fmt.Errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid build constraint %q: %v", s, err)
+	}
+	return e, nil
+}
+
+type buildParser struct {
+	s   string
+	tok string // the token returned by the last next
+	put bool   // tok is to be returned again
+}
+
+// next returns the next token, or "" at the end.
+func (p *buildParser) next() string {
+	if p.put {
+		p.put = false
+		return p.tok
+	}
+	p.s = strings.TrimLeft(p.s, " \t")
+	n := 0
+	switch {
+	case p.s == "":
+	case strings.HasPrefix(p.s, "&&") || strings.HasPrefix(p.s, "||"):
+		n = 2
+	case strings.IndexByte("!()", p.s[0]) >= 0:
+		n = 1
+	default:
+		for n < len(p.s) && isTagByte(p.s[n]) {
+			n++
+		}
+		if n == 0 {
+			n = 1 // reported as unexpected
+		}
+	}
+	p.tok, p.s = p.s[:n], p.s[n:]
+	return p.tok
+}
+
+func isTagByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
+}
+
+func (p *buildParser) or() (*buildExpr, error) {
+	return p.binary("||", p.and)
+}
+
+func (p *buildParser) and() (*buildExpr, error) {
+	return p.binary("&&", p.not)
+}
+
+// binary parses operands joined by op, each parsed by operand.
+func (p *buildParser) binary(op string, operand func() (*buildExpr, error)) (*buildExpr, error) {
+	e, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == op {
+		a, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if e.op != op {
+			e = &buildExpr{op: op, args: []*buildExpr{e}}
+		}
+		e.args = append(e.args, a)
+	}
+	p.put = true
+	return e, nil
+}
+
+func (p *buildParser) not() (*buildExpr, error) {
+	switch tok := p.next(); {
+	case tok == "!":
+		a, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &buildExpr{op: "!", args: []*buildExpr{a}}, nil
+	case tok == "(":
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return e, nil
+	case tok == "":
+		return nil, errors.New("unexpected end")
+	case isTagByte(tok[0]):
+		return &buildExpr{op: "tag", tag: tok}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+}
+
+// sourceBuild returns the build constraint of the "// spv:build" directive of
+// the source file src, e.g. "// spv:build linux && !android", or nil if it has
+// none. Precompiled modules have none.
+func sourceBuild(src string) (*buildExpr, error) {
+	if isSPIRVFile(src) {
+		return nil, nil
+	}
+	directives, err := sourceDirectives(src)
+	if err != nil {
+		return nil, err
+	}
+	value, found := directives["build"]
+	if !found {
+		return nil, nil
+	}
+	if value == "" {
+		return nil, fmt.Errorf("%sbuild needs a build constraint, e.g. linux && !android", directivePrefix)
+	}
+	switch {
+	case bucketCount > 0:
+		return nil, fmt.Errorf("%sbuild can't be used with -bucket", directivePrefix)
+	case internal:
+		return nil, fmt.Errorf("%sbuild can't be used with -internal", directivePrefix)
+	}
+	return parseBuildExpr(value)
+}
+
+// writeBuildConstraint writes e as the build constraint of a generated file,
+// with "// +build" lines too for Go versions before 1.17, followed by the
+// blank line that has to separate it from the package clause.
+func writeBuildConstraint(w *bufio.Writer, e *buildExpr) error {
+	fmt.Fprintf(w, "//go:build %s\n", e)
+	if !goVersionAtLeast(17) {
+		lines, err := e.plusBuildLines()
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			w.WriteString(line + "\n")
+		}
+	}
+	w.WriteString("\n")
+	return nil
+}
+
+// manifestPart is one of the manifests written when sources have build
+// constraints: the one for the build configurations satisfying constraint,
+// listing the sources available in them.
+type manifestPart struct {
+	path       string
+	constraint *buildExpr // nil for the single manifest without constraints
+	sources    []string
+}
+
+// execute writes the manifest of the part into w, which is the manifest of
+// the sources of the part behind its build constraint, and behind Go 1.16 if
+// it embeds modules.
+func (part manifestPart) execute(w *bufio.Writer) error {
+	if part.constraint != nil {
+		all := filesTotal
+		filesTotal = part.sources
+		defer func() { filesTotal = all }()
+	}
+	if c := requireGo(part.constraint, anyEmbedded(), embedMinor); c != nil {
+		if err := writeBuildConstraint(w, c); err != nil {
+			return err
+		}
+	}
+	return executeManifest(w)
+}
+
+// manifestParts returns the manifests to write for filesTotal. Without build
+// constraints it is the single manifest of all sources. Otherwise there is a
+// manifest for every combination of the distinct constraints holding or not,
+// each listing the sources without a constraint and those whose constraint
+// holds in it, so that every build configuration has exactly one manifest
+// referring to exactly the shaders built for it. The one for none of them
+// holding is the usual manifest file, which is always written. Combinations
+// that no build configuration satisfies, e.g. with two GOOS tags, get none.
+func manifestParts() ([]manifestPart, error) {
+	constraints := make(map[string]*buildExpr)
+	bySource := make(map[string]string)
+	for _, src := range filesTotal {
+		e, err := sourceBuild(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", src, err)
+		}
+		if e != nil {
+			constraints[e.String()] = e
+			bySource[src] = e.String()
+		}
+	}
+	if len(constraints) == 0 {
+		return []manifestPart{{path: manifestPath(), sources: filesTotal}}, nil
+	}
+	if len(constraints) > maxBuildConstraints {
+		return nil, fmt.Errorf("the sources have %d distinct %sbuild constraints, but at most %d are supported",
+			len(constraints), directivePrefix, maxBuildConstraints)
+	}
+
+	var names []string
+	for name := range constraints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []manifestPart
+	for mask := 0; mask < 1<<len(names); mask++ {
+		holds := make(map[string]bool)
+		all := &buildExpr{op: "&&"}
+		for i, name := range names {
+			e := constraints[name]
+			if holds[name] = mask&(1<<i) != 0; !holds[name] {
+				e = &buildExpr{op: "!", args: []*buildExpr{e}}
+			}
+			all.args = append(all.args, e)
+		}
+		if len(all.args) == 1 {
+			all = all.args[0]
+		}
+		if mask != 0 && !all.satisfiable() {
+			continue
+		}
+		part := manifestPart{path: taggedManifestPath(mask), constraint: all}
+		for _, src := range filesTotal {
+			if c, found := bySource[src]; !found || holds[c] {
+				part.sources = append(part.sources, src)
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// taggedManifestPath returns the path of the manifest for the combination of
+// build constraints given by mask; see manifestParts.
+func taggedManifestPath(mask int) string {
+	if mask == 0 {
+		return manifestPath()
+	}
+	return path.Join(outputDir(), fmt.Sprintf("%s_tags%d%s", strings.TrimSuffix(manifestFilename, genExtension), mask, genExtension))
+}
+
+// isTaggedManifest returns true if name is the name of a manifest for build
+// constraints other than the usual manifest file.
+func isTaggedManifest(name string) bool {
+	base := strings.TrimSuffix(manifestFilename, genExtension) + "_tags"
+	if !strings.HasPrefix(name, base) || !strings.HasSuffix(name, genExtension) {
+		return false
+	}
+	digits := strings.TrimSuffix(strings.TrimPrefix(name, base), genExtension)
+	if digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// removeTaggedManifests removes the manifests for build constraints generated
+// earlier that aren't in keep, e.g. after a constraint was removed.
+func removeTaggedManifests(keep []manifestPart) error {
+	fs, err := ioutil.ReadDir(outputDir())
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	for _, p := range keep {
+		kept[p.path] = true
+	}
+	for _, f := range fs {
+		name := path.Join(outputDir(), f.Name())
+		if f.IsDir() || !isTaggedManifest(f.Name()) || kept[name] || !hasGeneratedHeader(name) {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+		if verbosity >= 1 {
+			fmt.Printf("%s: removed %s\n", os.Args[0], name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBuildConstraint(t *testing.T) {
+	tests := []struct {
+		in, goBuild, plusBuild string
+	}{
+		{"linux", "linux", "// +build linux"},
+		{"linux&&amd64", "linux && amd64", "// +build linux,amd64"},
+		{"linux || darwin", "linux || darwin", "// +build linux darwin"},
+		{"!linux && (amd64 || arm64)", "!linux && (amd64 || arm64)", "// +build !linux\n// +build amd64 arm64"},
+		{"a || b && c", "a || (b && c)", "// +build a b,c"},
+		{"(a && b) && c", "a && b && c", "// +build a,b,c"},
+		{"!(linux || darwin)", "!(linux || darwin)", "// +build !linux,!darwin"},
+		{"!(!linux && (amd64 || arm64)) && !linux", "!(!linux && (amd64 || arm64)) && !linux", "// +build linux !amd64,!arm64\n// +build !linux"},
+		{"go1.17 && !purego", "go1.17 && !purego", "// +build go1.17,!purego"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			e, err := parseBuildExpr(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.String(); got != tt.goBuild {
+				t.Errorf("//go:build %s, want %s", got, tt.goBuild)
+			}
+			lines, err := e.plusBuildLines()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(lines, "\n"); got != tt.plusBuild {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.plusBuild)
+			}
+		})
+	}
+}
+
+func TestBuildConstraintErrors(t *testing.T) {
+	for _, in := range []string{"", "linux &&", "(linux", "linux)", "linux darwin", "linux & amd64", "!"} {
+		if e, err := parseBuildExpr(in); err == nil {
+			t.Errorf("%q parsed as %s", in, e)
+		}
+	}
+	e, err := parseBuildExpr("(a || b) && c || d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines, err := e.plusBuildLines(); err == nil {
+		t.Errorf("(a || b) && c || d gave +build lines %q", lines)
+	}
+}
+
+func TestBuildConstraintSatisfiable(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"linux", true},
+		{"linux && !linux", false},
+		{"!linux && amd64 && linux", false},
+		{"!(!linux && amd64) && !linux", true},
+		{"linux && windows", false},
+		{"linux || windows", true},
+		{"android && !linux", false},
+		{"ios && darwin && unix", true},
+		{"windows && unix", false},
+		{"amd64 && arm64", false},
+		{"linux && arm64 && cgo && !purego", true},
+		{"(a || b) && !a && !b", false},
+	}
+	for _, tt := range tests {
+		e, err := parseBuildExpr(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.satisfiable(); got != tt.want {
+			t.Errorf("%s: satisfiable %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestManifestPartsSkipImpossible checks that no manifest is written for the
+// combination of a source's constraint holding together with a contradicting
+// one of another source.
+func TestManifestPartsSkipImpossible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.frag": "// spv:build linux\nvoid main() {}\n",
+		"b.frag": "// spv:build !linux && amd64\nvoid main() {}\n",
+		"c.frag": "void main() {}\n",
+	})
+	if code := runSPV(t, dir); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	want := "a.frag a.frag.gen.go b.frag b.frag.gen.go c.frag c.frag.gen.go shaders.gen.go shaders_tags1.gen.go shaders_tags2.gen.go"
+	if got := strings.Join(listFiles(t, dir), " "); got != want {
+		t.Errorf("files %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"path"
+	"strings"
+)
+
+// defaultByteType is the type of the binary data with -as fs and -as blob
+// unless -byte-type names another one.
+const defaultByteType = "[]byte"
+
+// byteType is the -byte-type value, e.g. "github.com/user/vk.ShaderCode".
+var byteType string
+
+// byteTypeName and byteTypeImport are byteType as written in the generated
+// code, e.g. "vk.ShaderCode", and the import path of its package, which is
+// empty for []byte and types declared in the generated package itself.
+var byteTypeName, byteTypeImport string
+
+// parseByteType splits byteType into byteTypeName and byteTypeImport. The type
+// has to be one that []byte converts to, which the Go compiler checks when the
+// generated package is built.
+func parseByteType() error {
+	byteTypeName, byteTypeImport = byteType, ""
+	if byteType == defaultByteType {
+		return nil
+	}
+	if !byteOutput() {
+		return errors.New("-byte-type needs -as fs or -as blob, the output modes with byte data")
+	}
+
+	name := byteType
+	if i := strings.LastIndexByte(byteType, '.'); i > strings.LastIndexByte(byteType, '/') {
+		byteTypeImport, name = byteType[:i], byteType[i+1:]
+	}
+	if !token.IsIdentifier(name) {
+		return fmt.Errorf("invalid -byte-type %q; expected a type like ShaderCode or example.com/vk.ShaderCode", byteType)
+	}
+	if byteTypeImport == "" {
+		return nil
+	}
+	pkgName := path.Base(byteTypeImport)
+	if !token.IsIdentifier(pkgName) {
+		return fmt.Errorf("-byte-type %q: the package name %q is not an identifier", byteType, pkgName)
+	}
+	byteTypeName = pkgName + "." + name
+	return nil
+}
+
+// byteOutput reports whether the output mode has byte data, which -byte-type
+// applies to.
+func byteOutput() bool {
+	return outputMode == "fs" || outputMode == "blob"
+}
+
+// dataType returns the Go type of the binary data in the generated files.
+func dataType() string {
+	if byteOutput() {
+		return byteTypeName
+	}
+	return outputModes[outputMode]
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// optimizer is the SPIRV-Tools optimizer used by -canonicalize.
+const optimizer = "spirv-opt"
+
+// canonicalizeArgs are the passes that normalize a module: debug info such as
+// names and source lines is dropped, and IDs are renumbered by the structure of
+// the module rather than the order the compiler happened to assign them in.
+var canonicalizeArgs = []string{"--strip-debug", "--canonicalize-ids"}
+
+// canonicalize runs the canonicalization passes over the module spvFile and
+// returns the path of the normalized module.
+func canonicalize(ctx context.Context, spvFile string, statusChan chan status) (string, error) {
+	out := strings.TrimSuffix(spvFile, ".spv") + "_canonical.spv"
+	args := append(append([]string{}, canonicalizeArgs...), "-o", out, spvFile)
+	statusChan <- status{2, commandLine(optimizer, args), false, ""}
+	output, err := exec.CommandContext(ctx, optimizer, args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return "", errInterrupted
+	}
+	if err != nil {
+		if len(output) > 0 {
+			return "", fmt.Errorf("%s failed:\n%s", optimizer, output)
+		}
+		return "", fmt.Errorf("%s failed: %v", optimizer, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import "strconv"
+
+const opCapability = 17
+
+// capabilityNames maps SPIR-V capabilities to their names in the
+// specification, which are also used by the Vulkan and SPIRV-Tools APIs.
+var capabilityNames = map[uint32]string{
+	0:    "Matrix",
+	1:    "Shader",
+	2:    "Geometry",
+	3:    "Tessellation",
+	4:    "Addresses",
+	5:    "Linkage",
+	6:    "Kernel",
+	7:    "Vector16",
+	8:    "Float16Buffer",
+	9:    "Float16",
+	10:   "Float64",
+	11:   "Int64",
+	12:   "Int64Atomics",
+	13:   "ImageBasic",
+	14:   "ImageReadWrite",
+	15:   "ImageMipmap",
+	17:   "Pipes",
+	18:   "Groups",
+	19:   "DeviceEnqueue",
+	20:   "LiteralSampler",
+	21:   "AtomicStorage",
+	22:   "Int16",
+	23:   "TessellationPointSize",
+	24:   "GeometryPointSize",
+	25:   "ImageGatherExtended",
+	27:   "StorageImageMultisample",
+	28:   "UniformBufferArrayDynamicIndexing",
+	29:   "SampledImageArrayDynamicIndexing",
+	30:   "StorageBufferArrayDynamicIndexing",
+	31:   "StorageImageArrayDynamicIndexing",
+	32:   "ClipDistance",
+	33:   "CullDistance",
+	34:   "ImageCubeArray",
+	35:   "SampleRateShading",
+	36:   "ImageRect",
+	37:   "SampledRect",
+	38:   "GenericPointer",
+	39:   "Int8",
+	40:   "InputAttachment",
+	41:   "SparseResidency",
+	42:   "MinLod",
+	43:   "Sampled1D",
+	44:   "Image1D",
+	45:   "SampledCubeArray",
+	46:   "SampledBuffer",
+	47:   "ImageBuffer",
+	48:   "ImageMSArray",
+	49:   "StorageImageExtendedFormats",
+	50:   "ImageQuery",
+	51:   "DerivativeControl",
+	52:   "InterpolationFunction",
+	53:   "TransformFeedback",
+	54:   "GeometryStreams",
+	55:   "StorageImageReadWithoutFormat",
+	56:   "StorageImageWriteWithoutFormat",
+	57:   "MultiViewport",
+	58:   "SubgroupDispatch",
+	59:   "NamedBarrier",
+	60:   "PipeStorage",
+	61:   "GroupNonUniform",
+	62:   "GroupNonUniformVote",
+	63:   "GroupNonUniformArithmetic",
+	64:   "GroupNonUniformBallot",
+	65:   "GroupNonUniformShuffle",
+	66:   "GroupNonUniformShuffleRelative",
+	67:   "GroupNonUniformClustered",
+	68:   "GroupNonUniformQuad",
+	69:   "ShaderLayer",
+	70:   "ShaderViewportIndex",
+	71:   "UniformDecoration",
+	4422: "FragmentShadingRateKHR",
+	4423: "SubgroupBallotKHR",
+	4427: "DrawParameters",
+	4431: "SubgroupVoteKHR",
+	4433: "StorageBuffer16BitAccess",
+	4434: "UniformAndStorageBuffer16BitAccess",
+	4435: "StoragePushConstant16",
+	4436: "StorageInputOutput16",
+	4437: "DeviceGroup",
+	4439: "MultiView",
+	4441: "VariablePointersStorageBuffer",
+	4442: "VariablePointers",
+	4445: "AtomicStorageOps",
+	4447: "SampleMaskPostDepthCoverage",
+	4448: "StorageBuffer8BitAccess",
+	4449: "UniformAndStorageBuffer8BitAccess",
+	4450: "StoragePushConstant8",
+	4464: "DenormPreserve",
+	4465: "DenormFlushToZero",
+	4466: "SignedZeroInfNanPreserve",
+	4467: "RoundingModeRTE",
+	4468: "RoundingModeRTZ",
+	4471: "RayQueryProvisionalKHR",
+	4472: "RayQueryKHR",
+	4478: "RayTraversalPrimitiveCullingKHR",
+	4479: "RayTracingKHR",
+	5008: "Float16ImageAMD",
+	5009: "ImageGatherBiasLodAMD",
+	5010: "FragmentMaskAMD",
+	5013: "StencilExportEXT",
+	5015: "ImageReadWriteLodAMD",
+	5016: "Int64ImageEXT",
+	5055: "ShaderClockKHR",
+	5249: "SampleMaskOverrideCoverageNV",
+	5251: "GeometryShaderPassthroughNV",
+	5254: "ShaderViewportIndexLayerEXT",
+	5255: "ShaderViewportMaskNV",
+	5259: "ShaderStereoViewNV",
+	5260: "PerViewAttributesNV",
+	5265: "FragmentFullyCoveredEXT",
+	5266: "MeshShadingNV",
+	5282: "ImageFootprintNV",
+	5283: "MeshShadingEXT",
+	5284: "FragmentBarycentricKHR",
+	5288: "ComputeDerivativeGroupQuadsNV",
+	5291: "FragmentDensityEXT",
+	5297: "GroupNonUniformPartitionedNV",
+	5301: "ShaderNonUniform",
+	5302: "RuntimeDescriptorArray",
+	5303: "InputAttachmentArrayDynamicIndexing",
+	5304: "UniformTexelBufferArrayDynamicIndexing",
+	5305: "StorageTexelBufferArrayDynamicIndexing",
+	5306: "UniformBufferArrayNonUniformIndexing",
+	5307: "SampledImageArrayNonUniformIndexing",
+	5308: "StorageBufferArrayNonUniformIndexing",
+	5309: "StorageImageArrayNonUniformIndexing",
+	5310: "InputAttachmentArrayNonUniformIndexing",
+	5311: "UniformTexelBufferArrayNonUniformIndexing",
+	5312: "StorageTexelBufferArrayNonUniformIndexing",
+	5340: "RayTracingNV",
+	5345: "VulkanMemoryModel",
+	5346: "VulkanMemoryModelDeviceScope",
+	5347: "PhysicalStorageBufferAddresses",
+	5350: "ComputeDerivativeGroupLinearNV",
+	5353: "RayTracingProvisionalKHR",
+	5357: "CooperativeMatrixNV",
+	5363: "FragmentShaderSampleInterlockEXT",
+	5372: "FragmentShaderShadingRateInterlockEXT",
+	5373: "ShaderSMBuiltinsNV",
+	5378: "FragmentShaderPixelInterlockEXT",
+	5379: "DemoteToHelperInvocation",
+	6016: "DotProductInputAllKHR",
+	6017: "DotProductInput4x8BitKHR",
+	6018: "DotProductInput4x8BitPackedKHR",
+	6019: "DotProductKHR",
+	6033: "AtomicFloat32AddEXT",
+	6034: "AtomicFloat64AddEXT",
+}
+
+// capabilityName returns the name of a capability, or a placeholder with its
+// number for those newer than this table.
+func capabilityName(c uint32) string {
+	if name, found := capabilityNames[c]; found {
+		return name
+	}
+	return "Capability" + strconv.FormatUint(uint64(c), 10)
+}
+
+// capabilities returns the names of the capabilities declared by the module
+// in declaration order.
+func (m *spirvModule) capabilities() []string {
+	var caps []string
+	for _, in := range m.instrs {
+		if in.opcode == opCapability && len(in.operands) > 0 {
+			caps = append(caps, capabilityName(in.operands[0]))
+		}
+	}
+	return caps
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// ccTemplateData is what -cc-template placeholders are rendered from.
+type ccTemplateData struct {
+	Input  string // path of the source file
+	Output string // path of the SPIR-V file to write
+	Stage  string // stage name as in the Stage constants, e.g. Fragment
+
+	// Defines and Includes are the -D and -I arguments from -args and the
+	// config's stage_args. A word consisting of just one of them expands to
+	// one argument per element.
+	Defines  []string
+	Includes []string
+}
+
+// ccTemplateWords holds the parsed words of -cc-template, the first being
+// the compiler.
+var ccTemplateWords []*template.Template
+
+const (
+	sentinelInput  = "\x00input\x00"
+	sentinelOutput = "\x00output\x00"
+)
+
+// parseCCTemplate parses -cc-template and sets cc to the compiler it runs.
+// The template is split into words before rendering, so placeholders never
+// split or join arguments.
+func parseCCTemplate() error {
+	ccTemplateWords = nil
+	words := strings.Fields(ccTemplate)
+	if len(words) == 0 {
+		return errors.New("-cc-template is empty")
+	}
+	if strings.Contains(words[0], "{{") {
+		return errors.New("-cc-template must start with the compiler, not a placeholder")
+	}
+	for _, w := range words {
+		t, err := template.New("cc").Option("missingkey=error").Parse(w)
+		if err != nil {
+			return fmt.Errorf("-cc-template: %v", err)
+		}
+		ccTemplateWords = append(ccTemplateWords, t)
+	}
+
+	args, err := renderCCTemplate(ccTemplateData{Input: sentinelInput, Output: sentinelOutput})
+	if err != nil {
+		return fmt.Errorf("-cc-template: %v", err)
+	}
+	var hasInput, hasOutput bool
+	for _, a := range args {
+		hasInput = hasInput || strings.Contains(a, sentinelInput)
+		hasOutput = hasOutput || strings.Contains(a, sentinelOutput)
+	}
+	if !hasInput || !hasOutput {
+		return errors.New("-cc-template must contain both {{.Input}} and {{.Output}}")
+	}
+
+	cc = words[0]
+	return nil
+}
+
+// renderCCTemplate returns the compiler arguments, without the compiler, for
+// data.
+func renderCCTemplate(data ccTemplateData) ([]string, error) {
+	var args []string
+	for _, t := range ccTemplateWords[1:] {
+		switch t.Root.String() {
+		case "{{.Defines}}":
+			args = append(args, data.Defines...)
+			continue
+		case "{{.Includes}}":
+			args = append(args, data.Includes...)
+			continue
+		}
+		var sb strings.Builder
+		if err := t.Execute(&sb, data); err != nil {
+			return nil, err
+		}
+		args = append(args, sb.String())
+	}
+	return args, nil
+}
+
+// templateArgs returns the arguments for compiling the source file src into
+// the SPIR-V file out with -cc-template. The define of a variant is among the
+// defines.
+func templateArgs(src, out string, t target) ([]string, error) {
+	data := ccTemplateData{
+		Input:  compilerInput(src),
+		Output: out,
+		Stage:  stageOf(src),
+	}
+	args := append(strings.Fields(ccArgs), stageArgs(src)...)
+	for i, a := range args {
+		switch {
+		case strings.HasPrefix(a, "-D") && len(a) > 2:
+			data.Defines = append(data.Defines, a)
+		case a == "-D" && i+1 < len(args):
+			data.Defines = append(data.Defines, "-D"+args[i+1])
+		case strings.HasPrefix(a, "-I") && len(a) > 2:
+			data.Includes = append(data.Includes, a)
+		case a == "-I" && i+1 < len(args):
+			data.Includes = append(data.Includes, "-I"+args[i+1])
+		}
+	}
+	if t.variant != "" {
+		data.Defines = append(data.Defines, variantDefine(t.variant))
+	}
+	if isOverlaid(src) {
+		data.Includes = append(data.Includes, "-I"+filepath.Dir(src))
+	}
+	return renderCCTemplate(data)
+}
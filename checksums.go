@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+)
+
+// checksums is the -checksums flag. Every generated file gets the SHA-256 of
+// its module, which the manifest collects into ShaderChecksums.
+var checksums bool
+
+// writeChecksum writes the SHA-256 of the module compiled from source, taken
+// over its little-endian bytes: the contents of the embedded file with -as
+// fs, the bytes of the string with -as string, and the words as Vulkan reads
+// them from memory otherwise.
+func writeChecksum(outFile *bufio.Writer, source string, words []uint32) {
+	sum := sha256.Sum256(spirvBytes(words))
+	fmt.Fprintf(outFile, "\nvar %sChecksum = [%d]byte{", makeIdentifier(source), sha256.Size)
+	for i, c := range sum {
+		if i%16 == 0 {
+			outFile.WriteString("\n\t")
+		} else {
+			outFile.WriteByte(' ')
+		}
+		fmt.Fprintf(outFile, "0x%02x,", c)
+	}
+	outFile.WriteString("\n}\n")
+}
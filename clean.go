@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// cleanGenerated removes the generated files from the source directory, and
+// its subdirectories with -recursive. Files without the generated code
+// comment are left alone.
+func cleanGenerated() int {
+	var removed int
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		fs, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range fs {
+			filename := path.Join(dir, f.Name())
+			if f.IsDir() {
+				if recursive && !strings.HasPrefix(f.Name(), ".") && !isEmbedDir(filename) {
+					if err := walk(filename); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if f.Name() != manifestFilename && f.Name() != testFilename && f.Name() != blobFilename && !isTaggedManifest(f.Name()) && !isGeneratedFromGLSL(f.Name()) {
+				continue
+			}
+
+			if !hasGeneratedHeader(filename) {
+				fmt.Printf("%s: keeping %s, it was not generated by spv\n", os.Args[0], filename)
+				continue
+			}
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+			removed++
+			fmt.Printf("%s: removed %s\n", os.Args[0], filename)
+		}
+		return nil
+	}
+
+	if err := walk("."); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	if internal && !recursive {
+		if err := walk(outputDir()); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+	}
+	if err := pruneEmbedded(true); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	if removed == 0 && verbosity >= 1 {
+		fmt.Printf("%s: nothing to clean\n", os.Args[0])
+	}
+	return 0
+}
+
+// hasGeneratedHeader returns true if the file has the generated code comment
+// before its package clause.
+func hasGeneratedHeader(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == genComment {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+	}
+	return false
+}
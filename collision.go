@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// collisionPolicy is the -collision policy for sources that map to the same
+// identifier: "error" fails the run, "suffix" numbers all but the first of
+// them, e.g. FooFrag and FooFrag2.
+var collisionPolicy string
+
+// suffixedIdentifiers maps the sources renamed by the "suffix" policy to their
+// identifiers.
+var suffixedIdentifiers = make(map[string]string)
+
+// suffixIdentifiers gives every source but the first in each group of byIdent
+// a numbered identifier that no other source uses.
+func suffixIdentifiers(byIdent map[string][]string) {
+	suffixedIdentifiers = make(map[string]string)
+	var ids []string
+	for id, srcs := range byIdent {
+		if len(srcs) > 1 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		n := 2
+		for _, src := range byIdent[id][1:] {
+			for {
+				candidate := id + strconv.Itoa(n)
+				n++
+				if _, taken := byIdent[candidate]; !taken {
+					byIdent[candidate] = []string{src}
+					suffixedIdentifiers[src] = candidate
+					break
+				}
+			}
+		}
+	}
+}
+
+// identifierChanged returns true if the file gen was generated from src under
+// another identifier than the one it has now, e.g. because a source added to
+// its group of colliding sources took the identifier, or the other sources in
+// the group are gone.
+func identifierChanged(src, gen string) bool {
+	m, err := readSourceMeta(src, gen)
+	return err != nil || m.Identifier != suffixedIdentifiers[src]
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// cacheDir is the -cache directory compiled modules are kept in, to be reused
+// by later runs, or "" for none. It is made absolute by finishArgs.
+var cacheDir string
+
+// warmCache is the -warm-cache flag: compile every source into cacheDir and
+// exit, writing nothing else.
+var warmCache bool
+
+// cacheStats counts the modules taken from and written to the cache in this
+// run.
+var cacheStats struct {
+	hits, written, bytes int64
+}
+
+// cacheKey returns the key of the module compiled from src for t. It covers
+// the source and its includes (see includeScanner.hash), the compiler
+// arguments and the version and options of the compiler, but not the flags
+// that only change what is done with the module, so that e.g. adding -cross
+// still finds it.
+func cacheKey(src string, t target) (string, error) {
+	// The compiler's version and options; see currentLock
+	lock, err := currentLock()
+	if err != nil {
+		return "", err
+	}
+	hash, err := includes.hash(src)
+	if err != nil {
+		return "", err
+	}
+	args := t.args(src, "")
+	args = args[:len(args)-3] // -o out src
+	h := sha256.New()
+	fmt.Fprintf(h, "spv module 2\x00%s\x00%s\x00%s\x00%s\x00%q\x00%t\x00%s", lock.Version+"\x00"+lock.HelpSHA256, hash, cc, ccTemplate, args, canonical, preludeHash)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachedModule returns the path of the cached module for key and the
+// warnings of compiling it, if there is one.
+func cachedModule(key string) (string, []string, bool) {
+	name := filepath.Join(cacheDir, key[:2], key+".spv")
+	if _, err := os.Stat(name); err != nil {
+		return "", nil, false
+	}
+	var warnings []string
+	data, err := ioutil.ReadFile(filepath.Join(cacheDir, key[:2], key+".warnings"))
+	switch {
+	case err == nil:
+		warnings = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	case !os.IsNotExist(err):
+		return "", nil, false // compiled again rather than losing its warnings
+	}
+	atomic.AddInt64(&cacheStats.hits, 1)
+	return name, warnings, true
+}
+
+// cacheModule copies the module spvFile into the cache under key, along with
+// the warnings of compiling it, if any. The files are renamed into place, the
+// module last, so that runs sharing the cache never see part of one or a
+// module without its warnings.
+func cacheModule(key, spvFile string, warnings []string) error {
+	dir := filepath.Join(cacheDir, key[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		text := strings.NewReader(strings.Join(warnings, "\n") + "\n")
+		if _, err := cacheFile(filepath.Join(dir, key+".warnings"), text); err != nil {
+			return err
+		}
+	}
+	in, err := os.Open(spvFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	n, err := cacheFile(filepath.Join(dir, key+".spv"), in)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&cacheStats.written, 1)
+	atomic.AddInt64(&cacheStats.bytes, n)
+	return nil
+}
+
+// cacheFile writes the contents of r into a temporary file in the directory of
+// name and renames it over name, returning the number of bytes written. The
+// entry gets 0644 like other files, so that a cache shared between users stays
+// readable.
+func cacheFile(name string, r io.Reader) (int64, error) {
+	out, err := ioutil.TempFile(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), name)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+// buildCached is buildModule going through the cache, if there is one. A
+// module taken from the cache comes with the warnings of compiling it, so that
+// -Werror and -strict-extensions see the same as without the cache. Failing to write the cache only costs a warning, as the module is fine.
+func buildCached(ctx context.Context, f string, t target, statusChan chan status) (string, []string, error) {
+	if cacheDir == "" {
+		return buildModule(ctx, f, t, statusChan)
+	}
+	key, err := cacheKey(f, t)
+	if err != nil {
+		return "", nil, err
+	}
+	if spvFile, warnings, found := cachedModule(key); found {
+		statusChan <- status{2, fmt.Sprintf("%s: using the cached module %s", f, spvFile), false, f}
+		return spvFile, warnings, nil
+	}
+	spvFile, warnings, err := buildModule(ctx, f, t, statusChan)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := cacheModule(key, spvFile, warnings); err != nil {
+		statusChan <- status{0, fmt.Sprintf("%s warning: Cannot cache the module of %s: %v", os.Args[0], f, err), false, f}
+	}
+	return spvFile, warnings, nil
+}
+
+// warmSource compiles the source f for the -warm-cache mode, for each of the
+// -multi-target environments or its variants too.
+func warmSource(ctx context.Context, f string, statusChan chan status) ([]string, error) {
+	targets, err := sourceTargets(f)
+	if err != nil {
+		return nil, err
+	}
+	_, warnings, err := buildCached(ctx, f, target{}, statusChan)
+	for _, t := range targets {
+		if err != nil {
+			break
+		}
+		var w []string
+		if _, w, err = buildCached(ctx, f, t, statusChan); err != nil && err != errInterrupted {
+			err = fmt.Errorf("for %s: %v", t, err)
+		}
+		warnings = addTargetWarnings(warnings, t, w)
+	}
+	return warnings, err
+}
+
+// warmCompileCache compiles every GLSL source found by getFiles into the
+// cache, e.g. once when setting up CI, and reports what it added.
+func warmCompileCache() int {
+	if c := compileAll("cached", warmSource); c != 0 {
+		return c
+	}
+	fmt.Printf("%s: cached %d modules (%d bytes) in %s; %d were cached already\n",
+		os.Args[0], cacheStats.written, cacheStats.bytes, cacheDir, cacheStats.hits)
+	return 0
+}
+
+// checkCacheArgs returns an error if -warm-cache is given without a cache or
+// with a flag that doesn't compile.
+func checkCacheArgs() error {
+	if cacheDir != "" {
+		abs, err := filepath.Abs(cacheDir)
+		if err != nil {
+			return err
+		}
+		cacheDir = abs
+	}
+	if !warmCache {
+		return nil
+	}
+	switch {
+	case cacheDir == "":
+		return errors.New("-warm-cache needs a -cache directory")
+	case verifyMode || syntaxOnly || manifestOnly || migrateSpec != "":
+		return errors.New("-warm-cache can't be used with -verify, -syntax-only, -manifest-only or -migrate")
+	case watchMode || serveAddr != "":
+		return errors.New("-warm-cache can't be used with -watch or -serve")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// strictCompiler makes the compiler recorded in the generated files cover its
+// binary as well as its --version output, to notice builds of the compiler
+// that report the same version.
+var strictCompiler bool
+
+// compilerHashes caches compilerHash by compiler (see compilerKey) and
+// -strict-compiler, as config targets can use different ones.
+var compilerHashes = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// compilerHash returns a hash identifying the installed compiler, which is
+// recorded in the files generated from GLSL so that upgrading the compiler
+// regenerates them. It hashes the output of --version, which takes a single
+// run of the compiler, and with -strict-compiler the contents of the binary
+// too. It is "" if the compiler can't be run, which compiling reports.
+func compilerHash() string {
+	key := compilerKey()
+	if strictCompiler {
+		key += "\x00strict"
+	}
+	compilerHashes.Lock()
+	defer compilerHashes.Unlock()
+	if id, found := compilerHashes.m[key]; found {
+		return id
+	}
+
+	var id string
+	if version, err := compilerOutput("--version"); err == nil {
+		h := sha256.New()
+		io.WriteString(h, version)
+		if !strictCompiler || hashBinary(h) == nil {
+			id = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	compilerHashes.m[key] = id
+	return id
+}
+
+// hashBinary writes the contents of the compiler binary to w.
+func hashBinary(w io.Writer) error {
+	path, err := exec.LookPath(cc)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// compilerChanged returns true if the file gen was generated from src by
+// another compiler than the installed one, or by an older version that didn't
+// record it.
+func compilerChanged(src, gen string) bool {
+	id := compilerHash()
+	if id == "" {
+		return false
+	}
+	m, err := readSourceMeta(src, gen)
+	return err != nil || m.Compiler != id
+}
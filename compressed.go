@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+)
+
+// compressedModule returns the module as -as compressed stores it: base64 of
+// its DEFLATE compressed bytes, for a single short line in the generated
+// file. The manifest's decodeModule reverses it.
+func compressedModule(words []uint32) string {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression) // only fails for invalid levels
+	w.Write(spirvBytes(words))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// decompressModule reads back a module stored by compressedModule.
+func decompressModule(s string) ([]uint32, error) {
+	b, err := decodeModuleBytes(s)
+	if err != nil {
+		return nil, err
+	}
+	return readSPIRV(bytes.NewReader(b))
+}
+
+// decodeModuleBytes decodes s the same way as the manifest's decodeModule,
+// which is what Module.Bytes costs at runtime on its first call.
+func decodeModuleBytes(s string) ([]byte, error) {
+	return ioutil.ReadAll(flate.NewReader(base64.NewDecoder(base64.StdEncoding, strings.NewReader(s))))
+}
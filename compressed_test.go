@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// testModule returns a module of n words after a SPIR-V header, with as
+// much repetition as real modules have when compressible is set, and random
+// words otherwise.
+func testModule(n int, compressible bool) []uint32 {
+	words := []uint32{spirvMagic, 0x00010000, 0x00080001, 10, 0}
+	r := rand.New(rand.NewSource(int64(n)))
+	for len(words) < n {
+		if compressible {
+			words = append(words, uint32(0x0004003b+len(words)%7), uint32(len(words)%32), 0x7, uint32(len(words)))
+		} else {
+			words = append(words, r.Uint32())
+		}
+	}
+	return words[:n]
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []uint32
+	}{
+		{"header only", testModule(5, false)},
+		{"small", testModule(100, true)},
+		{"random", testModule(1000, false)},
+		{"large", testModule(1<<18, true)},
+		{"all bytes", func() []uint32 {
+			words := testModule(5, false)
+			for i := 0; i < 256; i += 4 {
+				words = append(words, uint32(i)|uint32(i+1)<<8|uint32(i+2)<<16|uint32(i+3)<<24)
+			}
+			return words
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := compressedModule(tt.words)
+			b, err := decodeModuleBytes(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := spirvBytes(tt.words); !bytes.Equal(b, want) {
+				t.Errorf("decoded %d bytes differing from the %d of the module", len(b), len(want))
+			}
+			words, err := decompressModule(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(spirvBytes(words), spirvBytes(tt.words)) {
+				t.Errorf("decompressModule gave back %d words, want the %d of the module", len(words), len(tt.words))
+			}
+		})
+	}
+}
+
+// BenchmarkDecodeModule measures what the first call of Module.Bytes costs
+// at runtime with -as compressed, for modules of several sizes.
+func BenchmarkDecodeModule(b *testing.B) {
+	for _, n := range []int{256, 4096, 65536} {
+		words := testModule(n, true)
+		s := compressedModule(words)
+		b.Run(fmt.Sprintf("%dwords", n), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(4 * n))
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeModuleBytes(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
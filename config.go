@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// spvConfig is the contents of the -config file.
+type spvConfig struct {
+	// Targets are packages to generate in a single run. Each one maps flag
+	// names to values that override the command line for that package.
+	Targets []map[string]interface{} `json:"targets"`
+
+	// StageArgs maps stage names, or names of groups of stages, to compiler
+	// arguments added after -args for sources of those stages.
+	StageArgs map[string]string `json:"stage_args"`
+
+	dir  string // directory containing the config file
+	path string // absolute path of the config file
+}
+
+var config spvConfig
+
+// jobSlots limits the compilers run at once by all the config targets to the
+// -jobs of the command line, on top of the -jobs of each target. It is nil
+// without targets.
+var jobSlots chan e
+
+func loadConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	config.dir = filepath.Dir(abs)
+	config.path = abs
+
+	for key := range config.StageArgs {
+		if _, found := stageGroups[key]; !found && !isStage(key) {
+			return fmt.Errorf("stage_args: unknown stage %q", key)
+		}
+	}
+
+	for i, t := range config.Targets {
+		for name := range t {
+			if flag.Lookup(name) == nil {
+				return fmt.Errorf("target %d: unknown flag %q", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// stageArgs returns the compiler arguments from the config for the stage of
+// the source file src: those of its group first, then those of the stage, so
+// that the more specific ones take precedence.
+func stageArgs(src string) []string {
+	stage := stageOf(src)
+	var args []string
+	for _, group := range sortedKeys(stageGroups) {
+		for _, s := range stageGroups[group] {
+			if s == stage {
+				args = append(args, strings.Fields(config.StageArgs[group])...)
+			}
+		}
+	}
+	return append(args, strings.Fields(config.StageArgs[stage])...)
+}
+
+func isStage(name string) bool {
+	for _, s := range stages {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runTargets generates every target of the config in turn. Paths in a target
+// are relative to the directory of the config file. The targets share the
+// jobSlots of the command line's -jobs and what the compilers print for
+// --version and --help (see compilerOutput), so a compiler used by many
+// targets is only probed once.
+func runTargets() (exitcode int) {
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	jobSlots = make(chan e, jobs)
+	defer func() { jobSlots = nil }()
+
+	base := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) { base[f.Name] = f.Value.String() })
+
+	var failed int
+	for i, t := range config.Targets {
+		name := fmt.Sprint(t["dir"])
+		if _, found := t["dir"]; !found {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		code := runTarget(t, base)
+		if err := os.Chdir(wd); err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+
+		switch {
+		case code == exitInterrupted:
+			return code
+		case code != 0:
+			failed++
+			fmt.Printf("%s: target %s failed\n", os.Args[0], name)
+		case verbosity >= 1:
+			fmt.Printf("%s: target %s done\n", os.Args[0], name)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%s: %d of %d targets failed\n", os.Args[0], failed, len(config.Targets))
+		return 1
+	}
+	return 0
+}
+
+// runTarget applies the target's flags on top of the base flag values and
+// generates it.
+func runTarget(t map[string]interface{}, base map[string]string) int {
+	if err := os.Chdir(config.dir); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	for name, value := range base {
+		if l, ok := flag.Lookup(name).Value.(*stringList); ok {
+			l.reset()
+		}
+		flag.Set(name, value)
+	}
+	for name, value := range t {
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if err := flag.Set(name, fmt.Sprint(v)); err != nil {
+				fmt.Printf("%s error: invalid value %v for -%s: %v\n", os.Args[0], v, name, err)
+				return 1
+			}
+		}
+	}
+	if err := finishArgs(); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	resetState()
+	return generate()
+}
+
+// resetState clears the state left by a previous generate.
+func resetState() {
+	filesToGenerate = nil
+	filesToDelete = nil
+	filesTotal = nil
+	scannedDirs = nil
+	renames = nil
+	lastResult = &runResult{}
+	renamedOld = nil
+	manifestFound = false
+	manifestStale = false
+	overlay = nil
+	includes = includeScanner{}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestConfigTargetsShareProbes generates two config targets with -cache and
+// checks that the compiler they share is probed once.
+func TestConfigTargetsShareProbes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, dir, map[string]string{
+		"a/s.frag":    "void main() {}\n",
+		"b/s.frag":    "void main() { }\n",
+		"config.json": `{"targets": [{"dir": "a"}, {"dir": "b"}]}`,
+	})
+	probes := filepath.Join(dir, "probes")
+	os.Setenv("SPV_TEST_PROBES", probes)
+	defer os.Unsetenv("SPV_TEST_PROBES")
+	defer func() { config = spvConfig{} }()
+
+	run := func() int {
+		if err := loadConfig(filepath.Join(dir, "config.json")); err != nil {
+			t.Fatal(err)
+		}
+		return runTargets()
+	}
+	if code := runSPVWith(t, dir, run, "-cache", filepath.Join(dir, "cache")); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	for _, sub := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(dir, sub, "s.frag"+genExtension)); err != nil {
+			t.Error(err)
+		}
+	}
+	b, err := ioutil.ReadFile(probes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); strings.Join(got, " ") != "--version --help" {
+		t.Errorf("compiler probes %q, want --version and --help once", got)
+	}
+}
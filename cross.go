@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// crossCompiler transpiles SPIR-V modules back into high-level shading
+// languages for -cross.
+const crossCompiler = "spirv-cross"
+
+// crossTarget is a language that -cross can transpile the modules into.
+type crossTarget struct {
+	name   string   // as given to -cross
+	suffix string   // of the constant holding the source, after the shader ID
+	args   []string // spirv-cross arguments selecting the language
+}
+
+var crossTargets = []crossTarget{
+	{"msl", "MSL", []string{"--msl"}},
+	{"hlsl", "HLSL", []string{"--hlsl", "--shader-model", "50"}},
+	{"glsl-es", "GLSLES", []string{"--es", "--version", "300"}},
+}
+
+// crossSpec holds the -cross values, e.g. "target=msl".
+var crossSpec stringList
+
+// crossOutputs are the targets selected with -cross, in the order of
+// crossTargets.
+var crossOutputs []crossTarget
+
+// parseCross fills in crossOutputs from crossSpec. Each value is a target
+// name, optionally written as target=name.
+func parseCross() error {
+	crossOutputs = nil
+	selected := make(map[string]bool)
+	for _, v := range crossSpec {
+		name := strings.TrimPrefix(v, "target=")
+		found := false
+		for _, t := range crossTargets {
+			found = found || t.name == name
+		}
+		if !found {
+			return fmt.Errorf("invalid -cross target %q; accepted targets are msl, hlsl, glsl-es", name)
+		}
+		selected[name] = true
+	}
+	for _, t := range crossTargets {
+		if selected[t.name] {
+			crossOutputs = append(crossOutputs, t)
+		}
+	}
+	return nil
+}
+
+// crossNames returns the names of the selected targets, for the metadata
+// and fingerprints.
+func crossNames() []string {
+	var names []string
+	for _, t := range crossOutputs {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// transpile runs spirv-cross over the module compiled from f once for every
+// target selected with -cross and returns the sources in the same order.
+func transpile(ctx context.Context, f string, words []uint32, statusChan chan status) ([]string, error) {
+	if len(crossOutputs) == 0 {
+		return nil, nil
+	}
+
+	// The module may come from a previous build, so it is written out again
+	spvFile := filepath.Join(tempDir, fmt.Sprintf("%s_%d_cross.spv", strings.ReplaceAll(f, "/", "_"), rand.Int()))
+	err := writeAtomic(spvFile, func(w io.Writer) error {
+		_, err := w.Write(spirvBytes(words))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []string
+	for _, t := range crossOutputs {
+		args := append(append([]string{}, t.args...), spvFile)
+		statusChan <- status{2, commandLine(crossCompiler, args), false, f}
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, crossCompiler, args...)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		err := cmd.Run()
+		if ctx.Err() != nil {
+			return nil, errInterrupted
+		}
+		if err != nil {
+			if stderr.Len() > 0 {
+				return nil, fmt.Errorf("%s failed for %s:\n%s", crossCompiler, t.name, stderr.Bytes())
+			}
+			return nil, fmt.Errorf("%s failed for %s: %v", crossCompiler, t.name, err)
+		}
+		sources = append(sources, stdout.String())
+	}
+	return sources, nil
+}
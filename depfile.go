@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// writeDepFile writes a Make-style dependency file listing the inputs of
+// every generated file: its source and the files it includes. The manifest
+// depends on all sources. Relative paths, including name, are relative to
+// base, the directory spv was run in. A depfile that already holds the same
+// rules is left alone, so that a run without changes doesn't touch it and make
+// or ninja see nothing new.
+func writeDepFile(name, base string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	rel := func(p string) string {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(wd, p)
+		}
+		if r, err := filepath.Rel(base, p); err == nil {
+			p = r
+		}
+		return makeEscape(filepath.ToSlash(p))
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(base, name)
+	}
+
+	write := func(w *bufio.Writer) error {
+		included := make(map[string]e)
+		var sources []string
+		for _, src := range filesTotal {
+			inputs := []string{rel(sourcePath(src))}
+			sources = append(sources, inputs[0])
+			if !isSPIRVFile(src) {
+				deps, err := includes.deps(src)
+				if err != nil {
+					return err
+				}
+				for _, d := range deps {
+					inputs = append(inputs, rel(sourcePath(d)))
+					included[inputs[len(inputs)-1]] = e{}
+				}
+			}
+			writeRule(w, rel(generatedName(src)), inputs)
+		}
+		writeRule(w, rel(manifestPath()), sources)
+		if internal {
+			writeRule(w, rel(manifestFilename), sources)
+		}
+
+		// Empty rules for included files keep make working when one is
+		// removed, like -MP does for C compilers.
+		var phony []string
+		for d := range included {
+			phony = append(phony, d)
+		}
+		sort.Strings(phony)
+		for _, d := range phony {
+			w.WriteString("\n" + d + ":\n")
+		}
+		return nil
+	}
+	_, err = writeIfChanged(name, func(f io.Writer) error {
+		return writeText(f, write)
+	})
+	return err
+}
+
+func writeRule(w *bufio.Writer, target string, inputs []string) {
+	w.WriteString(target + ":")
+	for _, in := range inputs {
+		w.WriteString(" \\\n\t" + in)
+	}
+	w.WriteString("\n")
+}
+
+// makeEscape escapes the characters that are special in Make rules, the way
+// C compilers do in their dependency files.
+func makeEscape(p string) string {
+	var sb strings.Builder
+	for _, c := range p {
+		switch c {
+		case ' ', '\t', '#':
+			sb.WriteByte('\\')
+		case '$':
+			sb.WriteByte('$')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDepFileUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.frag":      "#version 450\n#include \"common.glsl\"\nvoid main() {}\n",
+		"common.glsl": "// nothing\n",
+		"extra.glsl":  "// nothing\n",
+	})
+	depFile := filepath.Join(dir, "out.d")
+	run := func() (string, time.Time) {
+		t.Helper()
+		if c := runSPV(t, dir, "-depfile", depFile); c != 0 {
+			t.Fatalf("exited with %d", c)
+		}
+		data, err := ioutil.ReadFile(depFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(depFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), fi.ModTime()
+	}
+
+	before, _ := run()
+	if !strings.Contains(before, "common.glsl") {
+		t.Errorf("the depfile doesn't list the include:\n%s", before)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(depFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	after, mtime := run()
+	if after != before || !mtime.Equal(old) {
+		t.Errorf("a run without changes rewrote the depfile, modified at %v, want %v", mtime, old)
+	}
+
+	writeFiles(t, dir, map[string]string{"common.glsl": "#include \"extra.glsl\"\n"})
+	if after, _ = run(); !strings.Contains(after, "extra.glsl") {
+		t.Errorf("the depfile doesn't list the new include:\n%s", after)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// includeScanner finds the files included by GLSL sources. The includes of a
+// file are memoized by its path and the hash of its contents, so each header
+// is parsed once per run regardless of how many sources include it, and again
+// only if it changes. It is safe to share a single scanner between all
+// shaders.
+type includeScanner struct {
+	mu   sync.Mutex
+	memo map[includeKey]*includeEntry
+}
+
+// includeKey identifies a file by its path, since its includes are resolved
+// relative to it, and by its contents.
+type includeKey struct {
+	path string
+	hash [sha256.Size]byte
+}
+
+type includeEntry struct {
+	once     sync.Once
+	hash     [sha256.Size]byte // hash of the file contents
+	includes []string          // resolved paths of the directly included and linked files
+	missing  []string          // names of the #includes that couldn't be resolved
+}
+
+var includes includeScanner
+
+// includeDirs returns the include search paths given to the compiler.
+func includeDirs() []string {
+	var dirs []string
+	args := strings.Fields(ccArgs)
+	for i, a := range args {
+		switch {
+		case a == "-I" && i+1 < len(args):
+			dirs = append(dirs, args[i+1])
+		case strings.HasPrefix(a, "-I") && len(a) > 2:
+			dirs = append(dirs, a[2:])
+		}
+	}
+	return dirs
+}
+
+func (s *includeScanner) entry(key includeKey) *includeEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.memo == nil {
+		s.memo = make(map[includeKey]*includeEntry)
+	}
+	ent, ok := s.memo[key]
+	if !ok {
+		ent = &includeEntry{hash: key.hash}
+		s.memo[key] = ent
+	}
+	return ent
+}
+
+// scan returns the memoized includes of the file at path, parsing them if
+// the file is new or changed.
+func (s *includeScanner) scan(path string) (*includeEntry, error) {
+	data, err := loader.read(path)
+	if err != nil {
+		return nil, err
+	}
+	ent := s.entry(includeKey{filepath.Clean(path), sha256.Sum256(data)})
+	ent.once.Do(func() {
+		ent.includes, ent.missing = parseIncludes(path, data)
+		ent.includes = append(ent.includes, parseLinks(path, data)...)
+	})
+	return ent, nil
+}
+
+// direct returns the files directly included by the file at path.
+func (s *includeScanner) direct(path string) ([]string, error) {
+	ent, err := s.scan(path)
+	if err != nil {
+		return nil, err
+	}
+	return ent.includes, nil
+}
+
+// unresolved returns the names of the #includes of the file at path that
+// weren't found next to it or in the include paths.
+func (s *includeScanner) unresolved(path string) ([]string, error) {
+	ent, err := s.scan(path)
+	if err != nil {
+		return nil, err
+	}
+	return ent.missing, nil
+}
+
+// deps returns the sorted list of all files transitively included by the
+// file at path. Include cycles are tolerated.
+func (s *includeScanner) deps(path string) ([]string, error) {
+	seen := map[string]e{filepath.Clean(path): e{}}
+	queue := []string{path}
+	var all []string
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		incs, err := s.direct(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, inc := range incs {
+			if _, found := seen[inc]; found {
+				continue
+			}
+			seen[inc] = e{}
+			all = append(all, inc)
+			queue = append(queue, inc)
+		}
+	}
+	sort.Strings(all)
+	return all, nil
+}
+
+// parseIncludes returns the resolved paths of the #include directives in
+// data, and the names of those that can't be found, which are left for the
+// compiler to report.
+func parseIncludes(path string, data []byte) (incs, missing []string) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(line[1:])
+		if !strings.HasPrefix(line, "include") {
+			continue
+		}
+		line = strings.TrimSpace(line[len("include"):])
+		if len(line) < 2 {
+			continue
+		}
+
+		var name string
+		var local bool
+		switch line[0] {
+		case '"':
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				continue
+			}
+			name, local = line[1:1+end], true
+		case '<':
+			end := strings.IndexByte(line[1:], '>')
+			if end < 0 {
+				continue
+			}
+			name = line[1 : 1+end]
+		default:
+			continue
+		}
+
+		if resolved := resolveInclude(filepath.Dir(path), name, local); resolved != "" {
+			incs = append(incs, resolved)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	return incs, missing
+}
+
+func resolveInclude(dir, name string, local bool) string {
+	var candidates []string
+	if local {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	for _, d := range includeDirs() {
+		candidates = append(candidates, filepath.Join(d, name))
+	}
+	for _, c := range candidates {
+		if isOverlaid(c) {
+			return c
+		}
+		if fi, err := os.Stat(c); err == nil && !fi.IsDir() {
+			return c
+		}
+	}
+	return ""
+}
+
+// hash returns a hash of everything compiling the source file src depends
+// on: the contents of it and its includes, its stage and the arguments for
+// that stage. Two sources with the same hash compile to the same module.
+func (s *includeScanner) hash(src string) (string, error) {
+	deps, err := s.deps(src)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%q\x00", stageOf(src), stageArgs(src))
+	for _, p := range append([]string{src}, deps...) {
+		ent, err := s.scan(p)
+		if err != nil {
+			return "", err
+		}
+		h.Write(ent.hash[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// depsNewer returns true if any file included by src, or the config file
+// giving src stage arguments, is newer than gen, or can't be found.
+func depsNewer(src, gen string) bool {
+	if isSPIRVFile(src) {
+		return false
+	}
+	if len(stageArgs(src)) > 0 {
+		if newer, err := isNewer(config.path, gen); newer || err != nil {
+			return true
+		}
+	}
+	deps, err := includes.deps(src)
+	if err != nil {
+		// The compiler will report unreadable sources
+		return true
+	}
+	for _, d := range deps {
+		if newer, err := isNewer(sourcePath(d), gen); newer || err != nil {
+			// Likewise for an include removed since it was scanned
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files, by their paths relative to dir.
+func writeFiles(tb testing.TB, dir string, files map[string]string) {
+	tb.Helper()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestIncludeDeps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.glsl":     "// nothing\n",
+		"b.glsl":     "#include \"a.glsl\"\n",
+		"cycle.glsl": "#include \"cycle.glsl\"\n#include \"b.glsl\"\n",
+	})
+	p := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name   string
+		source string
+		deps   []string
+	}{
+		{"none", "void main() {}\n", nil},
+		{"direct", "#include \"a.glsl\"\n", []string{p("a.glsl")}},
+		{"transitive", "#include \"b.glsl\"\n", []string{p("a.glsl"), p("b.glsl")}},
+		{"shared", "#include \"a.glsl\"\n#include \"b.glsl\"\n", []string{p("a.glsl"), p("b.glsl")}},
+		{"cycle", "# include \"cycle.glsl\"\n", []string{p("a.glsl"), p("b.glsl"), p("cycle.glsl")}},
+		{"missing", "#include \"nope.glsl\"\n", nil},
+	}
+	var s includeScanner
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFiles(t, dir, map[string]string{"main.frag": tt.source})
+			deps, err := s.deps(p("main.frag"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(deps, "\n") != strings.Join(tt.deps, "\n") {
+				t.Errorf("deps %q, want %q", deps, tt.deps)
+			}
+		})
+	}
+}
+
+// TestIncludeScannerChangedFile checks that a file is scanned again when it
+// changes, rather than giving the includes memoized for its path.
+func TestIncludeScannerChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"a.glsl": "", "b.glsl": ""})
+	header := filepath.Join(dir, "common.glsl")
+
+	var s includeScanner
+	for _, inc := range []string{"a.glsl", "b.glsl", "a.glsl"} {
+		writeFiles(t, dir, map[string]string{"common.glsl": fmt.Sprintf("#include %q\n", inc)})
+		got, err := s.direct(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{filepath.Join(dir, inc)}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("includes %q, want %q", got, want)
+		}
+	}
+	if len(s.memo) != 2 {
+		t.Errorf("%d memoized scans of the two contents", len(s.memo))
+	}
+}
+
+// BenchmarkIncludeFanOut scans the includes of a package where hundreds of
+// shaders include a few layers of headers that all include a common one,
+// sharing a scanner between the shaders as spv does and, for comparison,
+// with a scanner each.
+func BenchmarkIncludeFanOut(b *testing.B) {
+	const shaders, layers, perLayer = 500, 3, 4
+	dir, err := ioutil.TempDir("", "spv-bench-*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"common.glsl": "float common_value() { return 1.0; }\n"}
+	for l := 0; l < layers; l++ {
+		for i := 0; i < perLayer; i++ {
+			src := "#include \"common.glsl\"\n"
+			if l > 0 {
+				for j := 0; j < perLayer; j++ {
+					src += fmt.Sprintf("#include \"layer%d_%d.glsl\"\n", l-1, j)
+				}
+			}
+			for k := 0; k < 50; k++ {
+				src += fmt.Sprintf("float f%d_%d_%d() { return %d.0; }\n", l, i, k, k)
+			}
+			files[fmt.Sprintf("layer%d_%d.glsl", l, i)] = src
+		}
+	}
+	var srcs []string
+	for i := 0; i < shaders; i++ {
+		name := fmt.Sprintf("shader%d.frag", i)
+		files[name] = fmt.Sprintf("#version 450\n#include \"layer%d_%d.glsl\"\nvoid main() {}\n", layers-1, i%perLayer)
+		srcs = append(srcs, filepath.Join(dir, name))
+	}
+	writeFiles(b, dir, files)
+
+	b.Run("shared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var s includeScanner
+			for _, src := range srcs {
+				if _, err := s.deps(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("per shader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, src := range srcs {
+				var s includeScanner
+				if _, err := s.deps(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
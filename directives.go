@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// directivePrefix starts the comments in GLSL sources that give instructions
+// to spv, e.g. "// spv:export".
+const directivePrefix = "spv:"
+
+// sourceDirectives returns the directives in the comments of the source file
+// src, mapping each name to its value, which is "" for directives without
+// one. A directive is a line comment of its own like "// spv:name value".
+func sourceDirectives(src string) (map[string]string, error) {
+	f, err := loader.open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	directives := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "//") {
+			continue
+		}
+		line = strings.TrimSpace(line[2:])
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+		name, value := line[len(directivePrefix):], ""
+		if i := strings.IndexAny(name, " \t"); i >= 0 {
+			name, value = name[:i], strings.TrimSpace(name[i+1:])
+		}
+		if name != "" {
+			directives[name] = value
+		}
+	}
+	return directives, sc.Err()
+}
+
+// isDisabled returns true if the first line of the source file src is a
+// "// spv:skip" or "// spv:ignore" directive, which leaves the source alone:
+// it isn't compiled, and a file generated from it earlier is kept as it is.
+func isDisabled(src string) bool {
+	if isSPIRVFile(src) {
+		return false
+	}
+	f, err := loader.open(src)
+	if err != nil {
+		return false // reported when compiling
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return false
+	}
+	line := strings.TrimSpace(sc.Text())
+	if !strings.HasPrefix(line, "//") {
+		return false
+	}
+	switch strings.TrimSpace(line[2:]) {
+	case directivePrefix + "skip", directivePrefix + "ignore":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"unicode"
+)
+
+// docComments copies the comment at the top of each GLSL source onto the
+// generated binary data as its doc comment.
+var docComments bool
+
+// requireDoc reports the compiled sources without such a comment, with -v.
+var requireDoc bool
+
+// needSource returns true if the generated files need the text of the GLSL
+// sources and not just their modules.
+func needSource() bool {
+	return embedSource || docComments || requireDoc
+}
+
+// sourceDoc returns the lines of the comment block at the top of a GLSL
+// source, before or right after its #version line: either consecutive line
+// comments or one block comment. Directives such as "// spv:export" are left
+// out, and so are the asterisks that usually start the lines of a block
+// comment. It returns nil if there is no such comment.
+func sourceDoc(text []byte) []string {
+	lines := strings.Split(strings.TrimPrefix(string(text), "\ufeff"), "\n")
+	i := 0
+	skipBlank := func() {
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			i++
+		}
+	}
+	skipBlank()
+	if i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "#version") {
+		i++
+		skipBlank()
+	}
+	if i == len(lines) {
+		return nil
+	}
+
+	var doc []string
+	line := strings.TrimSpace(lines[i])
+	switch {
+	case strings.HasPrefix(line, "//"):
+		for ; i < len(lines); i++ {
+			line := strings.TrimSpace(lines[i])
+			if !strings.HasPrefix(line, "//") {
+				break
+			}
+			line = strings.TrimPrefix(line[2:], " ")
+			if !strings.HasPrefix(line, directivePrefix) {
+				doc = append(doc, line)
+			}
+		}
+	case strings.HasPrefix(line, "/*"):
+		lines[i] = strings.Replace(lines[i], "/*", "", 1)
+		for ; i < len(lines); i++ {
+			line := lines[i]
+			end := strings.Index(line, "*/")
+			if end >= 0 {
+				line = line[:end]
+			}
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "*") {
+				line = strings.TrimPrefix(line[1:], " ")
+			}
+			doc = append(doc, line)
+			if end >= 0 {
+				break
+			}
+		}
+	}
+
+	// Without the blank lines around the text
+	for len(doc) > 0 && strings.TrimSpace(doc[0]) == "" {
+		doc = doc[1:]
+	}
+	for len(doc) > 0 && strings.TrimSpace(doc[len(doc)-1]) == "" {
+		doc = doc[:len(doc)-1]
+	}
+	return doc
+}
+
+// writeDocComment writes the doc comment lines as Go line comments. Each
+// comment starts with a space so that no text becomes a Go directive, and
+// everything the Go compiler doesn't accept in a comment is replaced.
+func writeDocComment(outFile *bufio.Writer, doc []string) {
+	for _, line := range doc {
+		line = strings.ToValidUTF8(line, "\ufffd")
+		line = strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			if unicode.IsControl(r) || r == '\ufeff' {
+				return '\ufffd'
+			}
+			return r
+		}, line)
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" {
+			outFile.WriteString("//\n")
+		} else {
+			outFile.WriteString("// " + line + "\n")
+		}
+	}
+}
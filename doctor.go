@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// doctorReport collects the results of the -doctor checks.
+type doctorReport struct {
+	failed int
+}
+
+func (r *doctorReport) ok(what, detail string) {
+	fmt.Printf("ok    %s: %s\n", what, detail)
+}
+
+func (r *doctorReport) warn(what, detail string) {
+	fmt.Printf("warn  %s: %s\n", what, detail)
+}
+
+func (r *doctorReport) fail(what, detail string) {
+	fmt.Printf("FAIL  %s: %s\n", what, detail)
+	r.failed++
+}
+
+// optionalTool is a program spv runs for some of its flags.
+type optionalTool struct {
+	name   string
+	needed string // the flag that needs it if given, or ""
+}
+
+// doctor checks that everything the flags need is installed and usable and
+// prints a report. It fails if anything required is missing; tools only some
+// flags need are reported either way.
+func doctor() int {
+	var r doctorReport
+
+	if path, err := exec.LookPath(cc); err != nil {
+		r.fail("compiler", fmt.Sprintf("%s not found in PATH", cc))
+	} else if version, err := compilerOutput("--version"); err != nil {
+		r.fail("compiler", fmt.Sprintf("%s doesn't run: %v", path, err))
+	} else {
+		r.ok("compiler", fmt.Sprintf("%s (%s)", path, firstLine(version)))
+		doctorTargets(&r)
+		doctorLock(&r)
+	}
+
+	tools := []optionalTool{
+		{optimizer, flagIf(canonical, "-canonicalize")},
+		{linker, ""},
+		{crossCompiler, flagIf(len(crossOutputs) > 0, "-cross")},
+		{"spirv-val", ""},
+		{"tint", ""},
+	}
+	for _, t := range tools {
+		path, err := exec.LookPath(t.name)
+		switch {
+		case err == nil:
+			r.ok(t.name, path)
+		case t.needed != "":
+			r.fail(t.name, "not found in PATH, but "+t.needed+" needs it")
+		default:
+			r.warn(t.name, "not found in PATH (optional)")
+		}
+	}
+
+	if err := detectGoVersion(); err != nil {
+		r.fail("go version", err.Error())
+	} else if err := checkGoVersion(); err != nil {
+		r.fail("go version", err.Error())
+	} else {
+		for _, fallback := range goVersionFallbacks() {
+			r.warn("go version", fallback)
+		}
+	}
+
+	doctorOutputDir(&r)
+
+	if r.failed > 0 {
+		fmt.Printf("%s: %d problems found\n", os.Args[0], r.failed)
+		return 1
+	}
+	fmt.Printf("%s: no problems found\n", os.Args[0])
+	return 0
+}
+
+// doctorTargets checks that the compiler knows the target environments the
+// flags ask for.
+func doctorTargets(r *doctorReport) {
+	envs := append([]string{}, multiTarget...)
+	if env := targetEnv(strings.Fields(ccArgs)); env != "" {
+		envs = append(envs, env)
+	}
+	if len(envs) == 0 {
+		return
+	}
+	help, err := compilerOutput("--help")
+	if err != nil {
+		r.warn("target env", fmt.Sprintf("can't tell which environments %s supports: %v", cc, err))
+		return
+	}
+	for _, env := range envs {
+		if strings.Contains(help, env) {
+			r.ok("target env", env)
+		} else {
+			r.fail("target env", fmt.Sprintf("%s doesn't list %s in its --help", cc, env))
+		}
+	}
+}
+
+// doctorLock checks that the compiler matches spv.lock, if there is one.
+func doctorLock(r *doctorReport) {
+	data, err := ioutil.ReadFile(lockFilename)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		r.fail("lock", err.Error())
+		return
+	}
+	var locked compilerLock
+	if err := json.Unmarshal(data, &locked); err != nil {
+		r.fail("lock", fmt.Sprintf("cannot read %s: %v", lockFilename, err))
+		return
+	}
+	current, err := currentLock()
+	switch {
+	case err != nil:
+		r.fail("lock", fmt.Sprintf("cannot identify the compiler: %v", err))
+	case current == locked:
+		r.ok("lock", "the compiler matches "+lockFilename)
+	case lockWarn:
+		r.warn("lock", "the compiler doesn't match "+lockFilename)
+	default:
+		r.fail("lock", "the compiler doesn't match "+lockFilename+"; run with -update-lock to pin it")
+	}
+}
+
+// doctorOutputDir checks that the generated files can be written.
+func doctorOutputDir(r *doctorReport) {
+	// Created when generating, so its closest existing parent counts
+	dir := outputDir()
+	for dir != "." {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	f, err := ioutil.TempFile(dir, ".spv-doctor-*")
+	if err != nil {
+		r.fail("output directory", fmt.Sprintf("cannot write to %s: %v", dir, err))
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	r.ok("output directory", outputDir()+" is writable")
+}
+
+func flagIf(cond bool, name string) string {
+	if cond {
+		return name
+	}
+	return ""
+}
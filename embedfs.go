@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// embedDir is the directory in the output directory that -as fs writes the
+// modules into, to be embedded by the manifest with //go:embed.
+const embedDir = "spv_modules"
+
+// embedPath returns the path of embedDir relative to the source directory.
+func embedPath() string {
+	return path.Join(outputDir(), embedDir)
+}
+
+// isEmbedDir returns true if dir is an embedDir, which is never scanned for
+// sources.
+func isEmbedDir(dir string) bool {
+	return path.Base(dir) == embedDir
+}
+
+// embeddedName returns the name in embedDir of the module compiled from src,
+// e.g. "foo.frag.spv".
+func embeddedName(src string) string {
+	name := strings.TrimSuffix(path.Base(shaderFileName(src)), genExtension)
+	if !isSPIRVFile(name) {
+		name += ".spv"
+	}
+	return name
+}
+
+// outputDirective returns the value of the "// spv:output" directive of src,
+// "embed" or "inline", or "" if it has none. Precompiled modules have none.
+func outputDirective(src string) string {
+	if isSPIRVFile(src) {
+		return ""
+	}
+	directives, err := sourceDirectives(src)
+	if err != nil {
+		return "" // reported by checkOutputDirective
+	}
+	return directives["output"]
+}
+
+// checkOutputDirective returns an error if the "// spv:output" directive of
+// src is invalid or can't be used with the flags.
+func checkOutputDirective(src string) error {
+	switch outputDirective(src) {
+	case "", "inline":
+		return nil
+	case "embed":
+	default:
+		return fmt.Errorf("invalid spv:output %q; expected embed or inline", outputDirective(src))
+	}
+	switch {
+	case noManifest:
+		return errors.New("spv:output embed needs the manifest and can't be used with -no-manifest")
+	case len(multiTarget) > 0:
+		return errors.New("spv:output embed can't be used with -multi-target")
+	case !goVersionAtLeast(embedMinor):
+		return fmt.Errorf("spv:output embed needs Go 1.%d for embed, but the target is Go 1.%d", embedMinor, goMinor)
+	}
+	return nil
+}
+
+// isEmbedded returns true if the module of src is written into embedDir:
+// with -as fs unless its source says "// spv:output inline" or the target Go
+// version has no embed, and otherwise if it says "// spv:output embed".
+func isEmbedded(src string) bool {
+	switch outputDirective(src) {
+	case "embed":
+		return true
+	case "inline":
+		return false
+	}
+	return outputMode == "fs" && goVersionAtLeast(embedMinor)
+}
+
+// isInlineFS returns true if the module of src is written as a literal with
+// -as fs, because of a "// spv:output inline" directive or the Go version.
+func isInlineFS(src string) bool {
+	return outputMode == "fs" && !isEmbedded(src)
+}
+
+// anyEmbedded returns true if the module of any source is written into
+// embedDir, so that the manifest has to embed it.
+func anyEmbedded() bool {
+	for _, src := range filesTotal {
+		if isEmbedded(src) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEmbedded writes the module compiled from src into embedDir.
+func writeEmbedded(src string, words []uint32) (bool, error) {
+	if err := os.MkdirAll(embedPath(), 0755); err != nil {
+		return false, err
+	}
+	return writeBytesIfChanged(path.Join(embedPath(), embeddedName(src)), spirvBytes(words))
+}
+
+// verifyEmbedded checks that the embedded module of src matches words.
+func verifyEmbedded(src string, words []uint32) error {
+	name := path.Join(embedPath(), embeddedName(src))
+	have, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is missing", name)
+	} else if err != nil {
+		return err
+	}
+	if !bytes.Equal(have, spirvBytes(words)) {
+		return fmt.Errorf("%s doesn't match a fresh build", name)
+	}
+	return nil
+}
+
+// pruneEmbedded removes the modules in embedDir that no source embeds
+// anymore, or with all every module, as well as the directory if nothing else
+// is left in it.
+func pruneEmbedded(all bool) error {
+	fs, err := ioutil.ReadDir(embedPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	keep := make(map[string]e)
+	if !all {
+		for _, src := range filesTotal {
+			if isEmbedded(src) {
+				keep[embeddedName(src)] = e{}
+			}
+		}
+	}
+
+	left := 0
+	for _, f := range fs {
+		if _, found := keep[f.Name()]; found || f.IsDir() || !isSPIRVFile(f.Name()) {
+			left++
+			continue
+		}
+		if err := os.Remove(path.Join(embedPath(), f.Name())); err != nil {
+			return err
+		}
+		if verbosity >= 1 {
+			fmt.Printf("%s: removed %s\n", os.Args[0], path.Join(embedPath(), f.Name()))
+		}
+	}
+	if left == 0 {
+		return os.Remove(embedPath())
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// eolWriter normalizes generated files so that they are identical on every
+// platform: carriage returns are dropped, leaving only \n line endings, and
+// the file ends in exactly one newline once finish is called. Trailing
+// newlines are held back until something else is written.
+type eolWriter struct {
+	w        io.Writer
+	newlines int // newlines written but not yet passed on
+	empty    bool
+}
+
+func newEOLWriter(w io.Writer) *eolWriter {
+	return &eolWriter{w: w, empty: true}
+}
+
+func (e *eolWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if bytes.IndexByte(p, '\r') >= 0 {
+		p = bytes.Replace(p, []byte{'\r'}, nil, -1)
+	}
+	trimmed := bytes.TrimRight(p, "\n")
+	if len(trimmed) > 0 {
+		if err := e.flushNewlines(); err != nil {
+			return 0, err
+		}
+		if _, err := e.w.Write(trimmed); err != nil {
+			return 0, err
+		}
+		e.empty = false
+	}
+	e.newlines += len(p) - len(trimmed)
+	return n, nil
+}
+
+func (e *eolWriter) flushNewlines() error {
+	if e.newlines == 0 {
+		return nil
+	}
+	_, err := e.w.Write(bytes.Repeat([]byte{'\n'}, e.newlines))
+	e.newlines = 0
+	return err
+}
+
+// finish writes the single final newline.
+func (e *eolWriter) finish() error {
+	if e.empty {
+		return nil
+	}
+	e.newlines = 1
+	return e.flushNewlines()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEOLWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string // written one after another, flushing in between
+		want   string
+	}{
+		{"unix", []string{"package x\n\nvar A = 1\n"}, "package x\n\nvar A = 1\n"},
+		{"windows", []string{"package x\r\n\r\nvar A = 1\r\n"}, "package x\n\nvar A = 1\n"},
+		{"no final newline", []string{"package x"}, "package x\n"},
+		{"extra final newlines", []string{"package x\n\n\n"}, "package x\n"},
+		{"split CRLF", []string{"package x\r", "\n", "var A = 1\r", "\n\r\n"}, "package x\nvar A = 1\n"},
+		{"newlines between writes", []string{"package x\n\n", "\n", "var A = 1"}, "package x\n\n\nvar A = 1\n"},
+		{"lone CR", []string{"a\rb\n"}, "ab\n"},
+		{"only newlines", []string{"\n\r\n"}, ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "spv-test-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			name := filepath.Join(dir, "x.gen.go")
+
+			err = writeFileAtomic(name, func(w *bufio.Writer) error {
+				for _, s := range tt.writes {
+					w.WriteString(s)
+					if err := w.Flush(); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+			if bytes.IndexByte(got, '\r') >= 0 {
+				t.Errorf("%q has a carriage return", got)
+			}
+			if len(got) > 0 && (got[len(got)-1] != '\n' || bytes.HasSuffix(got, []byte("\n\n"))) {
+				t.Errorf("%q doesn't end in exactly one newline", got)
+			}
+		})
+	}
+}
+
+// TestManifestEOL checks the line endings of a manifest, which is written
+// from a template with newlines of its own.
+func TestManifestEOL(t *testing.T) {
+	defer func(files []string) { filesTotal = files }(filesTotal)
+	filesTotal = nil // left by the tests that generate
+	got, err := renderFile(executeManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.IndexByte(got, '\r') >= 0 || !bytes.HasSuffix(got, []byte("}\n")) || bytes.HasSuffix(got, []byte("\n\n")) {
+		t.Errorf("manifest doesn't end in exactly one newline after its last line, or has carriage returns:\n%s", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// stringList is a flag that can be given several times, or with a comma
+// separated list, and accumulates the values.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// reset clears the list, so that setting it again doesn't accumulate values
+// from an earlier config target.
+func (l *stringList) reset() {
+	*l = nil
+}
+
+// extensionMinSPIRV maps GLSL extensions to the lowest SPIR-V version they can
+// be compiled for. Extensions not listed here are not checked.
+var extensionMinSPIRV = map[string]string{
+	"GL_KHR_shader_subgroup_basic":            "spv1.3",
+	"GL_KHR_shader_subgroup_vote":             "spv1.3",
+	"GL_KHR_shader_subgroup_arithmetic":       "spv1.3",
+	"GL_KHR_shader_subgroup_ballot":           "spv1.3",
+	"GL_KHR_shader_subgroup_shuffle":          "spv1.3",
+	"GL_KHR_shader_subgroup_shuffle_relative": "spv1.3",
+	"GL_KHR_shader_subgroup_clustered":        "spv1.3",
+	"GL_KHR_shader_subgroup_quad":             "spv1.3",
+	"GL_EXT_ray_tracing":                      "spv1.4",
+	"GL_EXT_ray_query":                        "spv1.4",
+	"GL_EXT_mesh_shader":                      "spv1.4",
+}
+
+// targetEnvSPIRV maps --target-env values to the SPIR-V version they generate.
+var targetEnvSPIRV = map[string]string{
+	"vulkan1.0":         "spv1.0",
+	"vulkan1.1":         "spv1.3",
+	"vulkan1.1spirv1.4": "spv1.4",
+	"vulkan1.2":         "spv1.5",
+	"vulkan1.3":         "spv1.6",
+	"opengl":            "spv1.0",
+	"opengl4.5":         "spv1.0",
+}
+
+var extensionName = regexp.MustCompile(`^GL_[A-Za-z0-9_]+$`)
+
+// checkExtensionNames validates the names given with -enable-ext.
+func checkExtensionNames() error {
+	for _, ext := range enableExt {
+		if !extensionName.MatchString(ext) {
+			return fmt.Errorf("invalid extension name %q for -enable-ext", ext)
+		}
+	}
+	return nil
+}
+
+// extensionArgs returns the compiler arguments enabling the -enable-ext
+// extensions, as glslangValidator preamble lines.
+func extensionArgs() []string {
+	var args []string
+	for _, ext := range enableExt {
+		args = append(args, "-P#extension "+ext+" : enable")
+	}
+	return args
+}
+
+// targetSPIRV returns the SPIR-V version the compiler arguments generate.
+func targetSPIRV(args []string) string {
+	if spvVersion != "" {
+		return spvVersion
+	}
+	for i, a := range args {
+		if a == "--target-spv" && i+1 < len(args) {
+			return args[i+1]
+		} else if strings.HasPrefix(a, "--target-spv=") {
+			return strings.TrimPrefix(a, "--target-spv=")
+		}
+	}
+	if v, found := targetEnvSPIRV[targetEnv(args)]; found {
+		return v
+	}
+	return "spv1.0"
+}
+
+// checkExtensions returns an error if an -enable-ext extension needs a newer
+// SPIR-V version than the compiler arguments target.
+func checkExtensions(args []string) error {
+	target := targetSPIRV(args)
+	for _, ext := range enableExt {
+		if min, found := extensionMinSPIRV[ext]; found && target < min {
+			env := targetEnv(args)
+			if env == "" {
+				env = "the default vulkan1.0"
+			}
+			return fmt.Errorf("%s needs at least %s, but the target is %s (%s)", ext, min, env, target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// argsFingerprint returns a hash of the compiler and the arguments the source
+// file src is compiled with, apart from its input and output paths, and of
+// the options that change the module or its translations afterwards. It is
+// recorded in the generated files so that changing flags such as -args
+// regenerates exactly those files whose arguments changed.
+func argsFingerprint(src string) string {
+	args := compilerArgs(src, "")
+	args = args[:len(args)-3] // -o out src
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%q\x00%t", cc, ccTemplate, args, canonical)
+	if provenance {
+		fmt.Fprintf(h, "\x00provenance %s", spvToolVersion())
+	}
+	if preludeFile != "" && !isSPIRVFile(src) {
+		fmt.Fprintf(h, "\x00prelude %s", preludeHash)
+	}
+	if len(crossOutputs) > 0 {
+		fmt.Fprintf(h, "\x00%q", crossNames())
+	}
+	if len(multiTarget) > 0 {
+		fmt.Fprintf(h, "\x00targets %q", []string(multiTarget))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// argsChanged returns true if the file gen was generated from src with
+// different compiler arguments, or by an older version that didn't record
+// them.
+func argsChanged(src, gen string) bool {
+	m, err := readSourceMeta(src, gen)
+	if err != nil {
+		return true
+	}
+	return m.Fingerprint != argsFingerprint(src)
+}
+
+// globalFingerprint returns a hash of the command line options every
+// fingerprint depends on, for caches that cover all sources at once.
+func globalFingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%q\x00%q\x00%t\x00%q\x00%q\x00%q\x00%q\x00%s\x00%t\x00%s\x00%s", cc, ccTemplate, ccArgs, spvVersion, []string(enableExt), []string(stageExt), canonical, crossNames(), []string(multiTarget), autoMapArgs(), legacyArgs(), forcedStage, provenance, preludeHash, compilerHash())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -3,21 +3,39 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
-	"encoding/hex"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 const (
 	genComment = "// Code generated by github.com/jclc/spv. DO NOT EDIT."
+
+	// maxCommandLine is the length of the compiler arguments above which they
+	// are passed in a response file, well below the 32767 characters Windows
+	// allows for a whole command line.
+	maxCommandLine = 8000
+
+	// rayTracingTargetEnv is the --target-env given to ray tracing shaders
+	// when no target is chosen.
+	rayTracingTargetEnv = "vulkan1.2"
 )
 
+var errInterrupted = errors.New("interrupted")
+
+// compiledHook, if set, is called with every module compiled successfully.
+var compiledHook func(src string, words []uint32)
+
 type generatedFile struct {
 	Package string
 }
@@ -26,109 +44,715 @@ func makeSliceIdentifier(s string) string {
 	return "spv_" + makeIdentifier(s)
 }
 
-func operate(f string, statusChan chan string) (bool, error) {
-	inFileName := f
-	inStat, err := os.Stat(inFileName)
+func operate(ctx context.Context, f string, statusChan chan status) (bool, error) {
+	if ctx.Err() != nil {
+		return false, errInterrupted
+	}
+
+	inStat, err := loader.stat(f)
 	if err != nil {
 		return false, err
 	}
 
-	outFileName := generatedName(inFileName)
+	outFileName := generatedName(f)
 	outStat, err := os.Stat(outFileName)
 	if err != nil && !os.IsNotExist(err) {
 		return false, err
 	}
-	if err == nil && outStat.ModTime().After(inStat.ModTime()) && !force {
-		if verbose {
-			statusChan <- fmt.Sprintf("%s is unmodified; skipping", f)
-		}
+	if err == nil && outStat.ModTime().After(inStat.ModTime()) && !force && !verifyMode && !depsNewer(f, outFileName) &&
+		(isSPIRVFile(f) || !argsChanged(f, outFileName) && !compilerChanged(f, outFileName)) && !optionsChanged(f, outFileName) {
+		statusChan <- status{1, fmt.Sprintf("%s is unmodified; skipping", f), true, f}
 		return false, nil
 	}
 
-	spvFile := filepath.Join(tempDir, fmt.Sprintf("%s_%d.spv", f, rand.Int()))
+	if _, err := shaderGroup(f); err != nil {
+		return false, err
+	}
+	if _, err := sourceBuild(f); err != nil {
+		return false, err
+	}
+	if _, err := linkGroupOf(f); err != nil {
+		return false, err
+	}
+	if err := checkOutputDirective(f); err != nil {
+		return false, err
+	}
+
+	var words []uint32
+	var warnings []string
+	var source []byte
+	if old, found := renames[f]; found {
+		// Same source and includes as an old file, so its module is reused
+		if words, err = generatedModule(old); err == nil {
+			statusChan <- status{1, fmt.Sprintf("%s was renamed from %s; reusing its module", f, renamedFrom(old)), false, f}
+			if needSource() {
+				if source, err = loader.read(f); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
 
-	var args []string
-	args = append(args, strings.Split(ccArgs, " ")...)
-	args = append(args, "-o", spvFile, inFileName)
-	cmd := exec.Command(cc, args...)
+	if words == nil {
+		spvFile := loader.path(f) // precompiled modules are embedded as they are
+		if !isSPIRVFile(f) {
+			if needSource() {
+				source, err = loader.read(f)
+				if err != nil {
+					return false, err
+				}
+			}
+			spvFile, warnings, err = buildCached(ctx, f, target{}, statusChan)
+			if err != nil {
+				return false, err
+			}
+			if needSource() {
+				// The embedded source must be the one the module was compiled from
+				after, err := loader.read(f)
+				if err != nil {
+					return false, err
+				}
+				if sha256.Sum256(after) != sha256.Sum256(source) {
+					return false, errors.New("source changed during compilation")
+				}
+			}
+		}
+
+		words, err = readSPIRVFile(spvFile)
+		if err != nil {
+			return false, err
+		}
+		if provenance && !isSPIRVFile(f) {
+			if words, err = addProvenance(f, target{}, words); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	targetWords, warnings, err := buildTargets(ctx, f, warnings, statusChan)
+	if err != nil {
+		return false, err
+	}
+
+	if warnEmpty {
+		if msg := degenerateModule(words); msg != "" {
+			warnings = append(warnings, msg)
+		}
+	}
+	if checkLimits {
+		w, err := limitWarnings(words)
+		if err != nil {
+			return false, err
+		}
+		warnings = append(warnings, w...)
+	}
+	if strictExtensions && !isSPIRVFile(f) {
+		if err := checkImplicitExtensions(f, words, warnings); err != nil {
+			return false, err
+		}
+	}
+	if requireDoc && !isSPIRVFile(f) && sourceDoc(source) == nil {
+		statusChan <- status{1, fmt.Sprintf("%s has no doc comment", f), false, f}
+	}
+	if werror && len(warnings) > 0 {
+		return false, errors.New("\n" + strings.Join(warnings, "\n"))
+	}
+	for _, w := range warnings {
+		statusChan <- status{0, fmt.Sprintf("%s warning in file %s: %s", os.Args[0], f, w), false, f}
+	}
+
+	if compiledHook != nil {
+		compiledHook(f, words)
+	}
+	lastResult.addSize(f, 4*len(words))
+	if checkBindings != "" {
+		recordModule(f, words)
+	}
+
+	translated, err := transpile(ctx, f, words, statusChan)
+	if err != nil {
+		return false, err
+	}
+
+	if outputMode == "blob" {
+		if err := stashBlobModule(f, words); err != nil {
+			return false, err
+		}
+	}
+
+	if verifyMode {
+		if isEmbedded(f) {
+			if err := verifyEmbedded(f, words); err != nil {
+				return false, err
+			}
+		}
+		if bucketCount > 0 {
+			// Verified with the whole bucket
+			return false, stashSection(f, func(w *bufio.Writer) error {
+				return writeDeclarations(w, words, targetWords, source, translated, f)
+			})
+		}
+		return false, verifyFile(outFileName, func(w *bufio.Writer) error {
+			return writeGoData(w, words, targetWords, source, translated, f)
+		})
+	}
+
+	var embeddedChanged bool
+	if isEmbedded(f) {
+		// Written first, as the generated file tells whether it is up to date
+		if embeddedChanged, err = writeEmbedded(f, words); err != nil {
+			return false, err
+		}
+	}
+
+	if bucketCount > 0 {
+		// Written with the whole bucket by writeBuckets
+		return true, stashSection(f, func(w *bufio.Writer) error {
+			return writeDeclarations(w, words, targetWords, source, translated, f)
+		})
+	}
+
+	written, err := writeGoFile(f, words, targetWords, source, translated, outFileName)
+	if err != nil {
+		return false, err
+	}
+	if !written {
+		statusChan <- status{1, fmt.Sprintf("%s is identical to a fresh build; not rewritten", outFileName), false, f}
+		return embeddedChanged, nil
+	}
+
+	return true, nil
+}
+
+// buildModule compiles the source file f for the target t, links the
+// sources it names for the same target and canonicalizes the result if
+// enabled. It returns the
+// path of the module along with the warnings of the compilers.
+func buildModule(ctx context.Context, f string, t target, statusChan chan status) (string, []string, error) {
+	spvFile, warnings, err := compile(ctx, f, t, statusChan)
+	if err != nil {
+		return "", nil, err
+	}
+	linked, err := linkedSources(f)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(linked) > 0 {
+		var linkWarnings []string
+		spvFile, linkWarnings, err = compileLinked(ctx, f, t, spvFile, linked, statusChan)
+		if err != nil {
+			return "", nil, err
+		}
+		warnings = append(warnings, linkWarnings...)
+	}
+	if canonical {
+		if spvFile, err = canonicalize(ctx, spvFile, statusChan); err != nil {
+			return "", nil, err
+		}
+	}
+	return spvFile, warnings, nil
+}
+
+// compile compiles the source file f for the target t into a
+// SPIR-V file in the temp directory and returns its path along with the
+// warnings printed by the compiler.
+func compile(ctx context.Context, f string, t target, statusChan chan status) (string, []string, error) {
+	spvFile := filepath.Join(tempDir, fmt.Sprintf("%s_%d.spv", strings.ReplaceAll(f, "/", "_"), rand.Int()))
+	warnings, err := runCompiler(ctx, f, spvFile, t, statusChan)
+	if err != nil {
+		return "", nil, err
+	}
+	return spvFile, warnings, nil
+}
+
+// runCompiler runs the compiler on the source file f, writing the module to
+// spvFile for the target t, and returns the warnings it printed.
+func runCompiler(ctx context.Context, f, spvFile string, t target, statusChan chan status) ([]string, error) {
+	args := t.args(f, spvFile)
+	if ccTemplate != "" {
+		var err error
+		if args, err = templateArgs(f, spvFile, t); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkExtensions(args); err != nil {
+		return nil, err
+	}
+	if preludeFile != "" {
+		if err := writePreluded(f); err != nil {
+			return nil, err
+		}
+	}
+	statusChan <- status{2, commandLine(cc, args), false, f}
+	if argsLength(args) > maxCommandLine {
+		rspFile := strings.TrimSuffix(spvFile, ".spv") + ".args"
+		if err := writeResponseFile(rspFile, args); err != nil {
+			return nil, err
+		}
+		args = []string{"@" + rspFile}
+	}
+	cmd := exec.CommandContext(ctx, cc, args...)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err = cmd.Run()
+	targetWarning := rayTracingTargetWarning(f, args)
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, errInterrupted
+	}
 	if err != nil {
+		if targetWarning != "" {
+			targetWarning = "\n" + targetWarning
+		}
 		if stdout.Len() > 0 {
-			return false, errors.New("\n" + stdout.String())
+			return nil, errors.New(targetWarning + "\n" + unpreluded(f, stdout.String()))
 		} else if stderr.Len() > 0 {
-			return false, errors.New("\n" + stderr.String())
+			return nil, errors.New(targetWarning + "\n" + unpreluded(f, stderr.String()))
 		}
-		return false, err
+		if targetWarning != "" {
+			return nil, fmt.Errorf("%v%s", err, targetWarning)
+		}
+		return nil, err
 	}
 
-	if verbose && stdout.Len() > 0 {
-		statusChan <- fmt.Sprintf("-- %s --\n%s", f, stdout.String())
+	if strictStderr && stderr.Len() > 0 {
+		return nil, errors.New("compiler succeeded but wrote to stderr:\n" + stderr.String())
 	}
 
-	err = writeGoFile(inFileName, spvFile, outFileName)
+	if verbosity >= 3 {
+		statusChan <- status{3, fmt.Sprintf("-- %s stdout --\n%s-- %s stderr --\n%s", f, stdout.String(), f, stderr.String()), false, f}
+	} else if stdout.Len() > 0 {
+		statusChan <- status{1, fmt.Sprintf("-- %s --\n%s", f, stdout.String()), false, f}
+	}
+
+	warnings := compilerWarnings(unpreluded(f, stdout.String()+stderr.String()))
+	if targetWarning != "" {
+		warnings = append(warnings, targetWarning)
+	}
+	return warnings, nil
+}
+
+// compilerArgs returns the arguments for compiling the source file src into
+// the SPIR-V file out.
+func compilerArgs(src, out string) []string {
+	var args []string
+	args = append(args, strings.Fields(ccArgs)...)
+	args = append(args, stageArgs(src)...)
+	args = append(args, stageFlagArgs(src)...)
+	if isRayTracing(src) && targetEnv(args) == "" && spvVersion == "" {
+		// Ray tracing needs SPIR-V 1.4, which the default Vulkan 1.0 target lacks
+		args = append(args, "--target-env", rayTracingTargetEnv)
+	}
+	if spvVersion != "" {
+		args = append(args, "--target-spv", spvVersion)
+	}
+	args = append(args, extensionArgs()...)
+	args = append(args, autoMapArgs()...)
+	args = append(args, legacyArgs()...)
+	if isOverlaid(src) || preludeFile != "" {
+		// Resolve relative includes from the logical location of the source
+		args = append(args, "-I"+filepath.Dir(src))
+	}
+	args = append(args, "-o", out, compilerInput(src))
+	return args
+}
+
+func writeGoFile(source string, words []uint32, targetWords [][]uint32, text []byte, translated []string, out string) (bool, error) {
+	return writeFileIfChanged(out, func(outFile *bufio.Writer) error {
+		return writeGoData(outFile, words, targetWords, text, translated, source)
+	})
+}
+
+// writeGoData writes the generated file for source: the module, and as
+// selected by the flags and directives the modules compiled for the
+// -multi-target environments or the variants, its GLSL text, the sources it was translated into with -cross
+// and the reflection metadata.
+func writeGoData(outFile *bufio.Writer, words []uint32, targetWords [][]uint32, text []byte, translated []string, source string) error {
+	constraint, err := sourceBuild(source)
 	if err != nil {
-		return false, err
+		return err
 	}
+	if constraint = requireGo(constraint, isEmbedded(source), embedMinor); constraint != nil {
+		if err := writeBuildConstraint(outFile, constraint); err != nil {
+			return err
+		}
+	}
+	writeHeader(outFile)
+	outFile.WriteString(genComment)
+	outFile.WriteString("\n\n")
+	writeMeta(outFile, source)
+	fmt.Fprintf(outFile, "\npackage %s\n\n", dataPackage())
+	writeImports(outFile)
+	return writeDeclarations(outFile, words, targetWords, text, translated, source)
+}
 
-	return true, nil
+// writeDeclarations writes the declarations of the generated file for source,
+// after its package clause and imports; see writeGoData.
+func writeDeclarations(outFile *bufio.Writer, words []uint32, targetWords [][]uint32, text []byte, translated []string, source string) error {
+	varName := makeSliceIdentifier(source)
+
+	if docComments {
+		writeDocComment(outFile, sourceDoc(text))
+	}
+	writeBinaryData(outFile, varName, source, words)
+	targets, _ := sourceTargets(source) // checked by operate
+	for i, t := range targets {
+		if targetWords[i] != nil {
+			outFile.WriteString("\n")
+			writeBinaryData(outFile, varName+t.suffix(), source, targetWords[i])
+		}
+	}
+
+	if embedSource {
+		writeSource(outFile, source, text)
+	}
+
+	for i, t := range crossOutputs {
+		writeTextConst(outFile, makeIdentifier(source)+t.suffix, translated[i])
+	}
+
+	if checksums {
+		writeChecksum(outFile, source, words)
+	}
+
+	if registerFunc != "" {
+		writeRegistration(outFile, source)
+	}
+
+	if reflect {
+		return writeReflection(outFile, source, words)
+	}
+
+	return nil
 }
 
-func writeGoFile(source, in, out string) error {
-	inFile, err := os.Open(in)
+// writeImports writes the imports of the generated files, if they have any.
+func writeImports(outFile *bufio.Writer) {
+	var imports []string
+	if byteOutput() && byteTypeImport != "" {
+		imports = append(imports, byteTypeImport)
+	}
+	if registerImport != "" && registerImport != byteTypeImport {
+		imports = append(imports, registerImport)
+	}
+	sort.Strings(imports)
+	switch len(imports) {
+	case 0:
+	case 1:
+		fmt.Fprintf(outFile, "import %s\n\n", strconv.Quote(imports[0]))
+	default:
+		outFile.WriteString("import (\n")
+		for _, imp := range imports {
+			fmt.Fprintf(outFile, "\t%s\n", strconv.Quote(imp))
+		}
+		outFile.WriteString(")\n\n")
+	}
+}
+
+// writeBinaryData writes the module compiled from source as the variable or
+// constant varName, in the output mode. A "// spv:output" directive in the
+// source can embed the module as a file, or write it inline with -as fs,
+// while the data keeps the type of the output mode.
+func writeBinaryData(outFile *bufio.Writer, varName, source string, words []uint32) {
+	perLine := wordsPerLine
+	if perLine <= 0 {
+		perLine = len(words)
+	}
+
+	embedded := isEmbedded(source)
+	switch {
+	case outputMode == "compressed" && embedded:
+		fmt.Fprintf(outFile, "var %s = loadedModule(readModule(%s))\n", varName, strconv.Quote(embeddedName(source)))
+	case (outputMode == "fs" || outputMode == "blob") && embedded:
+		if byteTypeName != defaultByteType {
+			fmt.Fprintf(outFile, "var %s = %s(readModule(%s))\n", varName, byteTypeName, strconv.Quote(embeddedName(source)))
+		} else {
+			fmt.Fprintf(outFile, "var %s = readModule(%s)\n", varName, strconv.Quote(embeddedName(source)))
+		}
+	case outputMode == "fs" || outputMode == "blob" && outputDirective(source) == "inline":
+		fmt.Fprintf(outFile, "var %s = %s(", varName, byteTypeName)
+		writeStringLiteral(outFile, words, perLine)
+		outFile.WriteString(")\n")
+	case outputMode == "blob" && byteTypeName != defaultByteType:
+		fmt.Fprintf(outFile, "var %s = %s(blobModule(%s))\n", varName, byteTypeName, strconv.Quote(source))
+	case outputMode == "blob":
+		fmt.Fprintf(outFile, "var %s = blobModule(%s)\n", varName, strconv.Quote(source))
+	case outputMode == "compressed":
+		fmt.Fprintf(outFile, "var %s = lazyModule(%s)\n", varName, strconv.Quote(compressedModule(words)))
+	case embedded && outputMode == "string":
+		fmt.Fprintf(outFile, "var %s = string(readModule(%s))\n", varName, strconv.Quote(embeddedName(source)))
+	case embedded:
+		fmt.Fprintf(outFile, "var %s = moduleWords(readModule(%s))\n", varName, strconv.Quote(embeddedName(source)))
+	case outputMode == "string":
+		fmt.Fprintf(outFile, "const %s = ", varName)
+		writeStringLiteral(outFile, words, perLine)
+		outFile.WriteString("\n")
+	default:
+		fmt.Fprintf(outFile, "var %s = []uint32{\n", varName)
+		for i, w := range words {
+			if i%perLine == 0 {
+				outFile.WriteByte('\t')
+			} else {
+				outFile.WriteByte(' ')
+			}
+			fmt.Fprintf(outFile, "0x%08x,", w)
+			if i%perLine == perLine-1 || i == len(words)-1 {
+				outFile.WriteByte('\n')
+			}
+		}
+		outFile.WriteString("}\n")
+	}
+}
+
+// writeStringLiteral writes the module as a string literal of perLine words
+// per line. It is concatenated a line at a time rather than copying the whole
+// module.
+func writeStringLiteral(outFile *bufio.Writer, words []uint32, perLine int) {
+	for i := 0; i < len(words); i += perLine {
+		if i > 0 {
+			outFile.WriteString(" +\n\t")
+		}
+		end := i + perLine
+		if end > len(words) {
+			end = len(words)
+		}
+		outFile.WriteByte('"')
+		for _, c := range spirvBytes(words[i:end]) {
+			fmt.Fprintf(outFile, "\\x%02x", c)
+		}
+		outFile.WriteByte('"')
+	}
+}
+
+// writeSource writes the GLSL text the module was compiled from as a string
+// constant, one source line per line. Precompiled modules get an empty string.
+func writeSource(outFile *bufio.Writer, source string, text []byte) {
+	writeTextConst(outFile, makeIdentifier(source)+"Source", string(text))
+}
+
+// writeTextConst writes text as a string constant with one line of text per
+// line of Go.
+func writeTextConst(outFile *bufio.Writer, name, text string) {
+	fmt.Fprintf(outFile, "\nconst %s = ", name)
+	if len(text) == 0 {
+		outFile.WriteString(`""` + "\n")
+		return
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		if i > 0 {
+			outFile.WriteString(" +\n\t")
+		}
+		outFile.WriteString(strconv.Quote(line))
+	}
+	outFile.WriteString("\n")
+}
+
+// writeReflection writes the metadata extracted from the module.
+func writeReflection(outFile *bufio.Writer, source string, words []uint32) error {
+	m, err := parseSPIRV(words)
 	if err != nil {
 		return err
 	}
-	defer inFile.Close()
 
-	outFile, err := os.Create(out)
+	id := makeIdentifier(source)
+	fmt.Fprintf(outFile, "\nconst %sSPVVersion = 0x%08x\n", id, m.version)
+	fmt.Fprintf(outFile, "const %sSPVVersionString = %q\n", id, m.versionString())
+
+	offset, size, err := m.pushConstantRange()
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
+	fmt.Fprintf(outFile, "\nconst %sPushConstantOffset = %d\n", id, offset)
+	fmt.Fprintf(outFile, "const %sPushConstantSize = %d\n", id, size)
 
-	// cleanedSrc := strings.ReplaceAll(filepath.Base(source), ".", "_")
-	varName := makeSliceIdentifier(source)
-	// pathConst := "SpvPath_" + cleanedSrc
+	fmt.Fprintf(outFile, "\nvar %sEntryPoints = []EntryPoint{\n", id)
+	for _, ep := range m.entryPoints() {
+		fmt.Fprintf(outFile, "\t{%s, Stage%s},\n", strconv.Quote(ep.name), ep.stage)
+	}
+	outFile.WriteString("}\n")
 
-	outFile.WriteString(genComment)
-	fmt.Fprintf(outFile, "\n\npackage %s\n\n", pkg)
-	// fmt.Fprintf(outFile, "const %s = \"%s\"\n\n", pathConst, source)
-	fmt.Fprintf(outFile, "var %s = []uint32{\n\t", varName)
-
-	inBuf := bufio.NewReader(inFile)
-	h := hex.NewEncoder(outFile)
-	outEndianness := binary.BigEndian // Endianness in the resulting Go file
-	var inEndianness binary.ByteOrder // Endianness in the compiled SPIR-V file
-
-	var bb [4]byte
-	var ui uint32
-	inBuf.Read(bb[:])
-	if bb == [4]byte{0x07, 0x23, 0x02, 0x03} {
-		inEndianness = binary.BigEndian
-	} else if bb == [4]byte{0x03, 0x02, 0x23, 0x07} {
-		inEndianness = binary.LittleEndian
-	} else {
-		return fmt.Errorf("invalid magic number %x", bb)
-	}
-
-	for {
-		outFile.WriteString("0x")
-		ui = inEndianness.Uint32(bb[:])
-		binary.Write(h, outEndianness, ui)
-		outFile.WriteString(", ")
-
-		_, err := inBuf.Read(bb[:])
-		if err != nil {
-			break
-		}
+	fmt.Fprintf(outFile, "\nvar %sBindings = []Binding{\n", id)
+	for _, b := range m.bindings() {
+		fmt.Fprintf(outFile, "\t{%d, %d, %s},\n", b.set, b.binding, strconv.Quote(b.name))
+	}
+	outFile.WriteString("}\n")
+
+	fmt.Fprintf(outFile, "\nvar %sSpecConstants = []SpecConstant{\n", id)
+	for _, c := range m.specConstants() {
+		fmt.Fprintf(outFile, "\t{%d, %s, %q, %d, 0x%x},\n", c.id, strconv.Quote(c.name), c.typ, c.size, c.bits)
 	}
+	outFile.WriteString("}\n")
 
-	outFile.WriteString("\n}\n")
+	fmt.Fprintf(outFile, "\nvar %sRequiredCapabilities = []string{", id)
+	for i, c := range m.capabilities() {
+		if i > 0 {
+			outFile.WriteString(", ")
+		}
+		outFile.WriteString(strconv.Quote(c))
+	}
+	outFile.WriteString("}\n")
 
 	return nil
 }
+
+// isRayTracing returns true if src is a ray tracing shader.
+func isRayTracing(src string) bool {
+	stage := stageOf(src)
+	for _, s := range stageGroups["raytracing"] {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// targetEnv returns the value of the --target-env compiler argument, in the
+// forms of both glslangValidator and glslc, or "" if there is none.
+func targetEnv(args []string) string {
+	var env string
+	for i, a := range args {
+		if a == "--target-env" && i+1 < len(args) {
+			env = args[i+1]
+		} else if strings.HasPrefix(a, "--target-env=") {
+			env = strings.TrimPrefix(a, "--target-env=")
+		}
+	}
+	return env
+}
+
+// rayTracingTargetWarning returns a warning if src is a ray tracing shader and
+// the compiler arguments select a target older than SPIR-V 1.4, or "".
+func rayTracingTargetWarning(src string, args []string) string {
+	if !isRayTracing(src) {
+		return ""
+	}
+	if spvVersion != "" {
+		if spvVersion < "spv1.4" {
+			return fmt.Sprintf("ray tracing shaders need at least spv1.4, but -spv-version is %s", spvVersion)
+		}
+		return ""
+	}
+	switch env := targetEnv(args); env {
+	case "vulkan1.0", "vulkan1.1", "opengl", "opengl4.5":
+		return fmt.Sprintf("ray tracing shaders need at least %s (or spv1.4), but the target is %s", rayTracingTargetEnv, env)
+	}
+	return ""
+}
+
+// compilerWarnings returns the warning lines in the compiler output, in the
+// formats of both glslangValidator and glslc.
+func compilerWarnings(output string) []string {
+	var warnings []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "WARNING:") || strings.Contains(line, ": warning:") {
+			warnings = append(warnings, line)
+		}
+	}
+	return warnings
+}
+
+// argsLength returns the length of args on a command line.
+func argsLength(args []string) int {
+	n := 0
+	for _, a := range args {
+		n += len(a) + 1
+	}
+	return n
+}
+
+// writeResponseFile writes args into a response file for the compiler, one
+// argument per line, quoting arguments as needed.
+func writeResponseFile(name string, args []string) error {
+	var sb strings.Builder
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'\\") {
+			a = strconv.Quote(a)
+		}
+		sb.WriteString(a)
+		sb.WriteByte('\n')
+	}
+	return ioutil.WriteFile(name, []byte(sb.String()), 0644)
+}
+
+// commandLine formats a command for display, quoting arguments as needed.
+func commandLine(name string, args []string) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, a := range args {
+		sb.WriteByte(' ')
+		if a == "" || strings.ContainsAny(a, " \t\"'\\") {
+			a = strconv.Quote(a)
+		}
+		sb.WriteString(a)
+	}
+	return sb.String()
+}
+
+// writeFileAtomic writes a file by writing into a temporary file in the same
+// directory and renaming it over name, so that name is never left
+// half-written. The temporary file is removed if write fails.
+func writeFileAtomic(name string, write func(*bufio.Writer) error) error {
+	return writeAtomic(name, func(f io.Writer) error {
+		return writeText(f, write)
+	})
+}
+
+// writeText writes into f with write the way generated files are written,
+// with Unix line endings and a single final newline.
+func writeText(f io.Writer, write func(*bufio.Writer) error) error {
+	eol := newEOLWriter(f)
+	w := bufio.NewWriter(eol)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return eol.finish()
+}
+
+// writeAtomic is writeFileAtomic for files that are written as they are, such
+// as binary ones.
+func writeAtomic(name string, write func(io.Writer) error) error {
+	_, err := replaceFile(name, func(w io.Writer) (bool, error) {
+		return true, write(w)
+	})
+	return err
+}
+
+// replaceFile is writeAtomic, except that the temporary file only replaces
+// name if write returns true, and is removed otherwise. It returns whether
+// name was replaced. The new file keeps the mode of the one it replaces, or
+// gets 0644 if there is none, instead of the 0600 of temporary files.
+func replaceFile(name string, write func(io.Writer) (bool, error)) (bool, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after a successful rename
+
+	replace, err := write(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !replace {
+		return false, err
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// evalStringLiteral returns the value of a concatenation of string literals
+// like the ones writeStringLiteral writes.
+func evalStringLiteral(t *testing.T, src string) string {
+	t.Helper()
+	expr, err := parser.ParseExpr(src)
+	if err != nil {
+		t.Fatalf("invalid Go expression %q: %v", src, err)
+	}
+	var value func(ast.Expr) string
+	value = func(e ast.Expr) string {
+		switch e := e.(type) {
+		case *ast.BinaryExpr:
+			if e.Op != token.ADD {
+				t.Fatalf("unexpected operator %s in %q", e.Op, src)
+			}
+			return value(e.X) + value(e.Y)
+		case *ast.BasicLit:
+			s, err := strconv.Unquote(e.Value)
+			if err != nil {
+				t.Fatalf("invalid literal %s: %v", e.Value, err)
+			}
+			return s
+		}
+		t.Fatalf("unexpected expression %T in %q", e, src)
+		return ""
+	}
+	return value(expr)
+}
+
+func TestStringLiteralRoundTrip(t *testing.T) {
+	// Every byte value, including invalid UTF-8, NUL, quotes and backslashes
+	var all []uint32
+	for i := 0; i < 256; i += 4 {
+		all = append(all, uint32(i)|uint32(i+1)<<8|uint32(i+2)<<16|uint32(i+3)<<24)
+	}
+
+	tests := []struct {
+		name    string
+		words   []uint32
+		perLine int
+	}{
+		{"header", []uint32{spirvMagic, 0x00010000, 0x00080001, 10, 0}, 8},
+		{"all bytes", all, 8},
+		{"one word per line", all, 1},
+		{"single line", all, len(all)},
+		{"uneven last line", all[:13], 5},
+		{"invalid utf-8", []uint32{0xfffefdfc, 0x80c0e0f0, 0x0022275c}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			writeStringLiteral(w, tt.words, tt.perLine)
+			w.Flush()
+
+			got := evalStringLiteral(t, buf.String())
+			if want := string(spirvBytes(tt.words)); got != want {
+				t.Errorf("round trip of %d words gave %q, want %q", len(tt.words), got, want)
+			}
+		})
+	}
+}
+
+// TestBinaryDataLocalizedDiff changes one byte in the middle of a module and
+// checks that only the line holding it changes in the generated literal, in
+// every output mode that writes the module inline.
+func TestBinaryDataLocalizedDiff(t *testing.T) {
+	defer func(mode string, perLine int) { outputMode, wordsPerLine = mode, perLine }(outputMode, wordsPerLine)
+	defer func(name string, minor int) { byteTypeName, goMinor = name, minor }(byteTypeName, goMinor)
+	byteTypeName = defaultByteType
+
+	words := make([]uint32, 1001)
+	for i := range words {
+		words[i] = uint32(i) * 0x01000193
+	}
+	changed := append([]uint32(nil), words...)
+	changed[500] ^= 0x00ff0000
+
+	tests := []struct {
+		mode    string
+		perLine int
+		goMinor int
+	}{
+		{"words", 8, 0},
+		{"words", 1, 0},
+		{"words", 13, 0},
+		{"string", 8, 0},
+		{"string", 5, 0},
+		{"fs", 8, 15}, // inline before Go 1.16
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.mode, tt.perLine), func(t *testing.T) {
+			outputMode, wordsPerLine, goMinor = tt.mode, tt.perLine, tt.goMinor
+			render := func(words []uint32) []string {
+				var buf bytes.Buffer
+				w := bufio.NewWriter(&buf)
+				writeBinaryData(w, "spv_Test", "test.comp.spv", words)
+				w.Flush()
+				return strings.Split(buf.String(), "\n")
+			}
+			before, after := render(words), render(changed)
+			if len(before) != len(after) {
+				t.Fatalf("%d lines became %d", len(before), len(after))
+			}
+			var differing []int
+			for i := range before {
+				if before[i] != after[i] {
+					differing = append(differing, i)
+				}
+			}
+			if len(differing) != 1 {
+				t.Errorf("lines %v of %d differ, want a single one", differing, len(before))
+			}
+		})
+	}
+}
+
+// TestWriteAtomicMode checks that a file written through a temporary one gets
+// 0644 when new and keeps its mode when replaced.
+func TestWriteAtomicMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions")
+	}
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.go")
+	write := func(w io.Writer) error {
+		_, err := io.WriteString(w, "package x\n")
+		return err
+	}
+
+	for _, tt := range []struct {
+		chmod, want os.FileMode
+	}{{0, 0644}, {0664, 0664}} {
+		if tt.chmod != 0 {
+			if err := os.Chmod(name, tt.chmod); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writeAtomic(name, write); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != tt.want {
+			t.Errorf("mode %v, want %v", got, tt.want)
+		}
+	}
+}
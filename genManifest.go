@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"text/template"
@@ -9,6 +10,118 @@ import (
 const manifestTemplate = `// Code generated by github.com/jclc/spv. DO NOT EDIT.
 
 package {{.Package}}
+{{- if or .EmbedDir .Checksums .ByteImport .Compressed }}
+
+import (
+{{- if .Compressed }}
+	"compress/flate"
+{{- end }}
+{{- if .Checksums }}
+	"crypto/sha256"
+{{- end }}
+{{- if .EmbedDir }}
+	"embed"
+{{- end }}
+{{- if .Compressed }}
+	"encoding/base64"
+{{- end }}
+{{- if .EmbedFS }}
+	"io/fs"
+{{- end }}
+{{- if .Compressed }}
+	"io/ioutil"
+	"strings"
+	"sync"
+{{- end }}
+{{- if .ByteImport }}
+
+	"{{ .ByteImport }}"
+{{- end }}
+)
+{{- end }}
+{{- if .EmbedDir }}
+
+//go:embed {{ .EmbedDir }}
+var files embed.FS
+{{- if .EmbedFS }}
+
+// FS contains the embedded SPIR-V modules named after their sources, e.g.
+// "foo.frag.spv", for code that works with an fs.FS.
+var FS fs.FS
+
+func init() {
+	var err error
+	if FS, err = fs.Sub(files, "{{ .EmbedDir }}"); err != nil {
+		panic(err)
+	}
+}
+{{- end }}
+
+// readModule returns the named module from files.
+func readModule(name string) []byte {
+	b, err := files.ReadFile("{{ .EmbedDir }}/" + name)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+{{- if eq .DataType "[]uint32" }}
+
+// moduleWords returns the words of a module read with readModule, which are
+// stored in little-endian order.
+func moduleWords(b []byte) []uint32 {
+	words := make([]uint32, len(b)/4)
+	for i := range words {
+		words[i] = uint32(b[4*i]) | uint32(b[4*i+1])<<8 | uint32(b[4*i+2])<<16 | uint32(b[4*i+3])<<24
+	}
+	return words
+}
+{{- end }}
+{{- end }}
+{{- if .Compressed }}
+
+// Module is a SPIR-V module stored as base64 of its DEFLATE compressed bytes.
+// It is decompressed the first time its Bytes are asked for, rather than when
+// the package is initialized.
+type Module struct {
+	once sync.Once
+	s    string
+	b    []byte
+}
+
+// lazyModule returns the module stored in s, without decompressing it yet.
+func lazyModule(s string) *Module {
+	return &Module{s: s}
+}
+
+// loadedModule returns the module b, which isn't compressed, e.g. because it
+// is embedded as a file.
+func loadedModule(b []byte) *Module {
+	m := &Module{b: b}
+	m.once.Do(func() {})
+	return m
+}
+
+// Bytes returns the module, decompressing it on the first call. It is safe
+// for concurrent use, and gives nil for a nil Module.
+func (m *Module) Bytes() []byte {
+	if m == nil {
+		return nil
+	}
+	m.once.Do(func() { m.b = decodeModule(m.s) })
+	return m.b
+}
+
+// decodeModule returns the module stored in s as base64 of its DEFLATE
+// compressed bytes.
+func decodeModule(s string) []byte {
+	b, err := ioutil.ReadAll(flate.NewReader(base64.NewDecoder(base64.StdEncoding, strings.NewReader(s))))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+{{- end }}
 
 // ID is a unique ID for each compiled shader, which can be accessed via Shaders.
 type ID int
@@ -17,57 +130,454 @@ const (
 {{ range $i, $e := .ShaderIDs }}	{{ if $i }}{{ $e }}{{ else }}{{ $e }} = iota{{ end }}
 {{ end }})
 
+// Stage is the pipeline stage of a shader.
+type Stage int
+
+const (
+{{ range $i, $e := .Stages }}	Stage{{ $e }}{{ if not $i }} Stage = iota{{ end }}
+{{ end }}
+	StageUnknown Stage = -1 // precompiled module without a stage extension
+)
+
+var stageNames = [...]string{
+{{ range $e := .Stages }}	"{{ $e }}",
+{{ end }}}
+
+func (s Stage) String() string {
+	if s < 0 || int(s) >= len(stageNames) {
+		return "Unknown"
+	}
+	return stageNames[s]
+}
+
+var stageFlags = [...]uint32{
+{{ range $e := .Stages }}	{{ index $.StageFlags $e | printf "0x%08x" }}, // {{ $e }}
+{{ end }}}
+
+// VkFlag returns the VkShaderStageFlagBits value of the stage, e.g. 0x10
+// (VK_SHADER_STAGE_FRAGMENT_BIT) for StageFragment, or 0 for StageUnknown.
+func (s Stage) VkFlag() uint32 {
+	if s < 0 || int(s) >= len(stageFlags) {
+		return 0
+	}
+	return stageFlags[s]
+}
+
 // Shader contains binary and metadata for a compiled SPIR-V shader.
 type Shader struct{
-	Source string       // Source is the name of the GLSL source.
-	BinaryData []uint32 // BinaryData is the raw SPIR-V binary data.
+	Source string       // Source is the name of the GLSL source or precompiled module.
+	Stage Stage         // Stage is the pipeline stage of the shader.
+	BinaryData {{ .DataType }} // BinaryData is the raw SPIR-V binary data.
+{{- if .EmbedSource }}
+	Code string         // Code is the GLSL source the shader was compiled from.
+{{- end }}
+{{- if .Reflect }}
+
+	// SPVVersion is the SPIR-V version of the module, e.g. 0x00010500 for
+	// 1.5, and SPVVersionString the same as "1.5".
+	SPVVersion       uint32
+	SPVVersionString string
+
+	// PushConstantOffset and PushConstantSize give the range of the push
+	// constant block used by the shader, or zeros if it has none.
+	PushConstantOffset uint32
+	PushConstantSize   uint32
+
+	// EntryPoints lists the entry points of the module. Modules linked from
+	// several sources have one for each of them.
+	EntryPoints []EntryPoint
+
+	// Bindings lists the descriptor bindings of the module's resources, as
+	// given in the source or assigned with -auto-map-bindings.
+	Bindings []Binding
+
+	// SpecConstants lists the specialization constants of the module with
+	// their defaults, sorted by ID, e.g. for a VkSpecializationInfo.
+	SpecConstants []SpecConstant
+
+	// RequiredCapabilities lists the SPIR-V capabilities the module declares,
+	// e.g. "GroupNonUniform", which the device has to support.
+	RequiredCapabilities []string
+{{- end }}
+}
+{{- if .Reflect }}
+
+// EntryPoint is an entry point of a shader module, to be given as the name
+// and stage of a pipeline stage.
+type EntryPoint struct {
+	Name  string
+	Stage Stage
+}
+
+// Binding is the descriptor set and binding of a resource of a shader, named
+// after the resource variable or, for an unnamed block, the block.
+type Binding struct {
+	Set     uint32
+	Binding uint32
+	Name    string
+}
+
+// SpecConstant is a specialization constant of a shader and the default value
+// it has unless specialized.
+type SpecConstant struct {
+	ID      uint32 // the constant_id given in the source
+	Name    string
+	Type    string // bool for a VkBool32, or the Go type, e.g. int32 or float32
+	Size    uint32 // in bytes
+	Default uint64 // the raw bits of the default value
+}
+
+// Bytes returns the default value as Size little-endian bytes, the layout of
+// the data of a VkSpecializationInfo on little-endian hosts.
+func (c SpecConstant) Bytes() []byte {
+	b := make([]byte, c.Size)
+	for i := range b {
+		b[i] = byte(c.Default >> (8 * uint(i)))
+	}
+	return b
 }
+{{- end }}
 
 // Shaders contains all of the compiled shaders, accessible via IDs
 var Shaders = []Shader{
 {{ range $e := .Shaders }}	{
 		Source:     "{{ $e.Source }}",
+		Stage:      Stage{{ $e.Stage }},
 		BinaryData: {{ $e.BinaryData }},
+{{- if $.EmbedSource }}
+		Code:       {{ $e.ID }}Source,
+{{- end }}
+{{- if $.Reflect }}
+		SPVVersion:         {{ $e.ID }}SPVVersion,
+		SPVVersionString:   {{ $e.ID }}SPVVersionString,
+		PushConstantOffset: {{ $e.ID }}PushConstantOffset,
+		PushConstantSize:   {{ $e.ID }}PushConstantSize,
+		EntryPoints:        {{ $e.ID }}EntryPoints,
+		Bindings:           {{ $e.ID }}Bindings,
+		SpecConstants:      {{ $e.ID }}SpecConstants,
+		RequiredCapabilities: {{ $e.ID }}RequiredCapabilities,
+{{- end }}
 	},
 {{ end }}}
+
+var shaderIndex = map[string]ID{
+{{ range $i, $e := .Shaders }}	"{{ $e.Source }}": {{ index $.ShaderIDs $i }},
+{{ end }}}
+
+// Get returns the binary data and stage of the shader compiled from the named
+// source file. The boolean is false if there is no such shader.
+func Get(name string) ({{ .DataType }}, Stage, bool) {
+	id, ok := shaderIndex[name]
+	if !ok {
+		return Shader{}.BinaryData, 0, false
+	}
+	return Shaders[id].BinaryData, Shaders[id].Stage, true
+}
+{{- if .ByPath }}
+
+// ShadersByPath maps the paths of the sources, relative to the source
+// directory and with forward slashes, to the binary data of their shaders.
+var ShadersByPath = map[string]{{ .DataType }}{
+{{ range $e := .Shaders }}	"{{ $e.Source }}": {{ $e.BinaryData }},
+{{ end }}}
+{{- end }}
+{{- if .Checksums }}
+
+// ShaderChecksums maps the names of the sources to the SHA-256 of their
+// modules, taken over the little-endian bytes of the binary data.
+var ShaderChecksums = map[string][32]byte{
+{{ range $e := .Shaders }}	"{{ $e.Source }}": {{ $e.ID }}Checksum,
+{{ end }}}
+
+// Verify returns true if data is the module of the shader compiled from the
+// named source file, e.g. after loading it from a cache or over the network.
+// It is false for an unknown name.
+func Verify(name string, data []byte) bool {
+	sum, ok := ShaderChecksums[name]
+	return ok && sha256.Sum256(data) == sum
+}
+{{- end }}
+{{- if .Targets }}
+
+// Targets lists the target environments that every shader was also compiled
+// for with -multi-target.
+var Targets = []string{ {{- range $i, $t := .Targets }}{{ if $i }}, {{ end }}"{{ $t }}"{{ end }}}
+
+// targetData holds the modules compiled for each of Targets, in the same
+// order. Precompiled modules are the same for every target.
+var targetData = map[string][]{{ .DataType }}{
+{{ range $e := .Shaders }}{{ if $e.TargetData }}	"{{ $e.Source }}": { {{- range $i, $d := $e.TargetData }}{{ if $i }}, {{ end }}{{ $d }}{{ end }}},
+{{ end }}{{ end }}}
+
+// GetTarget returns the binary data and stage of the shader compiled from the
+// named source file for the target environment, e.g. "vulkan1.2". The boolean
+// is false if there is no such shader or target.
+func GetTarget(name, target string) ({{ .DataType }}, Stage, bool) {
+	data, stage, ok := Get(name)
+	if !ok {
+		return data, stage, false
+	}
+	for i, t := range Targets {
+		if t == target {
+			if modules, found := targetData[name]; found {
+				return modules[i], stage, true
+			}
+			return data, stage, true
+		}
+	}
+	return Shader{}.BinaryData, 0, false
+}
+{{- end }}
+{{- if .Variants }}
+
+// Variants lists the variants declared by the shaders with a "// spv:variant"
+// comment, e.g. "nvidia", for choosing one by the GPU at runtime.
+var Variants = []string{ {{- range $i, $v := .Variants }}{{ if $i }}, {{ end }}"{{ $v }}"{{ end }}}
+
+// variantData holds the modules of the variants of the shaders that have any,
+// by source and variant.
+var variantData = map[string]map[string]{{ .DataType }}{
+{{ range $e := .Shaders }}{{ if $e.VariantData }}	"{{ $e.Source }}": { {{- range $i, $v := $e.VariantData }}{{ if $i }}, {{ end }}"{{ $v.Name }}": {{ $v.Data }}{{ end }}},
+{{ end }}{{ end }}}
+
+// GetVariant returns the binary data and stage of the shader compiled from the
+// named source file for the variant, e.g. "nvidia". A shader without that
+// variant gives its default module, as does the variant "". The boolean is
+// false if there is no such shader.
+func GetVariant(name, variant string) ({{ .DataType }}, Stage, bool) {
+	data, stage, ok := Get(name)
+	if !ok {
+		return data, stage, false
+	}
+	if d, found := variantData[name][variant]; found {
+		return d, stage, true
+	}
+	return data, stage, true
+}
+{{- end }}
+
+{{- if .Groups }}
+
+// ShaderGroup lists the IDs of related shaders.
+type ShaderGroup []ID
+
+// Groups contains the shaders of each group declared with a "// spv:group"
+// comment in their sources. Shaders without one are in Default.
+var Groups = struct {
+{{ range $g := .Groups }}	{{ $g.Name }} ShaderGroup
+{{ end }}}{
+{{ range $g := .Groups }}	{{ $g.Name }}: ShaderGroup{ {{- range $i, $id := $g.IDs }}{{ if $i }}, {{ end }}{{ $id }}{{ end }}},
+{{ end }}}
+{{- end }}
+{{- if .LinkGroups }}
+
+// LinkGroup is a group of shaders declared with a "// spv:link-group" comment
+// in their sources, such as the shaders of a ray tracing hit group. The
+// indices are into the group's Shaders, and -1 where it has no such shader,
+// which converted to uint32 is VK_SHADER_UNUSED_KHR.
+type LinkGroup struct {
+	Name         string
+	Shaders      []ID // in the order of the Stage constants
+	General      int  // ray generation, miss or callable shader
+	ClosestHit   int
+	AnyHit       int
+	Intersection int
+}
+
+// LinkGroups contains the link groups sorted by name.
+var LinkGroups = []LinkGroup{
+{{ range $g := .LinkGroups }}	{
+		Name:         "{{ $g.Name }}",
+		Shaders:      []ID{ {{- range $i, $id := $g.IDs }}{{ if $i }}, {{ end }}{{ $id }}{{ end }}},
+		General:      {{ $g.General }},
+		ClosestHit:   {{ $g.ClosestHit }},
+		AnyHit:       {{ $g.AnyHit }},
+		Intersection: {{ $g.Intersection }},
+	},
+{{ end }}}
+{{- end }}
+{{- if .SourceMeta }}
+
+// Meta identifies the revision of the source a shader was generated from.
+type Meta struct {
+	// Hash is the SHA-256 of the source, its includes, its stage and the
+	// arguments for that stage, or of the module if it was precompiled.
+	Hash string
+}
+
+// ShaderMeta maps the names of the sources to the metadata of their shaders.
+var ShaderMeta = map[string]Meta{
+{{ range $e := .Shaders }}	"{{ $e.Source }}": {Hash: "{{ $e.Hash }}"},
+{{ end }}}
+{{- end }}
 `
 
 func writeManifest() int {
-	file, err := os.Create(manifestFilename)
+	if err := updateBlob(); err != nil {
+		fmt.Printf("%s error: Cannot write %s: %v\n", os.Args[0], blobPath(), err)
+		return 1
+	}
+
+	parts, err := manifestParts()
 	if err != nil {
-		fmt.Println("Error creating manifest file:", err)
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	for _, part := range parts {
+		written, err := writeFileIfChanged(part.path, part.execute)
+		if err != nil {
+			fmt.Println("Error executing template:", err)
+			return 1
+		}
+		reportWritten(part.path, written)
+	}
+	if err := removeTaggedManifests(parts); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
 		return 1
 	}
-	defer file.Close()
 
+	if genTests {
+		written, err := writeFileIfChanged(testPath(), executeTest)
+		if err != nil {
+			fmt.Printf("%s error: Cannot write %s: %v\n", os.Args[0], testPath(), err)
+			return 1
+		}
+		reportWritten(testPath(), written)
+	}
+
+	if internal {
+		written, err := writeFileIfChanged(manifestFilename, executeFacade)
+		if err != nil {
+			fmt.Printf("%s error: Cannot write %s: %v\n", os.Args[0], manifestFilename, err)
+			return 1
+		}
+		reportWritten(manifestFilename, written)
+	}
+
+	return 0
+}
+
+// executeManifest writes the manifest into w.
+func executeManifest(w *bufio.Writer) error {
 	tmpl := template.Must(template.New("manifest").Parse(manifestTemplate))
 
 	var tmplData struct {
-		Package   string
-		ShaderIDs []string
-		Shaders   []struct {
-			Source     string
-			BinaryData string
+		Package     string
+		DataType    string
+		ByteImport  string // package of DataType with -byte-type
+		EmbedDir    string // if any module is embedded
+		EmbedFS     bool   // with -as fs and any module embedded
+		Compressed  bool   // with -as compressed
+		Reflect     bool
+		EmbedSource bool
+		Checksums   bool
+		ByPath      bool
+		SourceMeta  bool
+		Targets     []string // with -multi-target
+		Variants    []string // of all shaders
+		ShaderIDs   []string
+		Stages      []string
+		StageFlags  map[string]uint32
+		Shaders     []struct {
+			ID          string
+			Source      string
+			Stage       string
+			BinaryData  string
+			Hash        string   // with -source-meta
+			TargetData  []string // with -multi-target
+			VariantData []variantModule
 		}
+		Groups     []shaderGroupIDs
+		LinkGroups []linkGroup
 	}
 
-	tmplData.Package = pkg
+	tmplData.Package = dataPackage()
+	tmplData.DataType = dataType()
+	if anyEmbedded() {
+		tmplData.EmbedDir = embedDir
+		tmplData.EmbedFS = outputMode == "fs"
+	}
+	if byteOutput() {
+		tmplData.ByteImport = byteTypeImport
+	}
+	tmplData.Compressed = outputMode == "compressed"
+	tmplData.Reflect = reflect
+	tmplData.EmbedSource = embedSource
+	tmplData.Checksums = checksums
+	tmplData.ByPath = byPath
+	tmplData.SourceMeta = sourceMeta
+	tmplData.Targets = multiTarget
+	tmplData.Stages = stages
+	tmplData.StageFlags = vkStageFlags
 
+	var variants [][]variantModule
 	for _, src := range filesTotal {
+		var hash string
+		if sourceMeta {
+			var err error
+			if hash, err = sourceHash(src); err != nil {
+				return err
+			}
+		}
+		vs, err := variantModules(src)
+		if err != nil {
+			return fmt.Errorf("%s: %v", src, err)
+		}
+		variants = append(variants, vs)
 		tmplData.ShaderIDs = append(tmplData.ShaderIDs, makeIdentifier(src))
-		tmplData.Shaders = append(tmplData.Shaders, struct{ Source, BinaryData string }{
-			Source:     src,
-			BinaryData: makeSliceIdentifier(src),
+		tmplData.Shaders = append(tmplData.Shaders, struct {
+			ID, Source, Stage, BinaryData, Hash string
+			TargetData                          []string
+			VariantData                         []variantModule
+		}{
+			ID:          makeIdentifier(src),
+			Source:      src,
+			Stage:       stageOf(src),
+			BinaryData:  makeSliceIdentifier(src),
+			Hash:        hash,
+			TargetData:  targetModules(src),
+			VariantData: vs,
 		})
 	}
+	tmplData.Variants = allVariants(variants)
 
 	tmplData.ShaderIDs = append(tmplData.ShaderIDs, "NumShaders")
 
-	err = tmpl.Execute(file, tmplData)
+	groups, err := groupShaders()
 	if err != nil {
-		fmt.Println("Error executing template:", err)
-		return 1
+		return err
 	}
+	tmplData.Groups = groups
+
+	if tmplData.LinkGroups, err = linkGroups(); err != nil {
+		return err
+	}
+
+	writeHeader(w)
+	return tmpl.Execute(w, tmplData)
+}
 
+// removeManifest removes a manifest generated before -no-manifest was used,
+// as it may refer to shaders that no longer exist, along with the ones for
+// build constraints.
+func removeManifest() int {
+	if err := removeTaggedManifests(nil); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	if !manifestFound {
+		return 0
+	}
+	if !hasGeneratedHeader(manifestPath()) {
+		fmt.Printf("%s: keeping %s, it was not generated by spv\n", os.Args[0], manifestPath())
+		return 0
+	}
+	if err := os.Remove(manifestPath()); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	if verbosity >= 1 {
+		fmt.Printf("%s: removed %s\n", os.Args[0], manifestPath())
+	}
 	return 0
 }
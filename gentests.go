@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"path"
+	"text/template"
+)
+
+// testFilename is the test generated with -gen-tests next to the manifest.
+const testFilename = "shaders_gen_test.go"
+
+const testTemplate = `// Code generated by github.com/jclc/spv. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestSPIRVModules checks the header of every embedded module, and validates
+// it completely with spirv-val if it is installed.
+func TestSPIRVModules(t *testing.T) {
+	validator, err := exec.LookPath("spirv-val")
+	if err != nil {
+		t.Log("spirv-val not found; only checking the module headers")
+	}
+
+	for name, id := range shaderIndex {
+		b := spirvModuleBytes(Shaders[id].BinaryData)
+		t.Run(name, func(t *testing.T) {
+			if len(b) < 20 || len(b)%4 != 0 {
+				t.Fatalf("invalid module size of %d bytes", len(b))
+			}
+			if magic := binary.LittleEndian.Uint32(b); magic != 0x07230203 {
+				t.Fatalf("invalid magic number %#08x", magic)
+			}
+			version := binary.LittleEndian.Uint32(b[4:])
+			if major, minor := version>>16&0xff, version>>8&0xff; major != 1 || minor > 6 || version&0xff0000ff != 0 {
+				t.Fatalf("invalid SPIR-V version %#08x", version)
+			}
+			if validator == "" {
+				return
+			}
+
+			dir, err := ioutil.TempDir("", "spv-test-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			file := filepath.Join(dir, "module.spv")
+			if err := ioutil.WriteFile(file, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if out, err := exec.Command(validator, file).CombinedOutput(); err != nil {
+				t.Fatalf("spirv-val: %v\n%s", err, out)
+			}
+		})
+	}
+}
+
+// spirvModuleBytes returns the module in little-endian byte order.
+{{- if eq .DataType "string" }}
+func spirvModuleBytes(data string) []byte {
+	return []byte(data)
+}
+{{- else if eq .DataType "[]byte" }}
+func spirvModuleBytes(data []byte) []byte {
+	return data
+}
+{{- else if eq .DataType "*Module" }}
+func spirvModuleBytes(data *Module) []byte {
+	return data.Bytes()
+}
+{{- else }}
+func spirvModuleBytes(data []uint32) []byte {
+	b := make([]byte, 4*len(data))
+	for i, w := range data {
+		binary.LittleEndian.PutUint32(b[4*i:], w)
+	}
+	return b
+}
+{{- end }}
+`
+
+// testPath returns the path of the test generated with -gen-tests.
+func testPath() string {
+	return path.Join(outputDir(), testFilename)
+}
+
+// executeTest writes the test checking the embedded modules into w.
+func executeTest(w *bufio.Writer) error {
+	tmpl := template.Must(template.New("test").Parse(testTemplate))
+	writeHeader(w)
+	return tmpl.Execute(w, struct{ Package, DataType string }{dataPackage(), outputModes[outputMode]})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// goMinor is the minor version of Go the generated code has to compile with,
+// from -go-version or the go directive of the nearest go.mod, or 0 if unknown.
+var goMinor int
+
+var goVersionPattern = regexp.MustCompile(`^(?:go)?1\.(\d+)(?:\.\d+)?$`)
+
+// parseGoVersion returns the minor version of a Go version such as 1.16,
+// go1.16 or 1.21.3.
+func parseGoVersion(v string) (int, error) {
+	m := goVersionPattern.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return 0, fmt.Errorf("invalid Go version %q; use e.g. 1.16", v)
+	}
+	return strconv.Atoi(m[1])
+}
+
+// detectGoVersion sets goMinor from -go-version, or else from the go
+// directive of the go.mod file containing the current directory.
+func detectGoVersion() error {
+	goMinor = 0
+	if goVersion != "" {
+		minor, err := parseGoVersion(goVersion)
+		goMinor = minor
+		return err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for d := wd; ; d = filepath.Dir(d) {
+		data, err := ioutil.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) >= 2 && fields[0] == "go" {
+					minor, err := parseGoVersion(fields[1])
+					goMinor = minor
+					return err
+				}
+			}
+			return nil
+		}
+		if filepath.Dir(d) == d {
+			return nil
+		}
+	}
+}
+
+// goVersionAtLeast returns true if the generated code may use features of Go
+// 1.minor. Without a known version the latest features are allowed.
+func goVersionAtLeast(minor int) bool {
+	return goMinor == 0 || goMinor >= minor
+}
+
+// embedMinor is the minor version of Go that embed needs.
+const embedMinor = 16
+
+// checkGoVersion returns an error if a flag needs a newer Go version than the
+// generated code has to compile with and there is nothing to fall back to.
+func checkGoVersion() error {
+	if genTests && !goVersionAtLeast(7) {
+		return fmt.Errorf("-gen-tests needs Go 1.7 for subtests, but the target is Go 1.%d", goMinor)
+	}
+	if internal && !goVersionAtLeast(9) {
+		return fmt.Errorf("-internal needs Go 1.9 for type aliases, but the target is Go 1.%d", goMinor)
+	}
+	return nil
+}
+
+// goVersionFallbacks describes the flags that the generated code can't
+// follow as given because of the Go version it has to compile with, and what
+// it does instead.
+func goVersionFallbacks() []string {
+	var fallbacks []string
+	if outputMode == "fs" && !goVersionAtLeast(embedMinor) {
+		fallbacks = append(fallbacks, fmt.Sprintf("-as fs needs Go 1.%d for embed, but the target is Go 1.%d; the modules are written as %s literals instead",
+			embedMinor, goMinor, byteTypeName))
+	}
+	return fallbacks
+}
+
+// requireGo returns the build constraint e, which may be nil, with the
+// release tag of Go 1.minor added if needed is set, for generated files using
+// features of that version.
+func requireGo(e *buildExpr, needed bool, minor int) *buildExpr {
+	if !needed {
+		return e
+	}
+	tag := &buildExpr{op: "tag", tag: fmt.Sprintf("go1.%d", minor)}
+	if e == nil {
+		return tag
+	}
+	return &buildExpr{op: "&&", args: []*buildExpr{tag, e}}
+}
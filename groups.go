@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// defaultGroup is the group of shaders without a "// spv:group" directive.
+const defaultGroup = "Default"
+
+var groupName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// shaderGroupIDs is a group in the manifest.
+type shaderGroupIDs struct {
+	Name string   // field name in Groups
+	IDs  []string // identifiers of the shaders in the group
+}
+
+// shaderGroup returns the group declared in the source src, or "" if there
+// is none. Precompiled modules have no group.
+func shaderGroup(src string) (string, error) {
+	if isSPIRVFile(src) {
+		return "", nil
+	}
+	directives, err := sourceDirectives(src)
+	if err != nil {
+		return "", err
+	}
+	group, found := directives["group"]
+	if !found {
+		return "", nil
+	}
+	if !groupName.MatchString(group) {
+		return "", fmt.Errorf("invalid group name %q; use letters, digits and underscores", group)
+	}
+	return makeIdentifier(group), nil
+}
+
+// groupShaders returns the groups of the shaders in filesTotal sorted by
+// name, or nothing if no source declares a group.
+func groupShaders() ([]shaderGroupIDs, error) {
+	byGroup := make(map[string][]string)
+	declared := false
+	for _, src := range filesTotal {
+		group, err := shaderGroup(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", src, err)
+		}
+		if group != "" {
+			declared = true
+		} else {
+			group = defaultGroup
+		}
+		byGroup[group] = append(byGroup[group], makeIdentifier(src))
+	}
+	if !declared {
+		return nil, nil
+	}
+
+	var groups []shaderGroupIDs
+	for name, ids := range byGroup {
+		groups = append(groups, shaderGroupIDs{name, ids})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// headerFile is the file given with -header-file, whose text is put atop every
+// generated Go file, e.g. an SPDX license identifier.
+var headerFile string
+
+// header is the comment block made from headerFile, ending in a blank line, or
+// "" without one.
+var header string
+
+// loadHeader reads headerFile into header. Lines that aren't comments already
+// are turned into // comments, so plain license texts can be used as they
+// are.
+func loadHeader() error {
+	header = ""
+	if headerFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(headerFile)
+	if err != nil {
+		return fmt.Errorf("cannot read -header-file: %v", err)
+	}
+	text := strings.TrimRight(strings.ReplaceAll(string(data), "\r", ""), " \t\n")
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.Split(text, "\n")
+	block := strings.HasPrefix(lines[0], "/*") && strings.HasSuffix(lines[len(lines)-1], "*/")
+	var sb strings.Builder
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "//go:build") || strings.HasPrefix(trimmed, "// +build") {
+			return fmt.Errorf("-header-file %s contains a build constraint, which would apply to the generated files", headerFile)
+		}
+		switch {
+		case block || strings.HasPrefix(trimmed, "//"):
+		case line == "":
+			line = "//"
+		default:
+			line = "// " + line
+		}
+		sb.WriteString(line + "\n")
+	}
+	// Separated from the generated comment, so that it isn't a doc comment
+	sb.WriteString("\n")
+	header = sb.String()
+	return nil
+}
+
+// writeHeader writes the header, if any, at the start of a generated file.
+func writeHeader(w *bufio.Writer) {
+	w.WriteString(header)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// skipIdentical leaves generated files alone when a fresh build produces
+// exactly what they already hold, e.g. after -force, so that their
+// modification times and the git status stay clean.
+var skipIdentical bool
+
+// writeFileIfChanged is writeFileAtomic, except that with -skip-identical a
+// file that already holds the same bytes isn't rewritten. It returns whether
+// the file was written.
+func writeFileIfChanged(name string, write func(*bufio.Writer) error) (bool, error) {
+	if !skipIdentical {
+		return true, writeFileAtomic(name, write)
+	}
+	return writeIfChanged(name, func(f io.Writer) error {
+		return writeText(f, write)
+	})
+}
+
+// writeBytesIfChanged writes data into the file name atomically, unless
+// -skip-identical is given and the file already holds data. It returns
+// whether the file was written.
+func writeBytesIfChanged(name string, data []byte) (bool, error) {
+	if !skipIdentical {
+		return true, writeAtomic(name, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+	return writeIfChanged(name, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeIfChanged writes the file name with write into a temporary file,
+// comparing the output with the file as it goes, and only renames it over
+// the file if they differ. Neither is held in memory, however large.
+func writeIfChanged(name string, write func(io.Writer) error) (bool, error) {
+	cmp := &compareWriter{}
+	if old, err := os.Open(name); err == nil {
+		defer old.Close()
+		cmp.r, cmp.same = bufio.NewReader(old), true
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	return replaceFile(name, func(f io.Writer) (bool, error) {
+		if err := write(io.MultiWriter(f, cmp)); err != nil {
+			return false, err
+		}
+		return !cmp.done(), nil
+	})
+}
+
+// compareWriter compares the bytes written to it with those read from r, a
+// chunk at a time.
+type compareWriter struct {
+	r    io.Reader
+	same bool // everything written so far matched
+	buf  []byte
+}
+
+func (c *compareWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if c.buf == nil {
+		c.buf = make([]byte, 32*1024)
+	}
+	for c.same && len(p) > 0 {
+		chunk := p
+		if len(chunk) > len(c.buf) {
+			chunk = chunk[:len(c.buf)]
+		}
+		_, err := io.ReadFull(c.r, c.buf[:len(chunk)])
+		c.same = err == nil && bytes.Equal(c.buf[:len(chunk)], chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// done returns true if r held exactly what was written.
+func (c *compareWriter) done() bool {
+	if !c.same {
+		return false
+	}
+	var b [1]byte
+	n, _ := c.r.Read(b[:])
+	return n == 0
+}
+
+// reportWritten tells with -skip-identical and -verbose whether the file name
+// was written or left alone, for the files written after the sources.
+func reportWritten(name string, written bool) {
+	if !skipIdentical {
+		return
+	}
+	if written {
+		printStatus(status{1, fmt.Sprintf("wrote %s", name), false, ""})
+	} else {
+		printStatus(status{1, fmt.Sprintf("%s is identical to a fresh build; not rewritten", name), false, ""})
+	}
+}
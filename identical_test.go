@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkWriteFileIfChanged writes a large generated file over an identical
+// one with -skip-identical, streaming it as writeFileIfChanged does and, for
+// comparison, rendering it into memory first. The allocations show what the
+// streaming saves.
+func BenchmarkWriteFileIfChanged(b *testing.B) {
+	dir, err := ioutil.TempDir("", "spv-bench-*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "large.gen.go")
+
+	words := make([]uint32, 1<<20) // a 4 MiB module
+	for i := range words {
+		words[i] = uint32(i) * 2654435761
+	}
+	write := func(w *bufio.Writer) error {
+		w.WriteString("package x\n\nvar data = ")
+		writeStringLiteral(w, words, 8)
+		w.WriteString("\n")
+		return nil
+	}
+	if err := writeFileAtomic(name, write); err != nil {
+		b.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	skipIdentical = true
+	defer func() { skipIdentical = false }()
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(fi.Size())
+		for i := 0; i < b.N; i++ {
+			if written, err := writeFileIfChanged(name, write); err != nil || written {
+				b.Fatal(written, err)
+			}
+		}
+	})
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(fi.Size())
+		for i := 0; i < b.N; i++ {
+			data, err := renderFile(write)
+			if err != nil {
+				b.Fatal(err)
+			}
+			have, err := ioutil.ReadFile(name)
+			if err != nil || !bytes.Equal(have, data) {
+				b.Fatal("not identical", err)
+			}
+		}
+	})
+}
+
+func TestWriteFileIfChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	skipIdentical = true
+	defer func() { skipIdentical = false }()
+
+	long := string(bytes.Repeat([]byte("0123456789abcdef"), 8192))
+	tests := []struct {
+		name, old, content string
+		written            bool
+	}{
+		{"missing", "", "package x\n", true},
+		{"identical", "package x\n", "package x\n", false},
+		{"identical after EOL", "package x\n", "package x\r\n\n\n", false},
+		{"different", "package x\n", "package y\n", true},
+		{"longer", "package x\n", "package x\n\nvar y int\n", true},
+		{"shorter", "package x\n\nvar y int\n", "package x\n", true},
+		{"large identical", long + "\n", long, false},
+		{"large differing at the end", long + "\n", long[:len(long)-1] + "!", true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, string(rune('a'+i))+".gen.go")
+			if tt.old != "" {
+				if err := ioutil.WriteFile(name, []byte(tt.old), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			written, err := writeFileIfChanged(name, func(w *bufio.Writer) error {
+				_, err := w.WriteString(tt.content)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != tt.written {
+				t.Errorf("written = %v, want %v", written, tt.written)
+			}
+			want, err := renderFile(func(w *bufio.Writer) error {
+				_, err := w.WriteString(tt.content)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have, err := ioutil.ReadFile(name); err != nil || !bytes.Equal(have, want) {
+				t.Errorf("file holds %.40q, want %.40q (%v)", have, want, err)
+			}
+			if tmps, _ := filepath.Glob(filepath.Join(dir, ".*.tmp*")); len(tmps) > 0 {
+				t.Errorf("temporary files left: %v", tmps)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const generateDirective = "//go:generate spv"
+
+// initPackage adds a go:generate directive for the tool to doc.go in the
+// current directory unless some Go file there already has one.
+func initPackage() int {
+	name := pkg
+	if name == "" {
+		var err error
+		name, err = detectPackage()
+		if err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+	}
+
+	goFiles, _ := filepath.Glob("*.go")
+	for _, f := range goFiles {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+		if bytes.Contains(data, []byte(generateDirective+" ")) || bytes.HasSuffix(data, []byte(generateDirective)) {
+			fmt.Printf("%s: %s already contains a go:generate directive\n", os.Args[0], f)
+			return 0
+		}
+	}
+
+	directive := generateDirective + " -pkg " + name
+	if ccArgs != "" {
+		directive += " -args " + strconv.Quote(ccArgs)
+	}
+
+	const docFile = "doc.go"
+	data, err := ioutil.ReadFile(docFile)
+	switch {
+	case os.IsNotExist(err):
+		data = []byte(fmt.Sprintf("// Package %s contains SPIR-V shaders compiled from GLSL.\npackage %s\n", name, name))
+	case err != nil:
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, "\n"+directive+"\n"...)
+
+	if err := ioutil.WriteFile(docFile, data, 0644); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	fmt.Printf("%s: added %q to %s\n", os.Args[0], directive, docFile)
+	return 0
+}
+
+// detectPackage returns the package name of the Go files in the current
+// directory or, if there are none, a name derived from the directory name.
+func detectPackage() (string, error) {
+	goFiles, _ := filepath.Glob("*.go")
+	fset := token.NewFileSet()
+	for _, f := range goFiles {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, f, nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		return file.Name.Name, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	var name []rune
+	for _, r := range strings.ToLower(filepath.Base(wd)) {
+		if unicode.IsLetter(r) || r == '_' || (unicode.IsDigit(r) && len(name) > 0) {
+			name = append(name, r)
+		}
+	}
+	if len(name) == 0 {
+		return "", errors.New("cannot determine a package name; use -pkg")
+	}
+	return string(name), nil
+}
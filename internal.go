@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const facadeTemplate = `// Code generated by github.com/jclc/spv. DO NOT EDIT.
+
+package {{.Package}}
+{{- if .ByteImport }}
+
+import (
+	"{{ .ByteImport }}"
+
+	"{{ .Import }}"
+)
+{{- else }}
+
+import "{{.Import}}"
+{{- end }}
+
+// Shader contains binary and metadata for a compiled SPIR-V shader.
+type Shader = shaders.Shader
+
+// Stage is the pipeline stage of a shader.
+type Stage = shaders.Stage
+{{- if .Compressed }}
+
+// Module is a compressed SPIR-V module, decompressed by its Bytes method.
+type Module = shaders.Module
+{{- end }}
+{{- if .EmbedFS }}
+
+// FS contains the SPIR-V modules named after their sources, e.g.
+// "foo.frag.spv".
+var FS = shaders.FS
+{{- end }}
+{{- if .Reflect }}
+
+// EntryPoint is an entry point of a shader module.
+type EntryPoint = shaders.EntryPoint
+
+// Binding is the descriptor set and binding of a resource of a shader.
+type Binding = shaders.Binding
+
+// SpecConstant is a specialization constant of a shader and its default.
+type SpecConstant = shaders.SpecConstant
+{{- end }}
+
+const (
+{{ range $e := .Stages }}	Stage{{ $e }} = shaders.Stage{{ $e }}
+{{ end }}	StageUnknown = shaders.StageUnknown
+)
+
+// The exported shaders.
+var (
+{{ range $e := .Shaders }}	{{ $e }} = shaders.Shaders[shaders.{{ $e }}]
+{{ end }})
+
+// Get returns the binary data and stage of the exported shader compiled from
+// the named source file. The boolean is false if there is no such shader.
+func Get(name string) ({{ .DataType }}, Stage, bool) {
+	switch name {
+{{- if .Sources }}
+	case {{ range $i, $e := .Sources }}{{ if $i }}, {{ end }}"{{ $e }}"{{ end }}:
+		return shaders.Get(name)
+{{- end }}
+	}
+	return Shader{}.BinaryData, 0, false
+}
+{{- if .ByPath }}
+
+// ShadersByPath maps the paths of the exported sources to the binary data of
+// their shaders.
+var ShadersByPath = map[string]{{ .DataType }}{
+{{ range $e := .Sources }}	"{{ $e }}": shaders.ShadersByPath["{{ $e }}"],
+{{ end }}}
+{{- end }}
+{{- if .Checksums }}
+
+// Verify returns true if data is the module of the exported shader compiled
+// from the named source file.
+func Verify(name string, data []byte) bool {
+	switch name {
+{{- if .Sources }}
+	case {{ range $i, $e := .Sources }}{{ if $i }}, {{ end }}"{{ $e }}"{{ end }}:
+		return shaders.Verify(name, data)
+{{- end }}
+	}
+	return false
+}
+{{- end }}
+`
+
+// scanOutputDir adds the files generated into the output directory to
+// generated and notes whether the manifest exists.
+func scanOutputDir(generated map[string]e) error {
+	fs, err := ioutil.ReadDir(outputDir())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		filename := path.Join(outputDir(), f.Name())
+		switch {
+		case filename == manifestPath():
+			manifestFound = true
+		case isGeneratedFromGLSL(filename):
+			generated[filename] = e{}
+		}
+	}
+	return nil
+}
+
+// executeFacade writes the public manifest of -internal into w, exporting
+// the shaders whose sources have a "// spv:export" directive from the
+// internal package.
+func executeFacade(w *bufio.Writer) error {
+	imp, err := internalImportPath()
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Package    string
+		Import     string
+		DataType   string
+		ByteImport string
+		Reflect    bool
+		Checksums  bool
+		ByPath     bool
+		EmbedFS    bool
+		Compressed bool
+		Stages     []string
+		Shaders    []string
+		Sources    []string
+	}
+	data.Package = pkg
+	data.Import = imp
+	data.DataType = dataType()
+	if byteOutput() {
+		data.ByteImport = byteTypeImport
+	}
+	data.Reflect = reflect
+	data.Checksums = checksums
+	data.ByPath = byPath
+	data.EmbedFS = outputMode == "fs" && anyEmbedded()
+	data.Compressed = outputMode == "compressed"
+	data.Stages = stages
+
+	for _, src := range filesTotal {
+		if isSPIRVFile(src) {
+			continue // can't be marked
+		}
+		directives, err := sourceDirectives(src)
+		if err != nil {
+			return err
+		}
+		if _, found := directives["export"]; found {
+			data.Shaders = append(data.Shaders, makeIdentifier(src))
+			data.Sources = append(data.Sources, src)
+		}
+	}
+
+	tmpl := template.Must(template.New("facade").Parse(facadeTemplate))
+	writeHeader(w)
+	return tmpl.Execute(w, data)
+}
+
+// internalImportPath returns the import path of the internal package, based
+// on the module path in the go.mod file containing the source directory.
+func internalImportPath() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for d := wd; ; d = filepath.Dir(d) {
+		data, err := ioutil.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			mod := modulePath(data)
+			if mod == "" {
+				return "", fmt.Errorf("no module path in %s", filepath.Join(d, "go.mod"))
+			}
+			rel, err := filepath.Rel(d, wd)
+			if err != nil {
+				return "", err
+			}
+			return path.Join(mod, filepath.ToSlash(rel), internalDir), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		if filepath.Dir(d) == d {
+			return "", errors.New("-internal needs a go.mod file to import the internal package")
+		}
+	}
+}
+
+// modulePath returns the module path declared in the go.mod file contents.
+func modulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,19 @@
+package main
+
+// relaxedErrors and suppressWarnings pass glslangValidator's --relaxed-errors
+// and --suppress-warnings, to bring in older GLSL that the compiler would
+// otherwise reject or flood with warnings before it is rewritten.
+var relaxedErrors, suppressWarnings bool
+
+// legacyArgs returns the compiler arguments for -relaxed and
+// -suppress-warnings.
+func legacyArgs() []string {
+	var args []string
+	if relaxedErrors {
+		args = append(args, "--relaxed-errors")
+	}
+	if suppressWarnings {
+		args = append(args, "--suppress-warnings")
+	}
+	return args
+}
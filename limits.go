@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// checkLimits warns about shaders that use more resources than every Vulkan
+// device supports, as given by the minimums the specification guarantees or
+// by -limit.
+var checkLimits bool
+
+// limitArgs holds the -limit overrides, e.g. "storage-buffers=8".
+var limitArgs stringList
+
+// deviceLimit is a limit of VkPhysicalDeviceLimits checked by -check-limits.
+type deviceLimit struct {
+	name   string // for -limit
+	vkName string
+	what   string // what is counted, for the warnings
+	min    uint32 // the minimum the Vulkan specification guarantees
+}
+
+var deviceLimits = []deviceLimit{
+	{"samplers", "maxPerStageDescriptorSamplers", "samplers", 16},
+	{"uniform-buffers", "maxPerStageDescriptorUniformBuffers", "uniform buffers", 12},
+	{"storage-buffers", "maxPerStageDescriptorStorageBuffers", "storage buffers", 4},
+	{"sampled-images", "maxPerStageDescriptorSampledImages", "sampled images", 16},
+	{"storage-images", "maxPerStageDescriptorStorageImages", "storage images", 4},
+	{"input-attachments", "maxPerStageDescriptorInputAttachments", "input attachments", 4},
+	{"resources", "maxPerStageResources", "resources", 128},
+	{"descriptor-sets", "maxBoundDescriptorSets", "descriptor sets", 4},
+	{"push-constants", "maxPushConstantsSize", "bytes of push constants", 128},
+}
+
+// limitCounts maps the kinds of descriptors to the per-stage limits they
+// count against. Combined image samplers count as both samplers and sampled
+// images, and every descriptor counts against the resources.
+var limitCounts = map[string][]string{
+	"sampler":                {"samplers"},
+	"combined image sampler": {"samplers", "sampled-images"},
+	"sampled image":          {"sampled-images"},
+	"uniform texel buffer":   {"sampled-images"},
+	"storage image":          {"storage-images"},
+	"storage texel buffer":   {"storage-images"},
+	"uniform buffer":         {"uniform-buffers"},
+	"storage buffer":         {"storage-buffers"},
+	"input attachment":       {"input-attachments"},
+}
+
+// limits holds the value of each limit by name, after checkLimitArgs.
+var limits map[string]uint32
+
+// checkLimitArgs sets limits from the specification's minimums and the -limit
+// overrides. Giving -limit turns on -check-limits.
+func checkLimitArgs() error {
+	limits = make(map[string]uint32)
+	for _, l := range deviceLimits {
+		limits[l.name] = l.min
+	}
+	for _, arg := range limitArgs {
+		i := strings.IndexByte(arg, '=')
+		if i < 0 {
+			return fmt.Errorf("invalid -limit %q; expected name=value, e.g. storage-buffers=8", arg)
+		}
+		name := arg[:i]
+		if _, found := limits[name]; !found {
+			var names []string
+			for _, l := range deviceLimits {
+				names = append(names, l.name)
+			}
+			return fmt.Errorf("unknown -limit %q; expected one of %s", name, strings.Join(names, ", "))
+		}
+		n, err := strconv.ParseUint(arg[i+1:], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for -limit %s", arg[i+1:], name)
+		}
+		limits[name] = uint32(n)
+		checkLimits = true
+	}
+	return nil
+}
+
+// limitWarnings returns a warning for every limit the module exceeds. All the
+// resources declared in the module are counted, so a module linked from
+// several stages is held to the per-stage limits as a whole. Runtime arrays
+// count as a single descriptor, as their size isn't known until the pipeline
+// is created.
+func limitWarnings(words []uint32) ([]string, error) {
+	m, err := parseSPIRV(words)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check the limits: %v", err)
+	}
+
+	used := make(map[string]uint32)
+	var sets uint32
+	for _, v := range m.variables {
+		id := v.operands[1]
+		if _, found := m.decoration(id, decorationBinding); !found {
+			continue
+		}
+		set, _ := m.decoration(id, decorationDescriptorSet)
+		if set+1 > sets {
+			sets = set + 1
+		}
+		n := m.descriptorCount(v)
+		for _, name := range limitCounts[m.descriptorType(v)] {
+			used[name] += n
+		}
+		used["resources"] += n
+	}
+	used["descriptor-sets"] = sets
+	offset, size, err := m.pushConstantRange()
+	if err != nil {
+		return nil, err
+	}
+	used["push-constants"] = offset + size
+
+	var warnings []string
+	for _, l := range deviceLimits {
+		if used[l.name] <= limits[l.name] {
+			continue
+		}
+		if limits[l.name] == l.min {
+			warnings = append(warnings, fmt.Sprintf("uses %d %s, but %s is only guaranteed to be %d", used[l.name], l.what, l.vkName, l.min))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("uses %d %s, more than -limit %s=%d", used[l.name], l.what, l.name, limits[l.name]))
+		}
+	}
+	return warnings, nil
+}
+
+// descriptorCount returns the number of descriptors the resource variable v
+// takes up: the product of its array sizes, with runtime arrays counting as
+// one.
+func (m *spirvModule) descriptorCount(v instruction) uint32 {
+	ptr := m.types[v.operands[0]]
+	if ptr.opcode != opTypePointer || len(ptr.operands) < 3 {
+		return 1
+	}
+	n := uint32(1)
+	for t := m.types[ptr.operands[2]]; (t.opcode == opTypeArray || t.opcode == opTypeRuntimeArray) && len(t.operands) > 1; t = m.types[t.operands[1]] {
+		if t.opcode == opTypeArray && len(t.operands) > 2 {
+			n *= m.constants[t.operands[2]]
+		}
+	}
+	return n
+}
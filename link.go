@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// linker merges compiled modules into one: glslang compiles every stage into
+// a module of its own, so sources with "// spv:link" directives are compiled
+// one by one and linked afterwards.
+const linker = "spirv-link"
+
+// linkedSources returns the sources named by the "// spv:link" directive of
+// src, e.g. "// spv:link foo.frag", which are resolved relative to src.
+func linkedSources(src string) ([]string, error) {
+	directives, err := sourceDirectives(src)
+	if err != nil {
+		return nil, err
+	}
+	names := strings.Fields(directives["link"])
+	var linked []string
+	for _, name := range names {
+		l := filepath.ToSlash(filepath.Join(filepath.Dir(src), name))
+		if !isGLSLFile(l) && !isSPIRVFile(l) {
+			return nil, fmt.Errorf("cannot link %s: not a shader source", name)
+		}
+		if l == src {
+			return nil, errors.New("a source cannot link itself")
+		}
+		linked = append(linked, l)
+	}
+	return linked, nil
+}
+
+// parseLinks returns the resolved paths of the linked sources named in data,
+// so that they count as dependencies of the file at path.
+func parseLinks(path string, data []byte) []string {
+	var links []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "//") {
+			continue
+		}
+		fields := strings.Fields(line[2:])
+		if len(fields) == 0 || fields[0] != directivePrefix+"link" {
+			continue
+		}
+		for _, name := range fields[1:] {
+			links = append(links, filepath.Join(filepath.Dir(path), name))
+		}
+	}
+	return links
+}
+
+// compileLinked compiles the sources linked to f and links them with the
+// module spvFile compiled from f, for the same target t. It
+// returns the path of the linked module and the warnings of the compilers.
+func compileLinked(ctx context.Context, f string, t target, spvFile string, linked []string, statusChan chan status) (string, []string, error) {
+	modules := []string{spvFile}
+	var warnings []string
+	for _, l := range linked {
+		if isSPIRVFile(l) {
+			modules = append(modules, loader.path(l))
+			continue
+		}
+		m, w, err := compile(ctx, l, t, statusChan)
+		if err != nil {
+			return "", nil, fmt.Errorf("in linked source %s: %v", l, err)
+		}
+		modules = append(modules, m)
+		warnings = append(warnings, w...)
+	}
+
+	out := strings.TrimSuffix(spvFile, ".spv") + "_linked.spv"
+	args := append([]string{"-o", out}, modules...)
+	statusChan <- status{2, commandLine(linker, args), false, f}
+	cmd := exec.CommandContext(ctx, linker, args...)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return "", nil, errInterrupted
+	}
+	if err != nil {
+		if len(output) > 0 {
+			return "", nil, fmt.Errorf("%s failed:\n%s", linker, output)
+		}
+		return "", nil, fmt.Errorf("%s failed: %v", linker, err)
+	}
+	return out, warnings, nil
+}
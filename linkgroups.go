@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// linkGroup is a group of shaders declared with "// spv:link-group", e.g. the
+// shaders forming a ray tracing hit group. The indices refer to IDs and are
+// -1 where the group has no such shader.
+type linkGroup struct {
+	Name         string
+	IDs          []string // identifiers of the shaders in stage order
+	General      int      // ray generation, miss or callable shader
+	ClosestHit   int
+	AnyHit       int
+	Intersection int
+}
+
+// linkGroupOf returns the link group declared in the source src, or "" if
+// there is none.
+func linkGroupOf(src string) (string, error) {
+	if isSPIRVFile(src) {
+		return "", nil
+	}
+	directives, err := sourceDirectives(src)
+	if err != nil {
+		return "", err
+	}
+	group := directives["link-group"]
+	if group != "" && !groupName.MatchString(group) {
+		return "", fmt.Errorf("invalid link group name %q; use letters, digits and underscores", group)
+	}
+	return group, nil
+}
+
+// linkGroups returns the link groups of the shaders in filesTotal sorted by
+// name. A group can't have two shaders of the same stage, and a ray tracing
+// group has either a general shader or hit shaders, like a shader group of a
+// ray tracing pipeline.
+func linkGroups() ([]linkGroup, error) {
+	bySource := make(map[string][]string)
+	for _, src := range filesTotal {
+		group, err := linkGroupOf(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", src, err)
+		}
+		if group != "" {
+			bySource[group] = append(bySource[group], src)
+		}
+	}
+
+	stageIndex := make(map[string]int)
+	for i, s := range stages {
+		stageIndex[s] = i
+	}
+
+	var groups []linkGroup
+	for name, srcs := range bySource {
+		sort.SliceStable(srcs, func(i, j int) bool { return stageIndex[stageOf(srcs[i])] < stageIndex[stageOf(srcs[j])] })
+		g := linkGroup{Name: name, General: -1, ClosestHit: -1, AnyHit: -1, Intersection: -1}
+		for i, src := range srcs {
+			if i > 0 && stageOf(src) == stageOf(srcs[i-1]) {
+				return nil, fmt.Errorf("link group %s has two %s shaders, %s and %s", name, stageOf(src), srcs[i-1], src)
+			}
+			switch stageOf(src) {
+			case "RayGen", "Miss", "Callable":
+				if g.General >= 0 {
+					return nil, fmt.Errorf("link group %s has two general ray tracing shaders, %s and %s", name, srcs[g.General], src)
+				}
+				g.General = i
+			case "ClosestHit":
+				g.ClosestHit = i
+			case "AnyHit":
+				g.AnyHit = i
+			case "Intersection":
+				g.Intersection = i
+			}
+			g.IDs = append(g.IDs, makeIdentifier(src))
+		}
+		if g.General >= 0 && (g.ClosestHit >= 0 || g.AnyHit >= 0 || g.Intersection >= 0) {
+			return nil, fmt.Errorf("link group %s mixes the general shader %s with hit shaders", name, srcs[g.General])
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// sourceLoader supplies the sources by their logical paths, relative to the
+// source directory, so that compiling doesn't depend on where the sources are
+// stored. Generated names and identifiers always come from the logical path.
+type sourceLoader interface {
+	// stat returns the size and modification time of the source.
+	stat(name string) (os.FileInfo, error)
+	// read returns the contents of the source.
+	read(name string) ([]byte, error)
+	// open returns a reader of the source for scanning it line by line.
+	open(name string) (io.ReadCloser, error)
+	// path returns a file the compiler can read the source from. The
+	// compiler resolves the includes of the source relative to it.
+	path(name string) string
+}
+
+// loader is the loader every source is read through.
+var loader sourceLoader = fileLoader{}
+
+// fileLoader reads the sources from the source directory, or from their
+// replacements given with -overlay.
+type fileLoader struct{}
+
+func (fileLoader) stat(name string) (os.FileInfo, error) {
+	return os.Stat(sourcePath(name))
+}
+
+func (fileLoader) read(name string) ([]byte, error) {
+	return ioutil.ReadFile(sourcePath(name))
+}
+
+func (fileLoader) open(name string) (io.ReadCloser, error) {
+	return os.Open(sourcePath(name))
+}
+
+func (fileLoader) path(name string) string {
+	return sourcePath(name)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// lockFilename is the file in the source directory that pins the compiler.
+const lockFilename = "spv.lock"
+
+// compilerLock identifies a compiler build. The hash of the --help output
+// tells apart builds that report the same version but support different
+// options.
+type compilerLock struct {
+	Compiler   string `json:"compiler"`
+	Version    string `json:"version"`
+	HelpSHA256 string `json:"help_sha256"`
+}
+
+// compilerPath is the absolute path of the compiler of the run, found in the
+// source directory, or "" before generate has looked for it. It identifies
+// the compiler in the caches of what it prints, as config targets can use
+// different compilers, or the same relative path from different directories.
+var compilerPath string
+
+// findCompiler sets compilerPath for cc. A compiler that can't be found keeps
+// its name, which compiling reports.
+func findCompiler() {
+	compilerPath = cc
+	if path, err := exec.LookPath(cc); err == nil {
+		if abs, err := filepath.Abs(path); err == nil {
+			compilerPath = abs
+		}
+	}
+}
+
+// compilerKey returns the key of the compiler in the caches of compilerOutput
+// and compilerHash.
+func compilerKey() string {
+	if compilerPath != "" {
+		return compilerPath
+	}
+	return cc
+}
+
+// compilerOutputs caches compilerOutput by compiler and argument, so that the
+// config targets sharing a compiler run it once for its --version and --help.
+var compilerOutputs = struct {
+	sync.Mutex
+	m map[string]compilerProbe
+}{m: make(map[string]compilerProbe)}
+
+type compilerProbe struct {
+	out string
+	err error
+}
+
+// compilerOutput runs the compiler with a single argument and returns what it
+// prints. Compilers that exit with an error after printing their usage are
+// fine.
+func compilerOutput(arg string) (string, error) {
+	key := compilerKey() + "\x00" + arg
+	compilerOutputs.Lock()
+	defer compilerOutputs.Unlock()
+	if p, found := compilerOutputs.m[key]; found {
+		return p.out, p.err
+	}
+	out, err := runCompilerProbe(arg)
+	compilerOutputs.m[key] = compilerProbe{out, err}
+	return out, err
+}
+
+func runCompilerProbe(arg string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(cc, arg)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if out.Len() == 0 {
+		if err == nil {
+			err = errors.New("no output")
+		}
+		return "", fmt.Errorf("%s %s: %v", cc, arg, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// currentLock returns the lock of the installed compiler.
+func currentLock() (compilerLock, error) {
+	version, err := compilerOutput("--version")
+	if err != nil {
+		return compilerLock{}, err
+	}
+	help, err := compilerOutput("--help")
+	if err != nil {
+		return compilerLock{}, err
+	}
+	sum := sha256.Sum256([]byte(help))
+	return compilerLock{
+		Compiler:   filepath.Base(cc),
+		Version:    version,
+		HelpSHA256: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// checkLock compares the installed compiler with spv.lock, if there is one.
+// A mismatch is an error, or only a warning with -lock-warn.
+func checkLock() int {
+	data, err := ioutil.ReadFile(lockFilename)
+	if os.IsNotExist(err) {
+		return 0
+	} else if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	var locked compilerLock
+	if err := json.Unmarshal(data, &locked); err != nil {
+		fmt.Printf("%s error: Cannot read %s: %v\n", os.Args[0], lockFilename, err)
+		return 1
+	}
+
+	current, err := currentLock()
+	if err != nil {
+		fmt.Printf("%s error: Cannot identify the compiler: %v\n", os.Args[0], err)
+		return 1
+	}
+	if current == locked {
+		return 0
+	}
+
+	what := "build" // same version, different options
+	if current.Version != locked.Version {
+		what = "version"
+	}
+	if current.Compiler != locked.Compiler {
+		what = "compiler"
+	}
+	msg := fmt.Sprintf("%s is a different %s than pinned in %s:\n\tlocked:    %s %s\n\tinstalled: %s %s\nRun with -update-lock to pin the installed compiler",
+		cc, what, lockFilename, locked.Compiler, firstLine(locked.Version), current.Compiler, firstLine(current.Version))
+	if lockWarn {
+		fmt.Printf("%s warning: %s\n", os.Args[0], msg)
+		return 0
+	}
+	fmt.Printf("%s error: %s\n", os.Args[0], msg)
+	return 1
+}
+
+// updateLockFile pins the installed compiler in spv.lock.
+func updateLockFile() int {
+	if _, err := exec.LookPath(cc); err != nil {
+		fmt.Printf("%s error: Cannot find GLSL compiler %s\n", os.Args[0], cc)
+		return 1
+	}
+	lock, err := currentLock()
+	if err != nil {
+		fmt.Printf("%s error: Cannot identify the compiler: %v\n", os.Args[0], err)
+		return 1
+	}
+	data, err := json.MarshalIndent(lock, "", "\t")
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	if err := ioutil.WriteFile(lockFilename, append(data, '\n'), 0644); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	if verbosity >= 1 {
+		fmt.Printf("%s: pinned %s in %s\n", os.Args[0], firstLine(lock.Version), lockFilename)
+	}
+	return 0
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// logFile is the file given with -log, which the status messages and
+// summaries are written to instead of stdout.
+var logFile string
+
+// logOutput receives the status messages, per-file errors and summaries. The
+// status messages are written by a single goroutine per run, which has
+// finished before the run returns, so everything is written by then.
+var logOutput io.Writer = os.Stdout
+
+// openLog points logOutput to the -log file, if one is given, and returns a
+// function closing it.
+func openLog() (func(), error) {
+	if logFile == "" {
+		return func() {}, nil
+	}
+	f, err := os.Create(logFile)
+	if err != nil {
+		return nil, err
+	}
+	logOutput = f
+	return func() {
+		logOutput = os.Stdout
+		f.Close()
+	}, nil
+}
+
+// jsonLog makes the per-file output JSON records, one per line, for tools
+// that run spv and process its output.
+var jsonLog bool
+
+// logRecord is a structured log record. It is encoded with encoding/json, as
+// log/slog is newer than the Go 1.14 spv builds with, but its keys and level
+// names match those of the log/slog JSON handler, so the records can be
+// processed like the logs of Go services.
+type logRecord struct {
+	Time     string  `json:"time"`
+	Level    string  `json:"level"`
+	Msg      string  `json:"msg"`
+	File     string  `json:"file,omitempty"`
+	Stage    string  `json:"stage,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Error    string  `json:"error,omitempty"`
+}
+
+// statusLevels maps verbosity levels of status messages to log levels. Level 0
+// messages are the warnings shown even without -verbose.
+var statusLevels = []string{"WARN", "INFO", "DEBUG", "DEBUG"}
+
+// printStatus prints a status message if the verbosity allows, as text or as
+// a JSON record.
+func printStatus(s status) {
+	if s.level > verbosity || (s.skipped && quietSkip) {
+		return
+	}
+	if !jsonLog {
+		fmt.Fprintln(logOutput, s.msg)
+		return
+	}
+	r := logRecord{Level: statusLevels[s.level], Msg: s.msg, File: s.file}
+	if s.file != "" {
+		r.Stage = stageOf(s.file)
+	}
+	writeRecord(r)
+}
+
+// printFileError prints the error that failed the source file f.
+func printFileError(f string, err error) {
+	msg := truncateLines(err.Error(), maxErrors)
+	if !jsonLog {
+		fmt.Fprintf(logOutput, "%s error in file %s: %s\n", os.Args[0], f, msg)
+		return
+	}
+	writeRecord(logRecord{Level: "ERROR", Msg: "compilation failed", File: f, Stage: stageOf(f), Error: msg})
+}
+
+// truncateLines cuts msg down to its first n non-empty lines, saying how many
+// more there were, so that a broken include shared by many shaders can't
+// flood the output. n is 0 for no limit.
+func truncateLines(msg string, n int) string {
+	if n <= 0 {
+		return msg
+	}
+	lines := strings.Split(msg, "\n")
+	kept, more := len(lines), 0
+	for i, seen := 0, 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		if seen++; seen == n+1 {
+			kept = i
+		}
+		if seen > n {
+			more++
+		}
+	}
+	if more == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s\n... and %d more lines", strings.Join(lines[:kept], "\n"), more)
+}
+
+// printGenerated records that the file generated from f was written, with
+// the time it took: as a JSON record, or as text at -v 1 and above.
+func printGenerated(f string, d time.Duration) {
+	if jsonLog {
+		writeRecord(logRecord{Level: "INFO", Msg: "generated", File: f, Stage: stageOf(f), Duration: d.Seconds()})
+	} else if verbosity >= 1 {
+		fmt.Fprintf(logOutput, "%s was generated in %s\n", f, d.Round(time.Millisecond))
+	}
+}
+
+// printDeleted records that the stale generated file was deleted, like
+// printGenerated.
+func printDeleted(file string) {
+	if jsonLog {
+		writeRecord(logRecord{Level: "INFO", Msg: "deleted", File: file})
+	} else if verbosity >= 1 {
+		fmt.Fprintf(logOutput, "deleted the stale file %s\n", file)
+	}
+}
+
+// printSummary prints a message about the whole run, such as the number of
+// files with errors.
+func printSummary(level, msg string) {
+	if !jsonLog {
+		fmt.Fprintf(logOutput, "%s: %s\n", os.Args[0], msg)
+		return
+	}
+	writeRecord(logRecord{Level: level, Msg: msg})
+}
+
+// writeRecord writes r as a line of JSON. Like log/slog, it leaves <, > and &
+// unescaped, as compiler errors are full of them.
+func writeRecord(r logRecord) {
+	r.Time = time.Now().Format(time.RFC3339Nano)
+	enc := json.NewEncoder(logOutput)
+	enc.SetEscapeHTML(false)
+	enc.Encode(r) // can't fail with these fields, other than writing
+}
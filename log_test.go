@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLogEscaping(t *testing.T) {
+	defer func(j bool, w io.Writer) { jsonLog, logOutput = j, w }(jsonLog, logOutput)
+	defer func(n int) { maxErrors = n }(maxErrors)
+	jsonLog, maxErrors = true, 0
+
+	tests := []struct {
+		name, msg, want string
+		raw             string // a substring of the line written
+	}{
+		{"plain", "no errors", "no errors", `"no errors"`},
+		{"quotes and backslashes", `"C:\shaders\a.frag"`, `"C:\shaders\a.frag"`, `"\"C:\\shaders\\a.frag\""`},
+		{"newlines", "ERROR: 0:1: 'x' : undeclared\nERROR: 1 compilation errors", "ERROR: 0:1: 'x' : undeclared\nERROR: 1 compilation errors", `undeclared\nERROR`},
+		{"control characters", "a\tb\rc\x00d\x1b[0m", "a\tb\rc\x00d\x1b[0m", `a\tb\rc\u0000d\u001b[0m`},
+		{"html", "expected <, got vec4 && float", "expected <, got vec4 && float", `expected <, got vec4 && float`},
+		{"unicode", "väri ✓", "väri ✓", `väri ✓`},
+		{"invalid utf-8", "a\xffb", "a\ufffdb", "a\ufffdb"},
+		{"line separators", "a\u2028b\u2029c", "a\u2028b\u2029c", `a\u2028b\u2029c`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logOutput = &out
+			printFileError("a.frag", errors.New(tt.msg))
+			printSummary("INFO", tt.msg)
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("got %d lines, want a record on each of 2:\n%s", len(lines), out.String())
+			}
+			for i, line := range lines {
+				var r logRecord
+				if err := json.Unmarshal([]byte(line), &r); err != nil {
+					t.Fatalf("invalid record %s: %v", line, err)
+				}
+				got := r.Msg
+				if i == 0 {
+					got = r.Error
+				}
+				if got != tt.want {
+					t.Errorf("record %s decodes to %q, want %q", line, got, tt.want)
+				}
+				if !strings.Contains(line, tt.raw) {
+					t.Errorf("record %s doesn't contain %s", line, tt.raw)
+				}
+			}
+		})
+	}
+}
+
+func TestProfileOutput(t *testing.T) {
+	defer func(j bool, w io.Writer) { jsonLog, logOutput = j, w }(jsonLog, logOutput)
+	var timings fileTimings
+	timings.add("a.frag", 3*time.Millisecond)
+	timings.add("b.comp", 5*time.Millisecond)
+	timings.add("c.vert", time.Millisecond)
+
+	var out bytes.Buffer
+	logOutput, jsonLog = &out, false
+	timings.print(2)
+	if got, want := out.String(), "2 slowest files:\n       5ms  b.comp\n       3ms  a.frag\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("text output %q, want it to end in %q", got, want)
+	}
+
+	out.Reset()
+	jsonLog = true
+	timings.print(2)
+	var files []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var r logRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("invalid record %s: %v", line, err)
+		}
+		files = append(files, fmt.Sprintf("%s %s %g", r.File, r.Stage, r.Duration))
+	}
+	if got, want := strings.Join(files, ", "), "b.comp Compute 0.005, a.frag Fragment 0.003"; got != want {
+		t.Errorf("records for %s, want %s", got, want)
+	}
+}
+
+// TestQuietSkip checks the per-file lines at -v 1 with and without
+// -quiet-skip: generated and deleted files are always reported, sources that
+// are up to date only without it.
+func TestQuietSkip(t *testing.T) {
+	defer func(w io.Writer) { logOutput = w }(logOutput)
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Precompiled, as every runSPV has a compiler of its own
+	module := string(spirvBytes([]uint32{spirvMagic, 0x00010000, 0x00080001, 10, 0, 0x00020011, 1, 0x0003000e, 0, 1}))
+	writeFiles(t, dir, map[string]string{"a.comp.spv": module, "b.comp.spv": module})
+
+	steps := []struct {
+		name      string
+		do        func()
+		args      []string
+		want, not []string
+	}{
+		{"generate", nil, []string{"-v", "1", "-quiet-skip"}, []string{"a.comp.spv was generated in", "b.comp.spv was generated in"}, nil},
+		{"up to date", nil, []string{"-v", "1"}, []string{"a.comp.spv is unmodified", "b.comp.spv is unmodified"}, []string{"generated in"}},
+		{"source removed", func() { os.Remove(filepath.Join(dir, "b.comp.spv")) }, []string{"-v", "1", "-quiet-skip"},
+			[]string{"deleted the stale file b.comp.spv" + genExtension}, []string{"unmodified"}},
+	}
+	for _, step := range steps {
+		if step.do != nil {
+			step.do()
+		}
+		var out bytes.Buffer
+		logOutput = &out
+		if code := runSPV(t, dir, step.args...); code != 0 {
+			t.Fatalf("%s: exit code %d", step.name, code)
+		}
+		for _, s := range step.want {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%s: no %q in\n%s", step.name, s, out.String())
+			}
+		}
+		for _, s := range step.not {
+			if strings.Contains(out.String(), s) {
+				t.Errorf("%s: %q in\n%s", step.name, s, out.String())
+			}
+		}
+	}
+}
@@ -1,57 +1,182 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 	"unicode"
 )
 
 type e struct{} // empty type
 
+// status is a message for the status printer. It is printed if its level is
+// at most the verbosity; errors have level 0.
+type status struct {
+	level   int
+	msg     string
+	skipped bool   // the message is about a file that didn't need generating
+	file    string // the source the message is about, if any
+}
+
 const (
 	genExtension     = ".gen.go"
 	manifestFilename = "shaders" + genExtension
+
+	// internalDir is where the shader data is generated with -internal
+	internalDir = "internal/shaders"
+
+	exitInterrupted = 130 // exit code after SIGINT or SIGTERM
 )
 
 var (
-	dir     string
-	pkg     string
-	verbose bool
-	cc      string
-	ccArgs  string
-	force   bool // true if all source files should always be generated
+	dir        string
+	pkg        string
+	verbose    bool
+	verbosity  int // 1 for per-file status, 2 for command lines, 3 for all compiler output
+	cc         string
+	ccArgs     string
+	ccTemplate string // command line template used instead of cc and ccArgs
+	force      bool   // true if all source files should always be generated
+	profile    int    // number of slowest files to report, 0 to disable
+	quietSkip  bool   // don't report files that didn't need generating
+	jobs       int    // maximum number of concurrent compilers
+
+	spvVersion   string     // SPIR-V version passed to the compiler with --target-spv
+	goVersion    string     // Go version the generated code has to compile with
+	overlayFile  string     // JSON file mapping source paths to replacement files
+	outputMode   string     // how the binary data is emitted; see outputModes
+	wordsPerLine int        // words of binary data per line, all on one line if 0
+	reflect      bool       // generate metadata extracted from the SPIR-V modules
+	warnEmpty    bool       // warn about modules without entry points or code
+	werror       bool       // treat warnings as errors
+	strictStderr bool       // treat any compiler output on stderr as an error
+	maxErrors    int        // limit on error lines per file and failed files, 0 for none
+	embedSource  bool       // embed the GLSL source next to the binary data
+	byPath       bool       // add a map from source paths to binary data to the manifest
+	canonical    bool       // normalize the compiled modules with spirv-opt
+	enableExt    stringList // GLSL extensions enabled in every source
+
+	internal       bool // generate into internalDir with a facade for exported shaders
+	recursive      bool // include sources in subdirectories
+	fastScan       bool // skip scanning if no directory changed since the last run
+	followSymlinks bool // follow symlinks to directories
+	flattenSuffix  bool // include the directory in names generated from subdirectories
+
+	configFile string // JSON file with settings and package targets
+	serveAddr  string // address to serve the compiled modules on
+	watchMode  bool   // keep regenerating on changes
+	depFile    string // Make dependency file to write
+
+	initMode     bool   // add a go:generate directive instead of generating
+	cleanMode    bool   // remove generated files instead of generating
+	manifestOnly bool   // rewrite the manifest from the generated files
+	noManifest   bool   // generate only the per-shader files
+	updateLock   bool   // pin the installed compiler in spv.lock instead of generating
+	lockWarn     bool   // only warn if the compiler doesn't match spv.lock
+	doctorMode   bool   // check the build environment instead of generating
+	genTests     bool   // generate a test checking the embedded modules
+	verifyMode   bool   // compare the generated files with a fresh build
+	syntaxOnly   bool   // only check that the sources compile
+	migrateSpec  string // output modes to migrate the generated files between
 
 	filesToGenerate []string
 	filesToDelete   []string
 	filesTotal      []string
+	scannedDirs     []string // directories scanned for sources
 	manifestFound   bool
+	manifestStale   bool // true if a generated file is newer than the manifest
 
 	tempDir string
 
-	validExtensions = map[string]e{
-		".vert":  e{},
-		".tesc":  e{},
-		".tese":  e{},
-		".geom":  e{},
-		".frag":  e{},
-		".comp":  e{},
-		".mesh":  e{},
-		".task":  e{},
-		".rgen":  e{},
-		".rint":  e{},
-		".rahit": e{},
-		".rchit": e{},
-		".rmiss": e{},
-		".rcall": e{},
+	lastResult = &runResult{} // result of the latest generate
+
+	// outputModes maps the accepted -as values to the Go type of the data
+	outputModes = map[string]string{
+		"words":      "[]uint32",
+		"string":     "string",
+		"fs":         "[]byte",
+		"compressed": "*Module",
+		"blob":       "[]byte",
+	}
+
+	validSPVVersions = []string{"spv1.0", "spv1.1", "spv1.2", "spv1.3", "spv1.4", "spv1.5", "spv1.6"}
+
+	// stages lists the pipeline stages in the order of the generated Stage
+	// constants.
+	stages = []string{
+		"Vertex",
+		"TessControl",
+		"TessEvaluation",
+		"Geometry",
+		"Fragment",
+		"Compute",
+		"Mesh",
+		"Task",
+		"RayGen",
+		"Intersection",
+		"AnyHit",
+		"ClosestHit",
+		"Miss",
+		"Callable",
+	}
+
+	// stageGroups maps the names of groups of stages, which can be used in
+	// place of single stages in the config, to their stages.
+	stageGroups = map[string][]string{
+		"graphics":   {"Vertex", "TessControl", "TessEvaluation", "Geometry", "Fragment"},
+		"compute":    {"Compute"},
+		"mesh":       {"Mesh", "Task"},
+		"raytracing": {"RayGen", "Intersection", "AnyHit", "ClosestHit", "Miss", "Callable"},
+	}
+
+	// vkStageFlags maps the stages to their VkShaderStageFlagBits.
+	vkStageFlags = map[string]uint32{
+		"Vertex":         0x00000001,
+		"TessControl":    0x00000002,
+		"TessEvaluation": 0x00000004,
+		"Geometry":       0x00000008,
+		"Fragment":       0x00000010,
+		"Compute":        0x00000020,
+		"Task":           0x00000040, // VK_SHADER_STAGE_TASK_BIT_EXT
+		"Mesh":           0x00000080, // VK_SHADER_STAGE_MESH_BIT_EXT
+		"RayGen":         0x00000100,
+		"AnyHit":         0x00000200,
+		"ClosestHit":     0x00000400,
+		"Miss":           0x00000800,
+		"Intersection":   0x00001000,
+		"Callable":       0x00002000,
+	}
+
+	// validExtensions maps source file extensions to their stages
+	validExtensions = map[string]string{
+		".vert":  "Vertex",
+		".tesc":  "TessControl",
+		".tese":  "TessEvaluation",
+		".geom":  "Geometry",
+		".frag":  "Fragment",
+		".comp":  "Compute",
+		".mesh":  "Mesh",
+		".task":  "Task",
+		".rgen":  "RayGen",
+		".rint":  "Intersection",
+		".rahit": "AnyHit",
+		".rchit": "ClosestHit",
+		".rmiss": "Miss",
+		".rcall": "Callable",
 	}
 )
 
@@ -60,7 +185,74 @@ func main() {
 }
 
 func run() (exitcode int) {
-	parseArgs()
+	if err := parseArgs(); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 2
+	}
+
+	closeLog, err := openLog()
+	if err != nil {
+		fmt.Printf("%s error: Cannot create log %s: %v\n", os.Args[0], logFile, err)
+		return 1
+	}
+	defer closeLog()
+
+	if configFile != "" {
+		if err := loadConfig(configFile); err != nil {
+			fmt.Printf("%s error: Cannot read config %s: %v\n", os.Args[0], configFile, err)
+			return 1
+		}
+		if len(config.Targets) > 0 {
+			if serveAddr != "" || watchMode {
+				fmt.Printf("%s error: -serve and -watch can't be used with config targets\n", os.Args[0])
+				return 2
+			}
+			if len(namedFiles) > 0 {
+				fmt.Printf("%s error: sources can't be named with config targets\n", os.Args[0])
+				return 2
+			}
+			if reportFile != "" {
+				fmt.Printf("%s error: -report can't be used with config targets\n", os.Args[0])
+				return 2
+			}
+			return runTargets()
+		}
+	}
+
+	if serveAddr != "" {
+		return serve()
+	}
+	if watchMode {
+		return watchAndReport()
+	}
+
+	if reportFile != "" {
+		defer func() { exitcode = saveReport(exitcode) }()
+	}
+	return generate()
+}
+
+// generate generates the package in dir according to the flags.
+func generate() (exitcode int) {
+	if overlayFile != "" {
+		if err := loadOverlay(overlayFile); err != nil {
+			fmt.Printf("%s error: Cannot read overlay %s: %v\n", os.Args[0], overlayFile, err)
+			return 1
+		}
+	}
+	if preludeFile != "" {
+		if err := loadPrelude(); err != nil {
+			fmt.Printf("%s error: Cannot read prelude %s: %v\n", os.Args[0], preludeFile, err)
+			return 1
+		}
+	}
+
+	startDir, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+
 	if dir != "" {
 		err := os.Chdir(dir)
 		if err != nil {
@@ -69,19 +261,129 @@ func run() (exitcode int) {
 		}
 	}
 
+	if err := resolveToolsCompiler(); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	findCompiler()
+
+	if initMode {
+		return initPackage()
+	}
+
+	if cleanMode {
+		return cleanGenerated()
+	}
+
+	if updateLock {
+		return updateLockFile()
+	}
+
+	if doctorMode {
+		return doctor()
+	}
+
+	if err := detectGoVersion(); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	if err := checkGoVersion(); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	for _, fallback := range goVersionFallbacks() {
+		fmt.Printf("%s warning: %s\n", os.Args[0], fallback)
+	}
+
 	if pkg == "" {
-		fmt.Println("No package name specified")
+		if pkg, err = detectPackage(); err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+		if verbosity >= 2 {
+			fmt.Printf("%s: using package name %s\n", os.Args[0], pkg)
+		}
+	}
+
+	if _, found := outputModes[outputMode]; !found {
+		fmt.Printf("%s error: Invalid output mode %q; accepted modes are %s\n",
+			os.Args[0], outputMode, strings.Join(outputModeNames(), ", "))
 		return 1
 	}
 
-	// Populates filesToGenerate, filesToDelete and manifestFound
+	if spvVersion != "" && !isValidSPVVersion(spvVersion) {
+		fmt.Printf("%s error: Invalid SPIR-V version %q; accepted versions are %s\n",
+			os.Args[0], spvVersion, strings.Join(validSPVVersions, ", "))
+		return 1
+	}
+
+	if manifestOnly {
+		return rebuildManifest()
+	}
+
+	if migrateTo != "" {
+		if c := prepareMigration(); c != 0 {
+			return c
+		}
+	}
+
+	if fastScan && !force && !verifyMode && !syntaxOnly && printDeps == "" && overlay == nil && len(namedFiles) == 0 && scanUnchanged() {
+		if verbosity >= 1 {
+			printSummary("INFO", "No changes")
+		}
+		return 0
+	}
+
+	// Populates filesToGenerate, filesToDelete, manifestFound and manifestStale
 	if c := getFiles(); c != 0 {
 		return c
 	}
+	if sinceRef != "" {
+		restrictToChanged()
+	}
+	if len(namedFiles) > 0 {
+		if err := restrictToNamed(); err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+	}
+	if printDeps != "" {
+		return printDependencies()
+	}
+	if syntaxOnly {
+		return checkSyntax()
+	}
+	if warmCache {
+		return warmCompileCache()
+	}
+	if fastScan && !verifyMode && sinceRef == "" && len(namedFiles) == 0 {
+		// Files skipped by -since or names may be stale, so they must be scanned again
+		defer func() {
+			if exitcode == 0 {
+				saveScanCache()
+			}
+		}()
+	}
+	if depFile != "" && !verifyMode {
+		defer func() {
+			if exitcode != 0 {
+				return
+			}
+			if err := writeDepFile(depFile, startDir); err != nil {
+				fmt.Printf("%s error: Cannot write depfile: %v\n", os.Args[0], err)
+				exitcode = 1
+			}
+		}()
+	}
 
-	if len(filesToGenerate)+len(filesToDelete) == 0 && manifestFound {
-		if verbose {
-			fmt.Printf("%s: No changes\n", os.Args[0])
+	manifestCurrent := manifestFound && !manifestStale
+	if noManifest {
+		// A manifest left from before only needs to be removed
+		manifestCurrent = !manifestFound
+	}
+	if len(filesToGenerate)+len(filesToDelete) == 0 && manifestCurrent && !verifyMode {
+		if verbosity >= 1 {
+			printSummary("INFO", "No changes")
 		}
 		return 0
 	}
@@ -90,8 +392,30 @@ func run() (exitcode int) {
 		fmt.Printf("%s error: Cannot find GLSL compiler %s\n", os.Args[0], cc)
 		return 1
 	}
+	if canonical {
+		if _, err := exec.LookPath(optimizer); err != nil {
+			fmt.Printf("%s error: Cannot find %s, which -canonicalize needs\n", os.Args[0], optimizer)
+			return 1
+		}
+	}
+
+	if len(crossOutputs) > 0 {
+		if _, err := exec.LookPath(crossCompiler); err != nil {
+			fmt.Printf("%s error: Cannot find %s, which -cross needs\n", os.Args[0], crossCompiler)
+			return 1
+		}
+	}
+
+	if c := checkLock(); c != 0 {
+		return c
+	}
+
+	if err := os.MkdirAll(outputDir(), 0755); err != nil {
+		fmt.Printf("%s error: Cannot create output directory: %v\n", os.Args[0], err)
+		return 1
+	}
 
-	td, err := ioutil.TempDir("", "go-spv-*")
+	td, err := makeTempDir()
 	if err != nil {
 		fmt.Printf("%s error: Cannot create temp directory: %v\n", os.Args[0], err)
 		return 1
@@ -99,30 +423,90 @@ func run() (exitcode int) {
 	tempDir = td
 	defer os.RemoveAll(tempDir)
 
-	statusChan := make(chan string)
+	// Cancelling ctx kills running compilers and stops new ones from starting
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	statusChan := make(chan status)
 	statusChanClosed := make(chan e)
 	go func() {
 		defer close(statusChanClosed)
 		for s := range statusChan {
-			fmt.Println(s)
+			printStatus(s)
 		}
 	}()
 
-	var numErr uint32
-	var changed uint32 // stays at 0 if none of the files were changed
+	var changed uint32   // stays at 0 if none of the files were changed
+	var abandoned uint32 // files not compiled because of -max-errors
+	var timings fileTimings
+	res := &runResult{}
+	lastResult = res
+
+	var trace *buildTrace
+	if traceFile != "" {
+		trace = newBuildTrace(jobs)
+		// Written last, so that failing to write it skips none of the steps
+		// after the compilations
+		defer func() {
+			if err := trace.save(startDir); err != nil {
+				fmt.Printf("%s error: Cannot write trace: %v\n", os.Args[0], err)
+				if exitcode == 0 {
+					exitcode = 1
+				}
+			}
+		}()
+	}
 
+	sem := make(chan e, jobs)
 	wg := sync.WaitGroup{}
 	wg.Add(len(filesToGenerate))
 	for _, f := range filesToGenerate {
 		f := f
 		go func() {
-			chng, err := operate(f, statusChan)
+			queued := time.Now()
+			sem <- e{}
+			defer func() { <-sem }()
+			if jobSlots != nil {
+				jobSlots <- e{}
+				defer func() { <-jobSlots }()
+			}
+
+			if maxErrors > 0 && res.errorCount() >= maxErrors {
+				atomic.AddUint32(&abandoned, 1)
+				wg.Done()
+				return
+			}
+
+			var lane int
+			if trace != nil {
+				lane = trace.lane()
+			}
+			start := time.Now()
+			chng, err := operate(ctx, f, statusChan)
+			timings.add(f, time.Since(start))
+			if trace != nil {
+				trace.done(lane, f, queued, start, chng, err)
+			}
+			if err == errInterrupted {
+				wg.Done()
+				return
+			}
 			if err != nil {
-				atomic.AddUint32(&numErr, 1)
-				statusChan <- fmt.Sprintf("%s error in file %s: %v", os.Args[0], f, err)
+				res.addError(f, err)
 			}
 
 			if chng {
+				res.addGenerated(f)
 				atomic.StoreUint32(&changed, 1)
 			}
 			wg.Done()
@@ -131,31 +515,285 @@ func run() (exitcode int) {
 	wg.Wait()
 	close(statusChan)
 	<-statusChanClosed
+	res.sort()
+
+	// Errors are reported after the status messages so that none of them are
+	// interleaved with or lost among the other output.
+	for _, fe := range res.Errors {
+		printFileError(fe.File, fe.Err)
+	}
+	if abandoned > 0 {
+		printSummary("ERROR", fmt.Sprintf("stopped after %d errors; %d files were not compiled", len(res.Errors), abandoned))
+	}
+	durations := timings.durations()
+	res.Durations = durations
+	for _, f := range res.Generated {
+		printGenerated(f, durations[f])
+	}
+
+	if profile > 0 {
+		timings.print(profile)
+	}
+	// Leave stale files and the manifest alone; every generated file is
+	// either fully old or fully new.
+	if ctx.Err() != nil {
+		printSummary("WARN", "interrupted")
+		return exitInterrupted
+	}
+
+	if bucketCount > 0 && !verifyMode {
+		// Outside of the error check, as failed sources keep their old entries
+		if err := writeBuckets(); err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+	}
 
-	if numErr > 0 {
-		fmt.Printf("%s: errors in %d files\n", os.Args[0], numErr)
+	if verifyMode {
+		// Check everything, even if some of the shaders didn't match
+		code := verifyOutputs(len(res.Errors) == 0)
+		if len(res.Errors) > 0 {
+			printSummary("ERROR", fmt.Sprintf("errors in %d files", len(res.Errors)))
+			return 1
+		}
+		return code
+	}
+
+	if len(res.Errors) > 0 {
+		printSummary("ERROR", fmt.Sprintf("errors in %d files", len(res.Errors)))
 		return 1
 	}
 
+	if checkBindings != "" {
+		if c := reportBindingConflicts(); c != 0 {
+			return c
+		}
+	}
+
 	for _, file := range filesToDelete {
-		os.Remove(file)
+		res.deleteStale(file)
+	}
+	if err := pruneEmbedded(false); err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
 	}
 
-	if changed == 1 || !manifestFound || len(filesToDelete) != 0 {
+	if noManifest {
+		return removeManifest()
+	}
+
+	if changed == 1 || !manifestFound || manifestStale || len(filesToDelete) != 0 {
 		return writeManifest()
 	}
 
 	return 0
 }
 
-func parseArgs() {
+func parseArgs() error {
 	flag.StringVar(&dir, "dir", "", "Path to the directory with the source files")
-	flag.StringVar(&pkg, "pkg", "", "Package name for the output files")
-	flag.BoolVar(&verbose, "verbose", false, "Enable for informative messages")
+	flag.StringVar(&pkg, "pkg", "", "Package name for the output files (default: from the Go files or the directory name)")
+	flag.BoolVar(&verbose, "verbose", false, "Enable for informative messages (same as -v=1)")
+	flag.IntVar(&verbosity, "v", 0, "Verbosity level: 1 for per-file status, 2 to also print compiler commands, 3 to also print all compiler output")
 	flag.StringVar(&cc, "cc", "", "GLSL compiler")
 	flag.StringVar(&ccArgs, "args", "", "GLSL compiler arguments")
+	flag.StringVar(&ccTemplate, "cc-template", "", "Compiler command line template with {{.Input}}, {{.Output}}, {{.Stage}}, {{.Defines}} and {{.Includes}}")
+	flag.StringVar(&sinceRef, "since", "", "Only regenerate sources that changed since the git `ref`, or whose includes did")
 	flag.BoolVar(&force, "force", false, "Force compilation for every file regardless of date modified")
+	flag.BoolVar(&skipIdentical, "skip-identical", false, "Don't rewrite generated files that a fresh build leaves byte-for-byte identical")
+	flag.BoolVar(&jsonLog, "json", false, "Print the per-file output as JSON records, one per line")
+	flag.StringVar(&logFile, "log", "", "Write the per-file output and summaries to this `file` instead of stdout")
+	flag.StringVar(&reportFile, "report", "", "Write a summary of the run to `file`, as JSON with -json")
+	flag.BoolVar(&quietSkip, "quiet-skip", false, "Don't report up-to-date files, only compiles, deletions and errors")
+	flag.IntVar(&profile, "profile", 0, "Print compilation times of the N slowest files")
+	flag.StringVar(&cacheDir, "cache", "", "Keep the compiled modules in `dir` and reuse them for sources compiled the same way")
+	flag.BoolVar(&warmCache, "warm-cache", false, "Compile every source into the -cache directory without generating anything and exit")
+	flag.StringVar(&tempBase, "tmp", "", "Create the temp directory for the compiled modules in `dir` (default: the OS temp directory)")
+	flag.StringVar(&traceFile, "trace", "", "Write a Chrome trace of the compilations to `file`, for chrome://tracing or Perfetto")
+	flag.StringVar(&spvVersion, "spv-version", "", "SPIR-V version to generate, e.g. spv1.5")
+	flag.StringVar(&goVersion, "go-version", "", "Go `version` the generated code has to compile with (default: from go.mod)")
+	flag.StringVar(&outputMode, "as", "words", "Emit the binary data in this `mode`: "+strings.Join(outputModeNames(), ", "))
+	flag.BoolVar(&internal, "internal", false, "Generate into internal/shaders and export only shaders marked with // spv:export")
+	flag.BoolVar(&recursive, "recursive", false, "Include sources in subdirectories")
+	flag.BoolVar(&fastScan, "fast-scan", false, "Skip checking the sources if no directory changed since the last run")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "Follow symlinks to directories with -recursive")
+	flag.StringVar(&collisionPolicy, "collision", "error", "What to do when sources map to the same identifier: `error` or suffix (number all but the first)")
+	flag.IntVar(&bucketCount, "bucket", 0, "Generate the shaders into N files, each holding the shaders whose names hash to it (0 for a file per shader)")
+	flag.BoolVar(&flattenSuffix, "flatten-suffix", false, "Include the directory in file names generated from subdirectories")
+	flag.BoolVar(&reflect, "reflect", false, "Generate metadata such as push constant ranges from the compiled modules")
+	flag.BoolVar(&warnEmpty, "warn-empty", false, "Warn about modules without entry points or code")
+	flag.BoolVar(&checkLimits, "check-limits", false, "Warn about shaders using more descriptors or push constants than every Vulkan device supports")
+	flag.Var(&limitArgs, "limit", "Override a limit of -check-limits, e.g. storage-buffers=8 (repeatable, implies -check-limits)")
+	flag.BoolVar(&werror, "Werror", false, "Treat warnings as errors")
+	flag.BoolVar(&strictExtensions, "strict-extensions", false, "Fail shaders that use extensions without declaring them with #extension")
+	flag.IntVar(&maxErrors, "max-errors", 0, "Show at most N lines of compiler output per failed file, and stop after N failed files (0 for no limit)")
+	flag.BoolVar(&strictCompiler, "strict-compiler", false, "Regenerate when the compiler binary changes, not only its --version")
+	flag.BoolVar(&strictStderr, "strict-stderr", false, "Fail files whose compiler writes anything to stderr, even if it succeeds")
+	flag.BoolVar(&autoMapBindings, "auto-map-bindings", false, "Let the compiler assign the bindings that sources leave out")
+	flag.BoolVar(&autoMapLocations, "auto-map-locations", false, "Let the compiler assign the locations that sources leave out")
+	flag.BoolVar(&relaxedErrors, "relaxed", false, "Compile with the compiler's relaxed error checking, to migrate legacy shaders")
+	flag.BoolVar(&suppressWarnings, "suppress-warnings", false, "Have the compiler print no warnings, to migrate legacy shaders")
+	flag.StringVar(&forcedStage, "S", "", "Compile .glsl sources without a stage extension as this `stage`, e.g. Fragment or frag")
+	flag.Var(&stageExt, "stage-ext", "Map a custom source file `extension` to a stage, e.g. .vs=Vertex (repeatable)")
+	flag.Var(&enableExt, "enable-ext", "Enable a GLSL `extension` in every source, e.g. GL_KHR_shader_subgroup_basic (repeatable)")
+	flag.BoolVar(&canonical, "canonicalize", false, "Strip debug info and renumber IDs with spirv-opt for output that is stable across compiler versions")
+	flag.Var(&multiTarget, "multi-target", "Also compile every shader for each target `env`, e.g. vulkan1.0,vulkan1.2")
+	flag.Var(&crossSpec, "cross", "Also translate the modules with spirv-cross into a `target` language: msl, hlsl or glsl-es (repeatable)")
+	flag.BoolVar(&docComments, "doc-comments", false, "Copy the comment atop each GLSL source onto its binary data as a doc comment")
+	flag.BoolVar(&requireDoc, "require-doc", false, "Report the compiled sources without a comment at the top, with -v")
+	flag.BoolVar(&provenance, "provenance", false, "Record the spv version and compiler arguments in each compiled module as an OpModuleProcessed instruction")
+	flag.StringVar(&checkBindings, "check-bindings", "", "Warn when shaders in the same group (group) or any shaders (all) bind different kinds of descriptors to the same set and binding")
+	flag.BoolVar(&byPath, "by-path", false, "Add ShadersByPath to the manifest, mapping the source paths to the binary data")
+	flag.BoolVar(&checksums, "checksums", false, "Generate the SHA-256 of each module and a Verify function checking loaded modules against them")
+	flag.BoolVar(&embedSource, "embed-source", false, "Embed the GLSL source of each shader in the generated files")
+	flag.StringVar(&headerFile, "header-file", "", "Put the text of `file`, e.g. a license header, atop every generated Go file")
+	flag.BoolVar(&sourceMeta, "source-meta", false, "Add ShaderMeta with a hash of each shader's source to the manifest")
+	flag.BoolVar(&cleanMode, "clean", false, "Remove all generated files and exit")
+	flag.BoolVar(&genTests, "gen-tests", false, "Generate "+testFilename+" checking that the embedded modules are valid")
+	flag.BoolVar(&verifyMode, "verify", false, "Check that the generated files match a fresh build without writing anything")
+	flag.BoolVar(&updateLock, "update-lock", false, "Pin the installed compiler in "+lockFilename+" and exit")
+	flag.BoolVar(&doctorMode, "doctor", false, "Check that the compiler and tools the flags need are installed and exit")
+	flag.BoolVar(&lockWarn, "lock-warn", false, "Only warn if the compiler doesn't match "+lockFilename)
+	flag.BoolVar(&noManifest, "no-manifest", false, "Generate only the per-shader files, without the manifest")
+	flag.BoolVar(&syntaxOnly, "syntax-only", false, "Only check that the sources compile, writing nothing")
+	flag.StringVar(&migrateSpec, "migrate", "", "Regenerate all files in a new output mode, e.g. \"from=words to=string\"")
+	flag.BoolVar(&manifestOnly, "manifest-only", false, "Rewrite the manifest from the generated files without compiling")
+	flag.BoolVar(&initMode, "init", false, "Add a go:generate directive for spv to doc.go and exit")
+	flag.StringVar(&registerFunc, "register", "", "Register every shader in an init function by calling `func`, e.g. example.com/registry.Register")
+	flag.StringVar(&byteType, "byte-type", defaultByteType, "Go `type` of the binary data with -as fs or blob, e.g. example.com/vk.ShaderCode")
+	flag.IntVar(&wordsPerLine, "words-per-line", 8, "Words of binary data per line in generated files, 0 for a single line")
+	flag.StringVar(&overlayFile, "overlay", "", "JSON file mapping source paths to the files compiled in their place")
+	flag.StringVar(&preludeFile, "prelude", "", "Compile the contents of this `file` at the top of every GLSL source, after its #version line")
+	flag.Var(&printDeps, "print-deps", "Print the files each source includes, as a list or with -print-deps=tree, and exit without compiling")
+	flag.StringVar(&depFile, "depfile", "", "Write a Make-style dependency file listing the inputs of each generated file")
+	flag.BoolVar(&watchMode, "watch", false, "Keep regenerating whenever the sources change")
+	flag.StringVar(&serveAddr, "serve", "", "Keep compiling changed shaders and serve the modules over HTTP on `addr` (or unix:path)")
+	flag.StringVar(&configFile, "config", "", "JSON config file, e.g. to generate several packages in one run")
+	flag.IntVar(&jobs, "jobs", runtime.NumCPU(), "Maximum number of compilers to run, and of sources to check for changes, at once")
+	flag.Usage = usage
 	flag.Parse()
+	namedFiles = flag.Args()
+
+	if err := applyEnv(); err != nil {
+		return err
+	}
+
+	return finishArgs()
+}
+
+// finishArgs validates the flags and fills in derived values.
+func finishArgs() error {
+	if jobs < 1 {
+		return fmt.Errorf("-jobs must be at least 1, got %d", jobs)
+	}
+	if collisionPolicy != "error" && collisionPolicy != "suffix" {
+		return fmt.Errorf("invalid -collision policy %q; expected error or suffix", collisionPolicy)
+	}
+	if maxErrors < 0 {
+		return fmt.Errorf("-max-errors can't be negative, got %d", maxErrors)
+	}
+	if err := checkModes(); err != nil {
+		return err
+	}
+	if err := checkNamedFiles(); err != nil {
+		return err
+	}
+	if bucketCount < 0 {
+		return fmt.Errorf("-bucket can't be negative, got %d", bucketCount)
+	}
+	if bucketCount > 0 && migrateSpec != "" {
+		return errors.New("-bucket can't be used with -migrate")
+	}
+	if tempBase != "" {
+		abs, err := filepath.Abs(tempBase)
+		if err != nil {
+			return err
+		}
+		tempBase = abs
+	}
+
+	if verbose && verbosity < 1 {
+		verbosity = 1
+	}
+
+	if err := checkExtensionNames(); err != nil {
+		return err
+	}
+	if err := registerStageExtensions(); err != nil {
+		return err
+	}
+	if err := checkBindingsArg(); err != nil {
+		return err
+	}
+	if err := checkCacheArgs(); err != nil {
+		return err
+	}
+	if err := checkPreludeArg(); err != nil {
+		return err
+	}
+	if err := checkLimitArgs(); err != nil {
+		return err
+	}
+	if err := checkReportArg(); err != nil {
+		return err
+	}
+
+	if goVersion != "" {
+		if _, err := parseGoVersion(goVersion); err != nil {
+			return err
+		}
+	}
+
+	if noManifest {
+		switch {
+		case internal:
+			return errors.New("-internal needs the manifest and can't be used with -no-manifest")
+		case genTests:
+			return errors.New("-gen-tests needs the manifest and can't be used with -no-manifest")
+		case manifestOnly:
+			return errors.New("-manifest-only can't be used with -no-manifest")
+		case outputMode == "fs" || outputMode == "compressed" || outputMode == "blob":
+			return fmt.Errorf("-as %s needs the manifest and can't be used with -no-manifest", outputMode)
+		}
+	}
+
+	if sinceRef != "" && (verifyMode || syntaxOnly || migrateSpec != "") {
+		return errors.New("-since can't be used with -verify, -syntax-only or -migrate")
+	}
+	if syntaxOnly && (verifyMode || manifestOnly || migrateSpec != "") {
+		return errors.New("-syntax-only can't be used with -verify, -manifest-only or -migrate")
+	}
+	if printDeps != "" && (syntaxOnly || verifyMode || manifestOnly || migrateSpec != "" || watchMode || serveAddr != "") {
+		return errors.New("-print-deps can't be used with -syntax-only, -verify, -manifest-only, -migrate, -watch or -serve")
+	}
+
+	if migrateSpec != "" {
+		if verifyMode || manifestOnly {
+			return errors.New("-migrate can't be used with -verify or -manifest-only")
+		}
+		if err := parseMigrate(migrateSpec); err != nil {
+			return err
+		}
+	}
+	if err := parseByteType(); err != nil {
+		return err
+	}
+	if err := parseRegister(); err != nil {
+		return err
+	}
+	if err := parseCross(); err != nil {
+		return err
+	}
+	if err := checkMultiTarget(); err != nil {
+		return err
+	}
+	if err := loadHeader(); err != nil {
+		return err
+	}
+
+	ccGiven = cc != ""
+	if ccTemplate != "" {
+		return parseCCTemplate()
+	}
 
 	if cc == "" {
 		if runtime.GOOS == "windows" {
@@ -164,6 +802,34 @@ func parseArgs() {
 			cc = "glslangValidator"
 		}
 	}
+
+	return nil
+}
+
+// envName returns the environment variable that provides a fallback value for
+// the named flag, e.g. SPV_SPV_VERSION for -spv-version.
+func envName(flagName string) string {
+	return "SPV_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets the flags that weren't given on the command line from their
+// environment variables, if set.
+func applyEnv() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		if v, found := os.LookupEnv(envName(f.Name)); found {
+			if serr := f.Value.Set(v); serr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, envName(f.Name), serr)
+			}
+		}
+	})
+	return err
 }
 
 func getFiles() (exitcode int) {
@@ -178,72 +844,328 @@ func getFiles() (exitcode int) {
 		return 1
 	}
 
-	fs, err := ioutil.ReadDir(".")
-	if err != nil {
-		fmt.Printf("%s error: Cannot read directory contents: %v\n", os.Args[0], err)
-	}
-
 	// sources is all GLSL files
 	// generated are all .go files generated from GLSL files
 	sources := make(map[string]e)
 	generated := make(map[string]e)
 
+	if err := scanDir(".", map[string]string{}, sources, generated); err != nil {
+		fmt.Printf("%s error: Cannot read directory contents: %v\n", os.Args[0], err)
+		return 1
+	}
+	if internal && !recursive {
+		if err := scanOutputDir(generated); err != nil {
+			fmt.Printf("%s error: Cannot read directory contents: %v\n", os.Args[0], err)
+			return 1
+		}
+	}
+
+	// Overlaid sources don't need to exist in the source directory
+	for logical := range overlay {
+		if isSourceFile(logical) && (recursive || filepath.Dir(logical) == ".") {
+			sources[filepath.ToSlash(logical)] = e{}
+		}
+	}
+
+	for file := range sources {
+		filesTotal = append(filesTotal, file)
+	}
+
+	sort.Strings(filesTotal)
+
+	if c := checkCollisions(); c != 0 {
+		return c
+	}
+
+	held, err := findStaleBuckets(generated)
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	blobModules.m = make(map[string]*blobEntry) // from an earlier run, e.g. with -watch
+
+	outputs := make(map[string]e)
+	var newSources []string
+	var recompiled int // stale only because of the compiler
+	states := checkSources(generated, held)
+	kept := filesTotal[:0]
+	for i, src := range filesTotal {
+		s := states[i]
+		if s.err != nil {
+			fmt.Printf("%s error: Cannot check %s for changes: %v\n", os.Args[0], src, s.err)
+			return 1
+		}
+		outputs[generatedName(src)] = e{}
+		if s.disabled {
+			// The last file generated from it, if any, stays in the manifest
+			if verbosity >= 1 && !quietSkip {
+				fmt.Printf("%s: skipping %s, it is marked as disabled\n", os.Args[0], src)
+			}
+			if s.found {
+				kept = append(kept, src)
+			}
+			continue
+		}
+		kept = append(kept, src)
+		if s.manifestStale {
+			manifestStale = true
+		}
+		if s.stale {
+			filesToGenerate = append(filesToGenerate, src)
+		} else {
+			printStatus(status{1, fmt.Sprintf("%s is unmodified; skipping", src), true, src})
+		}
+		if s.compilerChanged {
+			recompiled++
+		}
+		if !s.found && !isSPIRVFile(src) {
+			newSources = append(newSources, src)
+		}
+	}
+	filesTotal = kept
+	if recompiled > 0 && verbosity >= 1 {
+		fmt.Printf("%s: regenerating %d files due to a compiler change\n", os.Args[0], recompiled)
+	}
+
+	for gen := range generated {
+		if _, found := outputs[gen]; !found {
+			filesToDelete = append(filesToDelete, gen)
+		}
+	}
+	sort.Strings(filesToDelete)
+	detectRenames(newSources)
+
+	return
+}
+
+// scanDir adds the GLSL sources in dir to sources and the files generated
+// from them to generated. Generated files are only looked for in the source
+// directory itself, since the whole tree generates into a single package.
+//
+// Symlinks to files are treated like the files they point to. Symlinks to
+// directories are only followed with -follow-symlinks, in which case visited
+// maps the real paths of the directories scanned so far to the paths they
+// were scanned as, so that loops and directories linked to twice are skipped.
+func scanDir(dir string, visited map[string]string, sources, generated map[string]e) error {
+	if followSymlinks {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if first, found := visited[real]; found {
+			fmt.Printf("%s warning: skipping %s, already scanned as %s\n", os.Args[0], dir, first)
+			return nil
+		}
+		visited[real] = dir
+	}
+	scannedDirs = append(scannedDirs, dir)
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var subdirs, linkedDirs []string
 	for _, f := range fs {
+		filename := path.Join(dir, f.Name())
+		if f.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(filename)
+			if err != nil {
+				if isSourceFile(filename) {
+					fmt.Printf("%s warning: skipping %s: %v\n", os.Args[0], filename, err)
+				}
+				continue
+			}
+			if target.IsDir() {
+				if followSymlinks {
+					linkedDirs = append(linkedDirs, filename)
+				}
+				continue
+			}
+			f = target
+		}
+
 		if f.IsDir() {
+			subdirs = append(subdirs, filename)
 			continue
 		}
 
-		filename := f.Name()
 		switch {
-		case filename == manifestFilename:
+		case filename == manifestPath():
 			manifestFound = true
-		case isGLSLFile(filename):
+		case isSourceFile(filename):
 			sources[filename] = e{}
-		case isGeneratedFromGLSL(filename):
+		case (dir == outputDir() || dir == ".") && isGeneratedFromGLSL(filename):
+			// Files generated into the source directory before -internal
+			// was used are deleted
 			generated[filename] = e{}
 		}
 	}
 
-	for src := range sources {
-		gen := generatedName(src)
-		_, found := generated[gen]
-		if force || !found || isNewer(src, gen) {
-			filesToGenerate = append(filesToGenerate, src)
+	if !recursive {
+		return nil
+	}
+	// Real directories come first so that they are preferred over links
+	for _, sub := range append(subdirs, linkedDirs...) {
+		if strings.HasPrefix(path.Base(sub), ".") || isEmbedDir(sub) {
+			continue
 		}
+		if err := scanDir(sub, visited, sources, generated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCollisions reports sources that would be generated into the same file
+// or, unless the -collision policy is "suffix", under the same identifier.
+func checkCollisions() (exitcode int) {
+	suffixedIdentifiers = make(map[string]string)
+	byOutput := make(map[string][]string)
+	byIdent := make(map[string][]string)
+	for _, src := range filesTotal {
+		gen := shaderFileName(src) // buckets hold several sources on purpose
+		byOutput[gen] = append(byOutput[gen], src)
+		id := makeIdentifier(src)
+		byIdent[id] = append(byIdent[id], src)
+	}
+	if collisionPolicy == "suffix" {
+		suffixIdentifiers(byIdent)
 	}
 
-	for gen := range generated {
-		if _, found := sources[originalName(gen)]; !found {
-			filesToDelete = append(filesToDelete, gen)
+	report := func(what string, m map[string][]string) {
+		var keys []string
+		for k, srcs := range m {
+			if len(srcs) > 1 {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s error: %s %s is shared by %s\n", os.Args[0], what, k, strings.Join(m[k], ", "))
+			exitcode = 1
 		}
 	}
+	report("output file", byOutput)
+	if collisionPolicy != "suffix" {
+		report("identifier", byIdent)
+	}
 
-	for file := range sources {
-		filesTotal = append(filesTotal, file)
+	if exitcode != 0 && recursive && !flattenSuffix {
+		fmt.Printf("%s: use -flatten-suffix to include directories in generated file names\n", os.Args[0])
 	}
+	return
+}
 
-	sort.Strings(filesTotal)
+// outputModeNames returns the accepted -as values, sorted.
+func outputModeNames() []string {
+	var names []string
+	for mode := range outputModes {
+		names = append(names, mode)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	return
+func isValidSPVVersion(v string) bool {
+	for _, valid := range validSPVVersions {
+		if v == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// isSourceFile returns true for GLSL sources and precompiled SPIR-V modules.
+func isSourceFile(filename string) bool {
+	return isGLSLFile(filename) || isSPIRVFile(filename)
 }
 
 func isGLSLFile(filename string) bool {
+	if isSPIRVFile(filename) {
+		return false
+	}
+	_, wellIsIt := validExtensions[stageExtension(filename)]
+	return wellIsIt || hasForcedStage(filename)
+}
+
+func isSPIRVFile(filename string) bool {
+	return filepath.Ext(filename) == ".spv"
+}
+
+// stageExtension returns the extension of filename that determines the stage,
+// ignoring a trailing .glsl or .spv.
+func stageExtension(filename string) string {
 	ext := filepath.Ext(filename)
-	if ext == ".glsl" {
-		ext = filepath.Ext(filename[:len(filename)-5])
+	if ext == ".glsl" || ext == ".spv" {
+		ext = filepath.Ext(filename[:len(filename)-len(ext)])
+	}
+	return ext
+}
+
+// stageOf returns the stage of the given source file. Precompiled modules
+// without a stage extension (foo.spv rather than foo.frag.spv) are Unknown.
+func stageOf(filename string) string {
+	if stage, found := validExtensions[stageExtension(filename)]; found {
+		return stage
+	}
+	if hasForcedStage(filename) {
+		return forcedStage
 	}
-	_, wellIsIt := validExtensions[ext]
-	return wellIsIt
+	return "Unknown"
 }
 
-// Returns the generated filename for the given original filename
+// Returns the generated filename for the given original filename: its bucket
+// file with -bucket, or else its own file. Sources in subdirectories are
+// generated into the output directory.
 func generatedName(original string) string {
-	return original + genExtension
+	if bucketCount > 0 {
+		return bucketName(bucketOf(original))
+	}
+	return shaderFileName(original)
+}
+
+// shaderFileName returns the name of the file generated for the source
+// original alone, which also names its module with -as fs.
+func shaderFileName(original string) string {
+	if flattenSuffix {
+		return path.Join(outputDir(), strings.ReplaceAll(original, "/", "_")+genExtension)
+	}
+	return path.Join(outputDir(), path.Base(original)+genExtension)
+}
+
+// outputDir returns the directory the shader data and manifest are generated
+// into, relative to the source directory.
+func outputDir() string {
+	if internal {
+		return internalDir
+	}
+	return "."
+}
+
+// manifestPath returns the path of the manifest.
+func manifestPath() string {
+	return path.Join(outputDir(), manifestFilename)
+}
+
+// dataPackage returns the name of the package the shader data and manifest
+// are generated into.
+func dataPackage() string {
+	if internal {
+		return path.Base(internalDir)
+	}
+	return pkg
 }
 
 func isGeneratedFromGLSL(filename string) bool {
-	if strings.HasSuffix(filename, ".gen.go") {
-		return isGLSLFile(filename[:len(filename)-7])
+	if isBucketFile(filename) {
+		return true
+	}
+	if strings.HasSuffix(filename, genExtension) {
+		// .glsl sources without a stage extension are only sources with -S,
+		// but their files are removed once it is dropped
+		original := filename[:len(filename)-len(genExtension)]
+		return isSourceFile(original) || filepath.Ext(original) == ".glsl"
 	}
 	return false
 }
@@ -257,21 +1179,24 @@ func originalName(generated string) string {
 }
 
 // Returns true if the file 'this' is newer than 'that'.
-func isNewer(this, that string) bool {
+func isNewer(this, that string) (bool, error) {
 	dis, err := os.Stat(this)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	dat, err := os.Stat(that)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 
-	return dat.ModTime().Before(dis.ModTime())
+	return dat.ModTime().Before(dis.ModTime()), nil
 }
 
 // makeIdentifier turns filenames into camelcase'd identifiers
 func makeIdentifier(s string) string {
+	if id, found := suffixedIdentifiers[s]; found {
+		return id
+	}
 	// if strings.HasSuffix(s, ".glsl") {
 	// 	s = s[:len(s)-5]
 	// }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCompiler stands in for glslangValidator, writing a minimal valid module
+// of a Shader capability and memory model into the -o file. It appends its
+// --version and --help runs to the file $SPV_TEST_PROBES, if set.
+const fakeCompiler = `#!/bin/sh
+out=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--version|--help)
+		[ -z "$SPV_TEST_PROBES" ] || echo "$1" >>"$SPV_TEST_PROBES"
+		if [ "$1" = --help ]; then echo "Usage: glslangValidator [option]... [file]..."; else echo "Glslang Version: 11:spv-test"; fi
+		exit 0 ;;
+	-o) out=$2; shift ;;
+	esac
+	shift
+done
+if [ -n "$out" ]; then
+	printf '\003\002\043\007\000\000\001\000\001\000\010\000\012\000\000\000\000\000\000\000' >"$out"
+	printf '\021\000\002\000\001\000\000\000\016\000\003\000\000\000\000\000\001\000\000\000' >>"$out"
+fi
+`
+
+// flagDefaults holds the values of the flags before any runSPV.
+var flagDefaults map[string]string
+
+// runSPV generates the package in dir with the flags in args, as the command
+// does, compiling with fakeCompiler, and returns the exit code.
+func runSPV(t testing.TB, dir string, args ...string) int {
+	t.Helper()
+	return runSPVWith(t, dir, generate, args...)
+}
+
+// runSPVWith is runSPV running run, e.g. runTargets, instead of generate.
+func runSPVWith(t testing.TB, dir string, run func() int, args ...string) int {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake compiler is a shell script")
+	}
+	if flagDefaults == nil {
+		if err := parseArgs(); err != nil {
+			t.Fatal(err)
+		}
+		flagDefaults = make(map[string]string)
+		flag.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, "test.") {
+				flagDefaults[f.Name] = f.Value.String()
+			}
+		})
+	}
+	for name, value := range flagDefaults {
+		if l, ok := flag.Lookup(name).Value.(*stringList); ok {
+			l.reset()
+		}
+		flag.Set(name, value)
+	}
+
+	ccDir, err := ioutil.TempDir("", "spv-cc-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ccDir)
+	cc := filepath.Join(ccDir, "glslangValidator")
+	if err := ioutil.WriteFile(cc, []byte(fakeCompiler), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.CommandLine.Parse(append([]string{"-cc", cc, "-pkg", "x", "-dir", dir}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	namedFiles = flag.Args()
+	if err := finishArgs(); err != nil {
+		t.Fatal(err)
+	}
+	resetState()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	return run()
+}
+
+// listFiles returns the names of the files in dir, sorted.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range fs {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestScanDirSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "shaders")
+	for _, d := range []string{root, filepath.Join(root, "sub"), filepath.Join(dir, "shared")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, dir, map[string]string{
+		"shaders/a.frag":     "void main() {}\n",
+		"shaders/sub/b.vert": "void main() {}\n",
+		"shared/c.comp":      "void main() {}\n",
+	})
+	links := map[string]string{
+		"shaders/linked.frag": filepath.Join(dir, "shared", "c.comp"),
+		"shaders/broken.frag": filepath.Join(dir, "shared", "missing.frag"),
+		"shaders/shared":      filepath.Join(dir, "shared"),
+		"shaders/sub/loop":    root,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("can't create symlinks: %v", err)
+		}
+	}
+
+	defer func(r, f bool) { recursive, followSymlinks = r, f }(recursive, followSymlinks)
+	tests := []struct {
+		name             string
+		recursive        bool
+		followSymlinks   bool
+		sources, scanned []string // relative to the source directory
+	}{
+		{"flat", false, false, []string{"a.frag", "linked.frag"}, []string{"."}},
+		{"recursive", true, false, []string{"a.frag", "linked.frag", "sub/b.vert"}, []string{".", "sub"}},
+		{
+			"following symlinks", true, true,
+			[]string{"a.frag", "linked.frag", "shared/c.comp", "sub/b.vert"},
+			[]string{".", "shared", "sub"}, // sub/loop leads back to .
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recursive, followSymlinks = tt.recursive, tt.followSymlinks
+			scannedDirs = nil
+			sources, generated := make(map[string]e), make(map[string]e)
+			if err := scanDir(root, make(map[string]string), sources, generated); err != nil {
+				t.Fatal(err)
+			}
+			rel := func(paths []string) string {
+				for i, p := range paths {
+					if paths[i], err = filepath.Rel(root, p); err != nil {
+						t.Fatal(err)
+					}
+					paths[i] = filepath.ToSlash(paths[i])
+				}
+				sort.Strings(paths)
+				return strings.Join(paths, " ")
+			}
+			var found []string
+			for src := range sources {
+				found = append(found, src)
+			}
+			if got, want := rel(found), strings.Join(tt.sources, " "); got != want {
+				t.Errorf("sources %s, want %s", got, want)
+			}
+			if got, want := rel(scannedDirs), strings.Join(tt.scanned, " "); got != want {
+				t.Errorf("scanned %s, want %s", got, want)
+			}
+		})
+	}
+
+	// A symlinked shader is stale when its target changes
+	gen := filepath.Join(root, "linked.frag.gen.go")
+	writeFiles(t, dir, map[string]string{"shaders/linked.frag.gen.go": "package x\n"})
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(gen, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "shared", "c.comp"), old.Add(-time.Hour), old.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "linked.frag")
+	if newer, err := isNewer(link, gen); err != nil || newer {
+		t.Errorf("symlinked shader with an older target is stale: %v, %v", newer, err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "shared", "c.comp"), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if newer, err := isNewer(link, gen); err != nil || !newer {
+		t.Errorf("symlinked shader with a changed target isn't stale: %v, %v", newer, err)
+	}
+	if _, err := isNewer(filepath.Join(root, "broken.frag"), gen); err == nil {
+		t.Error("no error for a broken symlink")
+	}
+}
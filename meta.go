@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// metaPrefix starts the comments in generated files that describe how they
+// were generated, so that the manifest can be rebuilt without the compiler.
+const metaPrefix = "//spv:"
+
+// genMeta is the metadata recorded in a generated file.
+type genMeta struct {
+	Source      string // logical path of the source
+	Stage       string
+	As          string   // output mode of the binary data
+	ByteType    string   // -byte-type, empty for the default
+	MultiTarget []string // not recorded for precompiled modules
+	Identifier  string   // given by -collision suffix, empty if not renamed
+	Register    string   // -register, empty without it
+	Reflect     bool
+	EmbedSource bool
+	Checksums   bool
+	Inline      bool   // with -as fs, the module is a literal rather than embedded
+	Hash        string // hash of the source and its includes; see includeScanner.hash
+	Fingerprint string // hash of the compiler arguments; see argsFingerprint
+	Compiler    string // hash of the compiler; see compilerHash
+}
+
+// writeMeta writes the metadata comments for the file generated from source.
+func writeMeta(outFile *bufio.Writer, source string) {
+	fmt.Fprintf(outFile, "%ssource %s\n", metaPrefix, source)
+	fmt.Fprintf(outFile, "%sstage %s\n", metaPrefix, stageOf(source))
+	fmt.Fprintf(outFile, "%sas %s\n", metaPrefix, outputMode)
+	if byteOutput() && byteType != defaultByteType {
+		fmt.Fprintf(outFile, "%sbyte-type %s\n", metaPrefix, byteType)
+	}
+	if isInlineFS(source) {
+		fmt.Fprintf(outFile, "%sinline\n", metaPrefix)
+	}
+	if !isSPIRVFile(source) {
+		if h, err := includes.hash(source); err == nil {
+			fmt.Fprintf(outFile, "%shash %s\n", metaPrefix, h)
+		}
+	}
+	if !isSPIRVFile(source) {
+		fmt.Fprintf(outFile, "%sfingerprint %s\n", metaPrefix, argsFingerprint(source))
+		if id := compilerHash(); id != "" {
+			fmt.Fprintf(outFile, "%scompiler %s\n", metaPrefix, id)
+		}
+	}
+	if id, found := suffixedIdentifiers[source]; found {
+		fmt.Fprintf(outFile, "%sidentifier %s\n", metaPrefix, id)
+	}
+	if registerFunc != "" {
+		fmt.Fprintf(outFile, "%sregister %s\n", metaPrefix, registerFunc)
+	}
+	if reflect {
+		fmt.Fprintf(outFile, "%sreflect\n", metaPrefix)
+	}
+	if embedSource {
+		fmt.Fprintf(outFile, "%sembed-source\n", metaPrefix)
+	}
+	if checksums {
+		fmt.Fprintf(outFile, "%schecksums\n", metaPrefix)
+	}
+	if canonical {
+		fmt.Fprintf(outFile, "%scanonicalize\n", metaPrefix)
+	}
+	if len(multiTarget) > 0 && !isSPIRVFile(source) {
+		fmt.Fprintf(outFile, "%smulti-target %s\n", metaPrefix, multiTarget.String())
+	}
+	if len(crossOutputs) > 0 {
+		fmt.Fprintf(outFile, "%scross %s\n", metaPrefix, strings.Join(crossNames(), ","))
+	}
+	if len(enableExt) > 0 {
+		fmt.Fprintf(outFile, "%senable-ext %s\n", metaPrefix, enableExt.String())
+	}
+}
+
+// readMeta reads the metadata comments before the package clause of a
+// generated file. A bucket file has the metadata of several sources, of
+// which it returns the first.
+func readMeta(filename string) (genMeta, error) {
+	metas, err := readMetas(filename)
+	if err != nil {
+		return genMeta{}, err
+	}
+	return metas[0], nil
+}
+
+// readSourceMeta returns the metadata of the source src in its generated file
+// gen, which is a bucket file with -bucket.
+func readSourceMeta(src, gen string) (genMeta, error) {
+	metas, err := readMetas(gen)
+	if err != nil {
+		return genMeta{}, err
+	}
+	for _, m := range metas {
+		if m.Source == src {
+			return m, nil
+		}
+	}
+	return genMeta{}, fmt.Errorf("%s has no spv metadata for %s", gen, src)
+}
+
+// readMetas reads the metadata of every source in a generated file; each
+// starts with its source line.
+func readMetas(filename string) ([]genMeta, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var metas []genMeta
+	m := &genMeta{}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if !strings.HasPrefix(line, metaPrefix) {
+			continue
+		}
+		key, value := line[len(metaPrefix):], ""
+		if i := strings.IndexByte(key, ' '); i >= 0 {
+			key, value = key[:i], key[i+1:]
+		}
+		switch key {
+		case "source":
+			metas = append(metas, genMeta{Source: value})
+			m = &metas[len(metas)-1]
+		case "stage":
+			m.Stage = value
+		case "as":
+			m.As = value
+		case "byte-type":
+			m.ByteType = value
+		case "multi-target":
+			m.MultiTarget = strings.Split(value, ",")
+		case "identifier":
+			m.Identifier = value
+		case "register":
+			m.Register = value
+		case "hash":
+			m.Hash = value
+		case "fingerprint":
+			m.Fingerprint = value
+		case "compiler":
+			m.Compiler = value
+		case "reflect":
+			m.Reflect = true
+		case "embed-source":
+			m.EmbedSource = true
+		case "checksums":
+			m.Checksums = true
+		case "inline":
+			m.Inline = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(metas) == 0 {
+		return nil, fmt.Errorf("%s has no spv metadata; regenerate it with -force", filename)
+	}
+	return metas, nil
+}
+
+// rebuildManifest rewrites the manifest from the metadata of the generated
+// files in the output directory, without compiling anything. The output
+// options are taken from the files, which must agree on them.
+func rebuildManifest() int {
+	fs, err := ioutil.ReadDir(outputDir())
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	var metas []genMeta
+	var glsl *genMeta // the first of a GLSL source, as precompiled modules have no targets
+	for _, f := range fs {
+		filename := path.Join(outputDir(), f.Name())
+		if f.IsDir() || !isGeneratedFromGLSL(filename) || !hasGeneratedHeader(filename) {
+			continue
+		}
+		ms, err := readMetas(filename)
+		if err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+		for _, m := range ms {
+			m := m
+			if generatedName(m.Source) != filename {
+				fmt.Printf("%s error: %s was generated from %s under a different name\n", os.Args[0], filename, m.Source)
+				return 1
+			}
+			if len(metas) > 0 && (m.As != metas[0].As || m.ByteType != metas[0].ByteType || m.Reflect != metas[0].Reflect || m.EmbedSource != metas[0].EmbedSource || m.Checksums != metas[0].Checksums) {
+				fmt.Printf("%s error: %s and %s were generated with different options; regenerate them with -force\n",
+					os.Args[0], metas[0].Source, m.Source)
+				return 1
+			}
+			if !isSPIRVFile(m.Source) {
+				if glsl == nil {
+					glsl = &m
+				} else if strings.Join(m.MultiTarget, ",") != strings.Join(glsl.MultiTarget, ",") {
+					fmt.Printf("%s error: %s and %s were compiled for different targets; regenerate them with -force\n",
+						os.Args[0], glsl.Source, m.Source)
+					return 1
+				}
+			}
+			metas = append(metas, m)
+		}
+	}
+
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Source < metas[j].Source })
+	filesTotal = nil
+	for _, m := range metas {
+		filesTotal = append(filesTotal, m.Source)
+	}
+	if len(metas) > 0 {
+		outputMode = metas[0].As
+		reflect = metas[0].Reflect
+		embedSource = metas[0].EmbedSource
+		checksums = metas[0].Checksums
+		multiTarget = nil
+		if glsl != nil {
+			multiTarget = glsl.MultiTarget
+		}
+		if byteType = metas[0].ByteType; byteType == "" {
+			byteType = defaultByteType
+		}
+	}
+	if _, found := outputModes[outputMode]; !found {
+		fmt.Printf("%s error: Invalid output mode %q in generated files\n", os.Args[0], outputMode)
+		return 1
+	}
+	if c := checkCollisions(); c != 0 {
+		return c
+	}
+	if verbosity >= 1 {
+		fmt.Printf("%s: rebuilding the manifest from %d generated files\n", os.Args[0], len(metas))
+	}
+
+	return writeManifest()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// migrateFrom and migrateTo are the output modes given with -migrate. An
+// empty migrateFrom accepts generated files in any mode.
+var migrateFrom, migrateTo string
+
+// parseMigrate parses a -migrate value like "from=words to=string" and makes
+// the target mode the output mode.
+func parseMigrate(spec string) error {
+	for _, field := range strings.Fields(spec) {
+		i := strings.IndexByte(field, '=')
+		if i < 0 {
+			return fmt.Errorf("invalid -migrate argument %q; expected from=MODE or to=MODE", field)
+		}
+		key, mode := field[:i], field[i+1:]
+		if _, found := outputModes[mode]; !found {
+			return fmt.Errorf("invalid output mode %q in -migrate; accepted modes are %s", mode, strings.Join(outputModeNames(), ", "))
+		}
+		switch key {
+		case "from":
+			migrateFrom = mode
+		case "to":
+			migrateTo = mode
+		default:
+			return fmt.Errorf("invalid -migrate argument %q; expected from=MODE or to=MODE", field)
+		}
+	}
+	if migrateTo == "" {
+		return fmt.Errorf("-migrate needs a target mode, e.g. -migrate to=%s", outputMode)
+	}
+	outputMode = migrateTo
+	return nil
+}
+
+// prepareMigration checks the modes of the existing generated files and forces
+// regenerating all of them if any is not in the target mode yet, so that the
+// manifest and the files agree again. Running it again after a successful
+// migration is an ordinary run.
+func prepareMigration() int {
+	names, err := generatedFiles()
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	var stale int
+	for _, name := range names {
+		m, err := readMeta(name)
+		if err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+		if m.As == migrateTo {
+			continue
+		}
+		if migrateFrom != "" && m.As != migrateFrom {
+			fmt.Printf("%s error: %s was generated as %s, not %s\n", os.Args[0], name, m.As, migrateFrom)
+			return 1
+		}
+		stale++
+	}
+
+	if stale == 0 {
+		if verbosity >= 1 {
+			fmt.Printf("%s: all generated files are already in mode %s\n", os.Args[0], migrateTo)
+		}
+		return 0
+	}
+	if verbosity >= 1 {
+		fmt.Printf("%s: migrating %d of %d generated files to mode %s\n", os.Args[0], stale, len(names), migrateTo)
+	}
+	force = true
+	return 0
+}
+
+// generatedFiles returns the per-shader files written by spv in the output
+// directory, and with -recursive in its subdirectories.
+func generatedFiles() ([]string, error) {
+	var names []string
+	add := func(name string) {
+		if isGeneratedFromGLSL(name) && hasGeneratedHeader(name) {
+			names = append(names, name)
+		}
+	}
+
+	if !recursive {
+		fs, err := ioutil.ReadDir(outputDir())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, f := range fs {
+			if !f.IsDir() {
+				add(path.Join(outputDir(), f.Name()))
+			}
+		}
+		return names, nil
+	}
+
+	err := filepath.Walk(outputDir(), func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && name != outputDir() && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			add(filepath.ToSlash(name))
+		}
+		return nil
+	})
+	return names, err
+}
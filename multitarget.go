@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// multiTarget lists the target environments given with -multi-target, e.g.
+// "vulkan1.0,vulkan1.2". Every GLSL source is compiled once more for each of
+// them, next to the module built with the compiler arguments as given.
+var multiTarget stringList
+
+// checkMultiTarget validates the -multi-target environments against the
+// other flags.
+func checkMultiTarget() error {
+	if len(multiTarget) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, env := range multiTarget {
+		if _, found := targetEnvSPIRV[env]; !found {
+			var accepted []string
+			for e := range targetEnvSPIRV {
+				accepted = append(accepted, e)
+			}
+			sort.Strings(accepted)
+			return fmt.Errorf("invalid -multi-target environment %q; accepted environments are %s", env, strings.Join(accepted, ", "))
+		}
+		if seen[env] {
+			return fmt.Errorf("-multi-target environment %s is given twice", env)
+		}
+		seen[env] = true
+	}
+	switch {
+	case outputMode == "fs" || outputMode == "blob":
+		return fmt.Errorf("-multi-target can't be used with -as %s", outputMode)
+	case ccTemplate != "":
+		return errors.New("-multi-target can't be used with -cc-template, whose arguments spv doesn't know")
+	case spvVersion != "":
+		return errors.New("-multi-target can't be used with -spv-version, which would override the targets")
+	}
+	return nil
+}
+
+// targetSuffix returns the suffix of the identifiers of the module compiled
+// for env, e.g. "Vulkan12" for vulkan1.2.
+func targetSuffix(env string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range env {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if upper {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+			upper = unicode.IsDigit(r)
+		default:
+			upper = true
+		}
+	}
+	return b.String()
+}
+
+// withTargetEnv returns the compiler arguments args with their target
+// environment replaced by env.
+func withTargetEnv(args []string, env string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--target-env" && i+1 < len(args):
+			i++
+		case strings.HasPrefix(args[i], "--target-env="):
+		default:
+			out = append(out, args[i])
+		}
+	}
+	// Before the trailing -o out src
+	n := len(out) - 3
+	return append(append(out[:n:n], "--target-env", env), out[n:]...)
+}
+
+// buildTargets builds the module of the source file f for every target of
+// sourceTargets, i.e. every -multi-target environment or variant, and returns
+// them in the same order. The warnings of the compilers that aren't among the
+// warnings of the default build are added to them. Precompiled modules are
+// the same for every target, so they get nil modules.
+func buildTargets(ctx context.Context, f string, warnings []string, statusChan chan status) ([][]uint32, []string, error) {
+	targets, err := sourceTargets(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isSPIRVFile(f) {
+		return make([][]uint32, len(targets)), warnings, nil
+	}
+	var modules [][]uint32
+	for _, t := range targets {
+		spvFile, w, err := buildCached(ctx, f, t, statusChan)
+		if err == errInterrupted {
+			return nil, nil, err
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("for %s: %v", t, err)
+		}
+		words, err := readSPIRVFile(spvFile)
+		if err == nil && provenance {
+			words, err = addProvenance(f, t, words)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("for %s: %v", t, err)
+		}
+		modules = append(modules, words)
+		warnings = addTargetWarnings(warnings, t, w)
+	}
+	return modules, warnings, nil
+}
+
+// addTargetWarnings adds the warnings w of compiling for t that aren't in
+// warnings already.
+func addTargetWarnings(warnings []string, t target, w []string) []string {
+	seen := make(map[string]bool)
+	for _, msg := range warnings {
+		seen[msg] = true
+	}
+	for _, msg := range w {
+		if !seen[msg] {
+			warnings = append(warnings, fmt.Sprintf("for %s: %s", t, msg))
+		}
+	}
+	return warnings
+}
+
+// targetModules returns the identifiers of the modules compiled from src for
+// the -multi-target environments, for the manifest.
+func targetModules(src string) []string {
+	if isSPIRVFile(src) {
+		return nil
+	}
+	var ids []string
+	for _, env := range multiTarget {
+		ids = append(ids, makeSliceIdentifier(src)+targetSuffix(env))
+	}
+	return ids
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// namedFiles are the sources given as arguments, relative to the source
+// directory. Only they are compiled, whether or not they are stale, while the
+// manifest still lists every source.
+var namedFiles []string
+
+// checkNamedFiles returns an error if sources are named along with a flag
+// that works on all of them.
+func checkNamedFiles() error {
+	if len(namedFiles) == 0 {
+		return nil
+	}
+	for _, m := range commandModes {
+		if *m.set {
+			return fmt.Errorf("sources can't be named with -%s", m.flag)
+		}
+	}
+	switch {
+	case watchMode || serveAddr != "":
+		return errors.New("sources can't be named with -watch or -serve")
+	case sinceRef != "":
+		return errors.New("sources can't be named with -since")
+	case manifestOnly || migrateSpec != "":
+		return errors.New("sources can't be named with -manifest-only or -migrate")
+	}
+	return nil
+}
+
+// restrictToNamed replaces filesToGenerate with the named sources, which have
+// to be among the sources found in the source directory. Files left from
+// removed sources are kept until the next run without names.
+func restrictToNamed() error {
+	known := make(map[string]bool)
+	for _, src := range filesTotal {
+		known[src] = true
+	}
+
+	filesToGenerate = nil
+	for _, name := range namedFiles {
+		src := filepath.ToSlash(filepath.Clean(name))
+		switch {
+		case !isSourceFile(src):
+			return fmt.Errorf("%s is not a shader source; see -stage-ext for custom extensions", name)
+		case isDisabled(src):
+			return fmt.Errorf("%s is marked as disabled", name)
+		case !known[src]:
+			if !recursive && filepath.Dir(src) != "." {
+				return fmt.Errorf("%s is in a subdirectory, which needs -recursive", name)
+			}
+			return fmt.Errorf("%s is not a source in the directory", name)
+		}
+		filesToGenerate = append(filesToGenerate, src)
+	}
+	filesToDelete = nil
+	force = true // only for the named files
+	return nil
+}
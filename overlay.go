@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// overlay maps logical source paths, relative to the source directory, to the
+// files that are actually compiled in their place.
+var overlay map[string]string
+
+// loadOverlay reads the overlay mapping from a JSON object. Replacement paths
+// are relative to the current working directory.
+func loadOverlay(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	overlay = make(map[string]string, len(m))
+	for logical, actual := range m {
+		abs, err := filepath.Abs(actual)
+		if err != nil {
+			return err
+		}
+		overlay[filepath.Clean(logical)] = abs
+	}
+	return nil
+}
+
+// sourcePath returns the path of the file to read for the given logical path.
+func sourcePath(logical string) string {
+	if actual, found := overlay[filepath.Clean(logical)]; found {
+		return actual
+	}
+	return logical
+}
+
+// isOverlaid returns true if the logical path is replaced by the overlay.
+func isOverlaid(logical string) bool {
+	_, found := overlay[filepath.Clean(logical)]
+	return found
+}
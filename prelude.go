@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// preludeFile is the file given with -prelude, whose contents are compiled
+// at the top of every GLSL source.
+var preludeFile string
+
+// preludeText holds the contents of preludeFile, and preludeHash their hash
+// for the fingerprints. Both are empty without -prelude.
+var (
+	preludeText []byte
+	preludeHash string
+)
+
+// checkPreludeArg makes the -prelude path absolute, as it is given relative to
+// the working directory rather than -dir.
+func checkPreludeArg() error {
+	if preludeFile == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(preludeFile)
+	if err != nil {
+		return err
+	}
+	preludeFile = abs
+	return nil
+}
+
+// loadPrelude reads the -prelude file. Like the overlay it is read on every
+// run, so that -watch picks up changes.
+func loadPrelude() error {
+	data, err := ioutil.ReadFile(preludeFile)
+	if err != nil {
+		return err
+	}
+	preludeText = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	sum := sha256.Sum256(preludeText)
+	preludeHash = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// compilerInput returns the file the compiler reads the source src from: the
+// source itself, or with -prelude a copy in the temp directory with the
+// prelude in front, as written by writePreluded.
+func compilerInput(src string) string {
+	if preludeFile == "" {
+		return loader.path(src)
+	}
+	return filepath.Join(tempDir, "prelude", filepath.FromSlash(src))
+}
+
+// writePreluded writes the copy of src with the prelude that compilerInput
+// names.
+func writePreluded(src string) error {
+	data, err := loader.read(src)
+	if err != nil {
+		return err
+	}
+	name := compilerInput(src)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return writeAtomic(name, func(w io.Writer) error {
+		_, err := w.Write(withPrelude(data))
+		return err
+	})
+}
+
+// withPrelude returns the source with the prelude inserted after its #version
+// line, or in front of it if it has none, in which case the prelude may give
+// the version. A #line directive after the prelude makes the compiler number
+// the lines of the source as in the original file. The #version of the
+// prelude is dropped if the source has its own.
+func withPrelude(source []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(source), "\r\n", "\n"), "\n")
+	var b strings.Builder
+	v, version := versionLine(lines)
+	if v < 0 {
+		b.Write(preludeText)
+		if len(preludeText) > 0 && preludeText[len(preludeText)-1] != '\n' {
+			b.WriteString("\n")
+		}
+		_, version = versionLine(strings.Split(string(preludeText), "\n"))
+		b.WriteString(lineDirective(1, version))
+		b.WriteString(strings.Join(lines, "\n"))
+		return []byte(b.String())
+	}
+
+	for _, line := range lines[:v+1] {
+		b.WriteString(line + "\n")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(preludeText), "\n"), "\n") {
+		if !isVersionDirective(line) {
+			b.WriteString(line + "\n")
+		}
+	}
+	b.WriteString(lineDirective(v+2, version))
+	b.WriteString(strings.Join(lines[v+1:], "\n"))
+	return []byte(b.String())
+}
+
+// versionLine returns the index of the #version line among lines and its
+// arguments, e.g. "450 core", or -1 if there is none.
+func versionLine(lines []string) (int, string) {
+	for i, line := range lines {
+		if fields := directiveFields(line); len(fields) > 0 && fields[0] == "version" {
+			return i, strings.Join(fields[1:], " ")
+		}
+	}
+	return -1, ""
+}
+
+func isVersionDirective(line string) bool {
+	fields := directiveFields(line)
+	return len(fields) > 0 && fields[0] == "version"
+}
+
+// directiveFields returns the fields of a preprocessor directive line after
+// the #, or nil for other lines.
+func directiveFields(line string) []string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "#") {
+		return nil
+	}
+	return strings.Fields(line[1:])
+}
+
+// lineDirective returns a #line directive that makes the next line line
+// number next in a source of the version. Desktop GLSL before 3.30 numbers
+// the line after the directive one higher than the other versions do.
+func lineDirective(next int, version string) string {
+	fields := strings.Fields(version)
+	n := 110 // the default version
+	if len(fields) > 0 {
+		if v, err := strconv.Atoi(fields[0]); err == nil {
+			n = v
+		}
+	}
+	es := n == 100 || len(fields) > 1 && fields[1] == "es"
+	if !es && n < 330 {
+		next--
+	}
+	return "#line " + strconv.Itoa(next) + "\n"
+}
+
+// unpreluded replaces the path of the copy compiled for src in the compiler
+// output with the path of the source itself.
+func unpreluded(src, output string) string {
+	if preludeFile == "" {
+		return output
+	}
+	return strings.ReplaceAll(output, compilerInput(src), loader.path(src))
+}
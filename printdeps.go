@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// printDeps is the format of -print-deps: "list" for all the files each source
+// includes, "tree" for the includes of each file nested under it, or "" to
+// generate as usual.
+var printDeps depsFormat
+
+// depsFormat is the value of -print-deps, which can be given alone for a list
+// or as -print-deps=tree.
+type depsFormat string
+
+func (f *depsFormat) String() string {
+	if f == nil {
+		return ""
+	}
+	return string(*f)
+}
+
+func (f *depsFormat) Set(value string) error {
+	switch value {
+	case "true", "list":
+		*f = "list"
+	case "false":
+		*f = ""
+	case "tree":
+		*f = "tree"
+	default:
+		return fmt.Errorf("expected list or tree")
+	}
+	return nil
+}
+
+func (f *depsFormat) IsBoolFlag() bool { return true }
+
+// depsRecord is the JSON record of a file for -print-deps with -json. The
+// records of all the files make up the include graph.
+type depsRecord struct {
+	File     string   `json:"file"`
+	Source   bool     `json:"source,omitempty"`   // a shader source rather than an included file
+	Includes []string `json:"includes,omitempty"` // directly included and linked files
+	Missing  []string `json:"missing,omitempty"`  // #includes that couldn't be resolved
+	Deps     []string `json:"deps,omitempty"`     // all files the source depends on
+	Error    string   `json:"error,omitempty"`
+}
+
+// printDependencies prints the files that the sources found by getFiles
+// depend on, as spv sees them when deciding what to rebuild, without
+// compiling anything. With named sources only those are printed.
+func printDependencies() int {
+	sources := filesTotal
+	if len(namedFiles) > 0 {
+		sources = filesToGenerate
+	}
+	if jsonLog {
+		return printDepsJSON(sources)
+	}
+
+	var failed int
+	for _, src := range sources {
+		fmt.Println(src)
+		if isSPIRVFile(src) {
+			continue
+		}
+		var err error
+		if printDeps == "tree" {
+			err = printDepsTree(src, "\t", map[string]bool{src: true}, make(map[string]bool))
+		} else {
+			err = printDepsList(src)
+		}
+		if err != nil {
+			fmt.Printf("%s error in file %s: %v\n", os.Args[0], src, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%s: errors in %d files\n", os.Args[0], failed)
+		return 1
+	}
+	return 0
+}
+
+// printDepsList prints every file src transitively includes, one per line,
+// followed by the includes that couldn't be resolved.
+func printDepsList(src string) error {
+	deps, err := includes.deps(src)
+	if err != nil {
+		return err
+	}
+	for _, d := range deps {
+		fmt.Printf("\t%s\n", d)
+	}
+	for _, f := range append([]string{src}, deps...) {
+		missing, err := includes.unresolved(f)
+		if err != nil {
+			return err
+		}
+		for _, name := range missing {
+			fmt.Printf("\t%s (not found, included by %s)\n", name, f)
+		}
+	}
+	return nil
+}
+
+// printDepsTree prints the files directly included by f with the given
+// indentation, each followed by its own includes. The files of path, from the
+// source down to f, are reported as cycles, and files already printed for the
+// source are only named again.
+func printDepsTree(f, indent string, path, printed map[string]bool) error {
+	incs, err := includes.direct(f)
+	if err != nil {
+		return err
+	}
+	for _, inc := range incs {
+		switch {
+		case path[inc]:
+			fmt.Printf("%s%s (cycle)\n", indent, inc)
+		case printed[inc]:
+			fmt.Printf("%s%s (repeated)\n", indent, inc)
+		default:
+			fmt.Printf("%s%s\n", indent, inc)
+			printed[inc] = true
+			path[inc] = true
+			if err := printDepsTree(inc, indent+"\t", path, printed); err != nil {
+				return fmt.Errorf("in %s: %v", inc, err)
+			}
+			delete(path, inc)
+		}
+	}
+	missing, err := includes.unresolved(f)
+	if err != nil {
+		return err
+	}
+	for _, name := range missing {
+		fmt.Printf("%s%s (not found)\n", indent, name)
+	}
+	return nil
+}
+
+// printDepsJSON prints a record for every source, followed by one for every
+// file they include, one per line.
+func printDepsJSON(sources []string) int {
+	var failed int
+	included := make(map[string]bool)
+	isSource := make(map[string]bool)
+	for _, src := range sources {
+		isSource[src] = true
+	}
+	emit := func(r depsRecord) {
+		b, _ := json.Marshal(r) // can't fail with these fields
+		fmt.Println(string(b))
+	}
+	record := func(f string, r *depsRecord) error {
+		incs, err := includes.direct(f)
+		if err != nil {
+			return err
+		}
+		r.Includes = incs
+		if r.Missing, err = includes.unresolved(f); err != nil {
+			return err
+		}
+		if r.Source {
+			r.Deps, err = includes.deps(f)
+		}
+		return err
+	}
+
+	for _, src := range sources {
+		r := depsRecord{File: src, Source: true}
+		if !isSPIRVFile(src) {
+			if err := record(src, &r); err != nil {
+				r = depsRecord{File: src, Source: true, Error: err.Error()}
+				failed++
+			}
+			for _, d := range r.Deps {
+				included[d] = true
+			}
+		}
+		emit(r)
+	}
+
+	var files []string
+	for f := range included {
+		if !isSource[f] {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		r := depsRecord{File: f}
+		if err := record(f, &r); err != nil {
+			r = depsRecord{File: f, Error: err.Error()}
+			failed++
+		}
+		emit(r)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type fileTiming struct {
+	file     string
+	duration time.Duration
+}
+
+// fileTimings collects the wall-clock durations of operate calls. It is safe
+// for concurrent use.
+type fileTimings struct {
+	mu      sync.Mutex
+	timings []fileTiming
+}
+
+func (t *fileTimings) add(file string, d time.Duration) {
+	t.mu.Lock()
+	t.timings = append(t.timings, fileTiming{file, d})
+	t.mu.Unlock()
+}
+
+// print prints the n slowest files in descending order to logOutput, or with
+// -json a record for each with its duration.
+func (t *fileTimings) print(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sort.Slice(t.timings, func(i, j int) bool {
+		if t.timings[i].duration == t.timings[j].duration {
+			return t.timings[i].file < t.timings[j].file
+		}
+		return t.timings[i].duration > t.timings[j].duration
+	})
+
+	if n > len(t.timings) {
+		n = len(t.timings)
+	}
+	if jsonLog {
+		for _, ft := range t.timings[:n] {
+			writeRecord(logRecord{Level: "INFO", Msg: "slow file", File: ft.file, Stage: stageOf(ft.file), Duration: ft.duration.Seconds()})
+		}
+		return
+	}
+	fmt.Fprintf(logOutput, "%s: %d slowest files:\n", os.Args[0], n)
+	for _, ft := range t.timings[:n] {
+		fmt.Fprintf(logOutput, "%10s  %s\n", ft.duration.Round(time.Millisecond), ft.file)
+	}
+}
+
+// durations returns the duration of each file.
+func (t *fileTimings) durations() map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := make(map[string]time.Duration, len(t.timings))
+	for _, ft := range t.timings {
+		m[ft.file] = ft.duration
+	}
+	return m
+}
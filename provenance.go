@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+)
+
+const (
+	opSourceContinued  = 2
+	opSource           = 3
+	opSourceExtension  = 4
+	opString           = 7
+	opExtension        = 10
+	opExtInstImport    = 11
+	opMemoryModel      = 14
+	opExecutionMode    = 16
+	opModuleProcessed  = 330
+	opExecutionModeID  = 331
+	moduleProcessedMin = 0x00010100 // OpModuleProcessed is new in SPIR-V 1.1
+)
+
+// provenance is the -provenance flag. Compiled modules get an
+// OpModuleProcessed instruction telling how spv built them, which debuggers
+// such as RenderDoc show.
+var provenance bool
+
+// provenanceText returns the text recorded in the module compiled from src
+// for the target t: the spv version and the command lines of
+// the compiler and of the optimizer with -canonicalize. The paths of the
+// input and output, which are in the temp directory, and of the tools are
+// left out, so that it is the same on every build.
+func provenanceText(src string, t target) string {
+	args := t.args(src, "")
+	args = args[:len(args)-3] // -o out src
+	text := fmt.Sprintf("spv %s: %s", spvToolVersion(), commandLine(filepath.Base(cc), args))
+	if ccTemplate != "" {
+		text += " (via -cc-template)"
+	}
+	if canonical {
+		text += "; " + commandLine(filepath.Base(optimizer), canonicalizeArgs)
+	}
+	return text
+}
+
+// spvToolVersion returns the module version spv was built from, or
+// "(devel)" for a build from a checkout.
+func spvToolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// addProvenance returns words with the provenance of the module compiled from
+// src for t added as an OpModuleProcessed instruction, at the end of the
+// debug instructions where the specification puts it. It is added after
+// -canonicalize, which would strip it.
+func addProvenance(src string, t target, words []uint32) ([]uint32, error) {
+	if len(words) < 5 || words[0] != spirvMagic {
+		return nil, fmt.Errorf("invalid SPIR-V header")
+	}
+	if words[1] < moduleProcessedMin {
+		m := spirvModule{version: words[1]}
+		return nil, fmt.Errorf("-provenance needs SPIR-V 1.1 or later, but the module is %s; see -spv-version", m.versionString())
+	}
+
+	// The instructions before the annotations, which begin the next section
+	i := 5
+	for i < len(words) {
+		count := int(words[i] >> 16)
+		if count == 0 || i+count > len(words) {
+			return nil, fmt.Errorf("malformed instruction at word %d", i)
+		}
+		switch words[i] & 0xffff {
+		case opCapability, opExtension, opExtInstImport, opMemoryModel, opEntryPoint,
+			opExecutionMode, opExecutionModeID, opString, opSourceExtension, opSource,
+			opSourceContinued, opName, opMemberName, opModuleProcessed:
+			i += count
+			continue
+		}
+		break
+	}
+
+	str := spirvStringWords(provenanceText(src, t))
+	in := append([]uint32{uint32(1+len(str))<<16 | opModuleProcessed}, str...)
+	out := make([]uint32, 0, len(words)+len(in))
+	out = append(out, words[:i]...)
+	out = append(out, in...)
+	return append(out, words[i:]...), nil
+}
+
+// spirvStringWords encodes s as a SPIR-V literal string: UTF-8, nul
+// terminated and padded to whole words.
+func spirvStringWords(s string) []uint32 {
+	words := make([]uint32, len(s)/4+1)
+	for i := 0; i < len(s); i++ {
+		words[i/4] |= uint32(s[i]) << (8 * uint(i%4))
+	}
+	return words
+}
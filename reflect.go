@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+)
+
+// SPIR-V opcodes, decorations and storage classes used by the reflection.
+const (
+	opNop              = 0
+	opName             = 5
+	opMemberName       = 6
+	opEntryPoint       = 15
+	opTypeBool         = 20
+	opTypeInt          = 21
+	opTypeFloat        = 22
+	opTypeVector       = 23
+	opTypeMatrix       = 24
+	opTypeArray        = 28
+	opTypeRuntimeArray = 29
+	opTypeStruct       = 30
+	opTypePointer      = 32
+	opConstant         = 43
+	opVariable         = 59
+	opDecorate         = 71
+	opMemberDecorate   = 72
+	opFunction         = 54
+	opFunctionParam    = 55
+	opFunctionEnd      = 56
+	opLabel            = 248
+	opReturn           = 253
+
+	decorationArrayStride  = 6
+	decorationMatrixStride = 7
+	decorationOffset       = 35
+
+	storageClassPushConstant          = 9
+	storageClassPhysicalStorageBuffer = 5349
+)
+
+// executionModels maps SPIR-V execution models to the stages they belong to.
+var executionModels = map[uint32]string{
+	0:    "Vertex",
+	1:    "TessControl",
+	2:    "TessEvaluation",
+	3:    "Geometry",
+	4:    "Fragment",
+	5:    "Compute",
+	5267: "Task", // TaskNV
+	5268: "Mesh", // MeshNV
+	5313: "RayGen",
+	5314: "Intersection",
+	5315: "AnyHit",
+	5316: "ClosestHit",
+	5317: "Miss",
+	5318: "Callable",
+	5364: "Task",
+	5365: "Mesh",
+}
+
+// instruction is a single SPIR-V instruction.
+type instruction struct {
+	opcode   uint32
+	operands []uint32
+}
+
+// spirvModule holds what reflection needs to know about a SPIR-V module.
+type spirvModule struct {
+	version uint32
+	instrs  []instruction
+
+	types       map[uint32]instruction // type declarations by result ID
+	constants   map[uint32]uint32      // low words of scalar constants by result ID, defaults for spec constants
+	decorations map[uint32]map[uint32][]uint32
+	members     map[uint32]map[uint32]map[uint32][]uint32 // struct ID -> member -> decoration
+	variables   []instruction
+}
+
+func parseSPIRV(words []uint32) (*spirvModule, error) {
+	if len(words) < 5 || words[0] != spirvMagic {
+		return nil, fmt.Errorf("invalid SPIR-V header")
+	}
+
+	m := &spirvModule{
+		version:     words[1],
+		types:       make(map[uint32]instruction),
+		constants:   make(map[uint32]uint32),
+		decorations: make(map[uint32]map[uint32][]uint32),
+		members:     make(map[uint32]map[uint32]map[uint32][]uint32),
+	}
+
+	for i := 5; i < len(words); {
+		count := int(words[i] >> 16)
+		if count == 0 || i+count > len(words) {
+			return nil, fmt.Errorf("malformed instruction at word %d", i)
+		}
+		in := instruction{words[i] & 0xffff, words[i+1 : i+count]}
+		m.instrs = append(m.instrs, in)
+		i += count
+
+		ops := in.operands
+		switch in.opcode {
+		case opTypeBool, opTypeInt, opTypeFloat, opTypeVector, opTypeMatrix,
+			opTypeArray, opTypeRuntimeArray, opTypeStruct, opTypePointer,
+			opTypeImage, opTypeSampler, opTypeSampledImage, opTypeAccelerationStructure:
+			if len(ops) > 0 {
+				m.types[ops[0]] = in
+			}
+		case opConstant, opSpecConstant:
+			if len(ops) > 2 {
+				m.constants[ops[1]] = ops[2]
+			}
+		case opVariable:
+			if len(ops) > 2 {
+				m.variables = append(m.variables, in)
+			}
+		case opDecorate:
+			if len(ops) > 1 {
+				if m.decorations[ops[0]] == nil {
+					m.decorations[ops[0]] = make(map[uint32][]uint32)
+				}
+				m.decorations[ops[0]][ops[1]] = ops[2:]
+			}
+		case opMemberDecorate:
+			if len(ops) > 2 {
+				if m.members[ops[0]] == nil {
+					m.members[ops[0]] = make(map[uint32]map[uint32][]uint32)
+				}
+				if m.members[ops[0]][ops[1]] == nil {
+					m.members[ops[0]][ops[1]] = make(map[uint32][]uint32)
+				}
+				m.members[ops[0]][ops[1]][ops[2]] = ops[3:]
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// decoration returns the first operand of a decoration on id.
+func (m *spirvModule) decoration(id, decoration uint32) (uint32, bool) {
+	ops, found := m.decorations[id][decoration]
+	if !found || len(ops) == 0 {
+		return 0, false
+	}
+	return ops[0], true
+}
+
+func (m *spirvModule) memberDecoration(id, member, decoration uint32) (uint32, bool) {
+	ops, found := m.members[id][member][decoration]
+	if !found || len(ops) == 0 {
+		return 0, false
+	}
+	return ops[0], true
+}
+
+// sizeOf returns the size in bytes of an explicitly laid out type. Matrices
+// take their stride from the enclosing struct member, and arrays sized by a
+// specialization constant its default value. Pointers can only be sized in the
+// PhysicalStorageBuffer storage class, where they are 64-bit addresses.
+func (m *spirvModule) sizeOf(id, matrixStride uint32) (uint32, error) {
+	t := m.types[id]
+	ops := t.operands
+	switch {
+	case t.opcode == opTypeBool:
+		return 4, nil
+	case (t.opcode == opTypeInt || t.opcode == opTypeFloat) && len(ops) > 1:
+		return ops[1] / 8, nil
+	case t.opcode == opTypeVector && len(ops) > 2:
+		size, err := m.sizeOf(ops[1], 0)
+		return ops[2] * size, err
+	case t.opcode == opTypeMatrix && len(ops) > 2:
+		if matrixStride == 0 {
+			var err error
+			if matrixStride, err = m.sizeOf(ops[1], 0); err != nil {
+				return 0, err
+			}
+		}
+		return ops[2] * matrixStride, nil
+	case t.opcode == opTypeArray && len(ops) > 2:
+		length, found := m.constants[ops[2]]
+		if !found {
+			return 0, fmt.Errorf("array %d has a length that isn't a constant", id)
+		}
+		stride, found := m.decoration(id, decorationArrayStride)
+		if !found {
+			var err error
+			if stride, err = m.sizeOf(ops[1], matrixStride); err != nil {
+				return 0, err
+			}
+		}
+		return length * stride, nil
+	case t.opcode == opTypeStruct:
+		var end uint32
+		for i, member := range ops[1:] {
+			offset, _ := m.memberDecoration(id, uint32(i), decorationOffset)
+			stride, _ := m.memberDecoration(id, uint32(i), decorationMatrixStride)
+			size, err := m.sizeOf(member, stride)
+			if err != nil {
+				return 0, err
+			}
+			if e := offset + size; e > end {
+				end = e
+			}
+		}
+		return end, nil
+	case t.opcode == opTypePointer && len(ops) > 1:
+		if ops[1] != storageClassPhysicalStorageBuffer {
+			return 0, fmt.Errorf("cannot size pointer %d in storage class %d", id, ops[1])
+		}
+		return 8, nil
+	}
+	return 0, fmt.Errorf("cannot size type %d with opcode %d", id, t.opcode)
+}
+
+// pushConstantRange returns the offset and size of the push constant block,
+// or zeros if the module doesn't use push constants.
+func (m *spirvModule) pushConstantRange() (offset, size uint32, err error) {
+	for _, v := range m.variables {
+		if v.operands[2] != storageClassPushConstant {
+			continue
+		}
+		ptr := m.types[v.operands[0]]
+		if ptr.opcode != opTypePointer || len(ptr.operands) < 3 {
+			continue
+		}
+		block := ptr.operands[2]
+		st := m.types[block]
+		if st.opcode != opTypeStruct || len(st.operands) < 2 {
+			continue
+		}
+
+		offset = ^uint32(0)
+		for i := range st.operands[1:] {
+			if o, _ := m.memberDecoration(block, uint32(i), decorationOffset); o < offset {
+				offset = o
+			}
+		}
+		end, err := m.sizeOf(block, 0)
+		if err != nil {
+			return 0, 0, fmt.Errorf("push constants: %v", err)
+		}
+		return offset, end - offset, nil
+	}
+	return 0, 0, nil
+}
+
+// entryPoint is an OpEntryPoint of a module.
+type entryPoint struct {
+	name  string
+	stage string // "Unknown" for execution models without a stage
+}
+
+// versionString returns the SPIR-V version of the module as "major.minor".
+// The version word is 0x00MMmm00.
+func (m *spirvModule) versionString() string {
+	return fmt.Sprintf("%d.%d", m.version>>16&0xff, m.version>>8&0xff)
+}
+
+// entryPoints returns the entry points of the module in declaration order.
+func (m *spirvModule) entryPoints() []entryPoint {
+	var eps []entryPoint
+	for _, in := range m.instrs {
+		if in.opcode != opEntryPoint || len(in.operands) < 3 {
+			continue
+		}
+		stage, found := executionModels[in.operands[0]]
+		if !found {
+			stage = "Unknown"
+		}
+		eps = append(eps, entryPoint{spirvString(in.operands[2:]), stage})
+	}
+	return eps
+}
+
+// spirvString decodes the nul-terminated literal string at the start of words.
+func spirvString(words []uint32) string {
+	var b []byte
+	for _, w := range words {
+		for i := uint(0); i < 4; i++ {
+			c := byte(w >> (8 * i))
+			if c == 0 {
+				return string(b)
+			}
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// degenerateModule returns a description of why the module looks like its code
+// was lost, e.g. to a preprocessor mistake, or "" if it looks fine.
+func degenerateModule(words []uint32) string {
+	m, err := parseSPIRV(words)
+	if err != nil {
+		return err.Error()
+	}
+
+	var entryPoints, body int
+	inFunction := false
+	for _, in := range m.instrs {
+		switch in.opcode {
+		case opEntryPoint:
+			entryPoints++
+		case opFunction:
+			inFunction = true
+		case opFunctionEnd:
+			inFunction = false
+		case opNop, opFunctionParam, opLabel, opReturn:
+		default:
+			if inFunction {
+				body++
+			}
+		}
+	}
+
+	switch {
+	case entryPoints == 0:
+		return "module has no entry points"
+	case body == 0:
+		return "module contains no instructions besides empty functions"
+	}
+	return ""
+}
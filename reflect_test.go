@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+// testSPIRV returns a module of the instructions after a SPIR-V header, each
+// given as its opcode followed by its operands.
+func testSPIRV(instrs ...[]uint32) []uint32 {
+	words := []uint32{spirvMagic, 0x00010000, 0x00080001, 100, 0}
+	for _, in := range instrs {
+		words = append(words, uint32(len(in))<<16|in[0])
+		words = append(words, in[1:]...)
+	}
+	return words
+}
+
+func TestPushConstantRange(t *testing.T) {
+	// A push constant block of a float array sized by a spec constant of 4
+	// and a member of the type given in each test at offset 16
+	block := func(member ...[]uint32) []uint32 {
+		instrs := [][]uint32{
+			{opTypeFloat, 1, 32},
+			{opTypeInt, 2, 32, 0},
+			{opSpecConstant, 2, 3, 4},
+			{opTypeArray, 4, 1, 3},
+			{opDecorate, 4, decorationArrayStride, 4},
+		}
+		instrs = append(instrs, member...)
+		return testSPIRV(append(instrs,
+			[]uint32{opTypeStruct, 6, 4, 5},
+			[]uint32{opMemberDecorate, 6, 0, decorationOffset, 0},
+			[]uint32{opMemberDecorate, 6, 1, decorationOffset, 16},
+			[]uint32{opTypePointer, 7, storageClassPushConstant, 6},
+			[]uint32{opVariable, 7, 8, storageClassPushConstant},
+		)...)
+	}
+
+	tests := []struct {
+		name   string
+		module []uint32
+		size   uint32
+		fails  bool
+	}{
+		{"none", testSPIRV([]uint32{opTypeFloat, 1, 32}), 0, false},
+		{"vector", block([]uint32{opTypeVector, 5, 1, 2}), 24, false},
+		{"buffer address", block([]uint32{opTypePointer, 5, storageClassPhysicalStorageBuffer, 1}), 24, false},
+		{"storage buffer pointer", block([]uint32{opTypePointer, 5, storageClassStorageBuffer, 1}), 0, true},
+		{"sampler", block([]uint32{opTypeSampler, 5}), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseSPIRV(tt.module)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset, size, err := m.pushConstantRange()
+			if (err != nil) != tt.fails {
+				t.Fatalf("error %v, want failure %v", err, tt.fails)
+			}
+			if offset != 0 || size != tt.size {
+				t.Errorf("offset %d, size %d; want 0, %d", offset, size, tt.size)
+			}
+		})
+	}
+}
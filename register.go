@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// registerFunc is the -register value, e.g.
+// "github.com/user/shaders/registry.Register". Every generated file gets an
+// init function that registers its shader with it.
+var registerFunc string
+
+// registerName and registerImport are registerFunc as called in the generated
+// code, e.g. "registry.Register", and the import path of its package, which
+// is empty for a function declared in the generated package itself.
+var registerName, registerImport string
+
+// parseRegister splits registerFunc into registerName and registerImport. The
+// function has to take the name of the source, the name of its stage and the
+// binary data, which the Go compiler checks when the generated package is
+// built.
+func parseRegister() error {
+	registerName, registerImport = registerFunc, ""
+	if registerFunc == "" {
+		return nil
+	}
+
+	name := registerFunc
+	if i := strings.LastIndexByte(registerFunc, '.'); i > strings.LastIndexByte(registerFunc, '/') {
+		registerImport, name = registerFunc[:i], registerFunc[i+1:]
+	}
+	if !token.IsIdentifier(name) {
+		return fmt.Errorf("invalid -register %q; expected a function like Register or example.com/registry.Register", registerFunc)
+	}
+	if registerImport == "" {
+		return nil
+	}
+	pkgName := path.Base(registerImport)
+	if !token.IsIdentifier(pkgName) {
+		return fmt.Errorf("-register %q: the package name %q is not an identifier", registerFunc, pkgName)
+	}
+	if byteOutput() && byteTypeImport != "" && byteTypeImport != registerImport && path.Base(byteTypeImport) == pkgName {
+		return fmt.Errorf("-register %q and -byte-type %q import different packages named %s", registerFunc, byteType, pkgName)
+	}
+	registerName = pkgName + "." + name
+	return nil
+}
+
+// writeRegistration writes the init function registering the module compiled
+// from source under its name and stage. The go command passes the files of a
+// package to the compiler sorted by name, so the shaders are registered in
+// the order of their generated files. With -as compressed the function gets
+// the Bytes method of the module, which the registry can't name the type of,
+// so that registering doesn't decompress it.
+func writeRegistration(outFile *bufio.Writer, source string) {
+	data := makeSliceIdentifier(source)
+	if outputMode == "compressed" {
+		data += ".Bytes"
+	}
+	fmt.Fprintf(outFile, "\nfunc init() {\n\t%s(%s, %s, %s)\n}\n",
+		registerName, strconv.Quote(source), strconv.Quote(stageOf(source)), data)
+}
+
+// registerChanged returns true if the file gen was generated from src with
+// another -register function, or without one.
+func registerChanged(src, gen string) bool {
+	m, err := readSourceMeta(src, gen)
+	return err != nil || m.Register != registerFunc
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"strconv"
+)
+
+// renames maps sources that appeared in this run to the generated files of
+// sources that disappeared with the same contents, i.e. were renamed.
+var renames map[string]string
+
+// renamedOld maps the generated files in renames to their sources.
+var renamedOld map[string]string
+
+// detectRenames fills renames by matching the hash of each new source against
+// the hashes recorded in the generated files that are about to be deleted. A
+// file only matches if it was also compiled with the same arguments and
+// compiler as the new source would be, as its module is reused as it is.
+func detectRenames(newSources []string) {
+	renames = make(map[string]string)
+	renamedOld = make(map[string]string)
+	if force || verifyMode || len(newSources) == 0 || bucketCount > 0 {
+		return
+	}
+
+	type oldFile struct {
+		gen  string
+		meta genMeta
+	}
+	byHash := make(map[string]oldFile)
+	for _, gen := range filesToDelete {
+		m, err := readMeta(gen)
+		if err != nil || m.Hash == "" {
+			continue
+		}
+		byHash[m.Hash] = oldFile{gen, m}
+	}
+	for _, src := range newSources {
+		h, err := includes.hash(src)
+		if err != nil {
+			continue
+		}
+		old, found := byHash[h]
+		if !found || old.meta.Fingerprint != argsFingerprint(src) || old.meta.Compiler != compilerHash() {
+			continue
+		}
+		renames[src] = old.gen
+		renamedOld[old.gen] = old.meta.Source
+		delete(byHash, h) // one old file for each new source
+	}
+}
+
+func renamedFrom(gen string) string {
+	return renamedOld[gen]
+}
+
+// generatedModule reads back the module embedded in the generated file gen,
+// in either output mode.
+func generatedModule(gen string) ([]uint32, error) {
+	m, err := readMeta(gen)
+	if err != nil {
+		return nil, err
+	}
+	return moduleIn(gen, m.Source)
+}
+
+// moduleIn reads back the module compiled from src out of the generated file
+// gen, which is a bucket file with -bucket.
+func moduleIn(gen, src string) ([]uint32, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), gen, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	name := makeSliceIdentifier(src)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok || len(vs.Names) != 1 || vs.Names[0].Name != name || len(vs.Values) != 1 {
+				continue
+			}
+			switch v := vs.Values[0].(type) {
+			case *ast.CompositeLit:
+				return literalWords(v)
+			case *ast.CallExpr:
+				// readModule("foo.frag.spv") of an embedded module, possibly
+				// converted to the -byte-type or the output mode, a
+				// conversion of an inline string literal, lazyModule of a
+				// compressed one or blobModule("foo.frag")
+				for len(v.Args) == 1 {
+					inner, ok := v.Args[0].(*ast.CallExpr)
+					if !ok {
+						break
+					}
+					v = inner
+				}
+				var b bytes.Buffer
+				if len(v.Args) != 1 || literalString(v.Args[0], &b) != nil {
+					return nil, fmt.Errorf("unexpected binary data in %s", gen)
+				}
+				if id, ok := v.Fun.(*ast.Ident); ok && id.Name == "readModule" {
+					return readSPIRVFile(path.Join(embedPath(), b.String()))
+				} else if ok && id.Name == "lazyModule" {
+					return decompressModule(b.String())
+				} else if ok && id.Name == "blobModule" {
+					modules, err := readBlob(blobPath())
+					if err != nil {
+						return nil, err
+					}
+					if words, found := modules[b.String()]; found {
+						return words, nil
+					}
+					return nil, fmt.Errorf("%s has no module of %s", blobPath(), b.String())
+				}
+				return readSPIRV(&b)
+			default:
+				var b bytes.Buffer
+				if err := literalString(v, &b); err != nil {
+					return nil, err
+				}
+				return readSPIRV(&b)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s has no %s", gen, name)
+}
+
+func literalWords(lit *ast.CompositeLit) ([]uint32, error) {
+	words := make([]uint32, 0, len(lit.Elts))
+	for _, elt := range lit.Elts {
+		bl, ok := elt.(*ast.BasicLit)
+		if !ok || bl.Kind != token.INT {
+			return nil, errors.New("unexpected element in binary data")
+		}
+		w, err := strconv.ParseUint(bl.Value, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, uint32(w))
+	}
+	return words, nil
+}
+
+// literalString appends the value of a concatenation of string literals.
+func literalString(expr ast.Expr, b *bytes.Buffer) error {
+	switch v := expr.(type) {
+	case *ast.BinaryExpr:
+		if v.Op != token.ADD {
+			return errors.New("unexpected operator in binary data")
+		}
+		if err := literalString(v.X, b); err != nil {
+			return err
+		}
+		return literalString(v.Y, b)
+	case *ast.BasicLit:
+		if v.Kind != token.STRING {
+			return errors.New("unexpected literal in binary data")
+		}
+		s, err := strconv.Unquote(v.Value)
+		if err != nil {
+			return err
+		}
+		b.WriteString(s)
+		return nil
+	}
+	return errors.New("unexpected expression in binary data")
+}
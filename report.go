@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// reportFile is the file given with -report, which a summary of the run is
+// written to after it, as text or with -json as JSON.
+var reportFile string
+
+// buildReport is the summary of a run written by -report. The JSON keys are
+// part of the format CI tools read.
+type buildReport struct {
+	Time      string         `json:"time"`
+	ExitCode  int            `json:"exit_code"`
+	Compiler  string         `json:"compiler"`
+	Version   string         `json:"compiler_version,omitempty"`
+	Generated []reportedFile `json:"generated"`
+	Skipped   []string       `json:"skipped"`
+	Deleted   []string       `json:"deleted"`
+	Errors    []reportedFile `json:"errors"`
+}
+
+// reportedFile is a source that was generated or failed, or a stale file that
+// couldn't be deleted.
+type reportedFile struct {
+	File     string  `json:"file"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Size     int     `json:"size,omitempty"`     // bytes of the module
+	Error    string  `json:"error,omitempty"`
+}
+
+// newBuildReport summarizes the result of the latest generate, which exited
+// with code. The sources that were neither generated nor failed were up to
+// date.
+func newBuildReport(res *runResult, code int) buildReport {
+	r := buildReport{
+		Time:      time.Now().Format(time.RFC3339),
+		ExitCode:  code,
+		Compiler:  cc,
+		Generated: []reportedFile{},
+		Skipped:   []string{},
+		Deleted:   append([]string{}, res.Deleted...),
+		Errors:    []reportedFile{},
+	}
+	if version, err := compilerOutput("--version"); err == nil {
+		r.Version = strings.SplitN(version, "\n", 2)[0]
+	}
+
+	done := make(map[string]bool)
+	for _, f := range res.Generated {
+		r.Generated = append(r.Generated, reportedFile{File: f, Duration: res.Durations[f].Seconds(), Size: res.Sizes[f]})
+		done[f] = true
+	}
+	for _, fe := range res.Errors {
+		r.Errors = append(r.Errors, reportedFile{File: fe.File, Duration: res.Durations[fe.File].Seconds(), Error: fe.Err.Error()})
+		done[fe.File] = true
+	}
+	for _, fe := range res.DeleteErrors {
+		r.Errors = append(r.Errors, reportedFile{File: fe.File, Error: "cannot delete stale file: " + fe.Err.Error()})
+	}
+	for _, f := range filesTotal {
+		if !done[f] {
+			r.Skipped = append(r.Skipped, f)
+		}
+	}
+	return r
+}
+
+// writeReport writes the report to name, as JSON with -json.
+func writeReport(name string, r buildReport) error {
+	return writeAtomic(name, func(f io.Writer) error {
+		if jsonLog {
+			b, err := json.MarshalIndent(r, "", "\t")
+			if err != nil {
+				return err
+			}
+			_, err = f.Write(append(b, '\n'))
+			return err
+		}
+
+		w := bufio.NewWriter(f)
+		fmt.Fprintf(w, "spv build report, %s\n\n", r.Time)
+		fmt.Fprintf(w, "exit code: %d\n", r.ExitCode)
+		fmt.Fprintf(w, "compiler:  %s", r.Compiler)
+		if r.Version != "" {
+			fmt.Fprintf(w, " (%s)", r.Version)
+		}
+		fmt.Fprintf(w, "\n%d generated, %d skipped, %d deleted, %d errors\n",
+			len(r.Generated), len(r.Skipped), len(r.Deleted), len(r.Errors))
+
+		if len(r.Generated) > 0 {
+			w.WriteString("\nGenerated:\n")
+			for _, g := range r.Generated {
+				fmt.Fprintf(w, "  %-40s %10s %8d bytes\n", g.File, seconds(g.Duration), g.Size)
+			}
+		}
+		if len(r.Skipped) > 0 {
+			w.WriteString("\nUp to date:\n")
+			for _, f := range r.Skipped {
+				fmt.Fprintf(w, "  %s\n", f)
+			}
+		}
+		if len(r.Deleted) > 0 {
+			w.WriteString("\nDeleted:\n")
+			for _, f := range r.Deleted {
+				fmt.Fprintf(w, "  %s\n", f)
+			}
+		}
+		if len(r.Errors) > 0 {
+			w.WriteString("\nErrors:\n")
+			for _, fe := range r.Errors {
+				fmt.Fprintf(w, "  %s: %s\n", fe.File, strings.ReplaceAll(strings.TrimSpace(fe.Error), "\n", "\n    "))
+			}
+		}
+		return w.Flush()
+	})
+}
+
+// seconds formats a duration given in seconds like time.Duration does,
+// rounded to the millisecond.
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+// checkReportArg makes the -report path absolute, since generating changes
+// to the source directory before the report is written.
+func checkReportArg() error {
+	if reportFile == "" {
+		return nil
+	}
+	if watchMode || serveAddr != "" {
+		return errors.New("-report can't be used with -watch or -serve")
+	}
+	abs, err := filepath.Abs(reportFile)
+	if err != nil {
+		return err
+	}
+	reportFile = abs
+	return nil
+}
+
+// saveReport writes the report of the run that exited with code. A failure
+// to write it only changes the exit code of a successful run, so that the
+// report never hides why a run failed.
+func saveReport(code int) int {
+	if err := writeReport(reportFile, newBuildReport(lastResult, code)); err != nil {
+		fmt.Printf("%s error: Cannot write report %s: %v\n", os.Args[0], reportFile, err)
+		if code == 0 {
+			return 1
+		}
+	}
+	return code
+}
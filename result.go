@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fileError is an error that occurred while generating from a single source.
+type fileError struct {
+	File string
+	Err  error
+}
+
+// runResult collects the outcome of a run. It is safe for concurrent use by
+// the workers.
+type runResult struct {
+	mu        sync.Mutex
+	Generated []string    // sources whose generated files were written
+	Deleted   []string    // stale generated files that were removed
+	Errors    []fileError // every per-file error, sorted by file after the run
+
+	// DeleteErrors holds the stale generated files that couldn't be removed,
+	// in the order they were tried.
+	DeleteErrors []fileError
+
+	Durations map[string]time.Duration // time spent on each source, set after the run
+	Sizes     map[string]int           // bytes of the modules compiled or reused by source
+}
+
+func (r *runResult) addSize(file string, size int) {
+	r.mu.Lock()
+	if r.Sizes == nil {
+		r.Sizes = make(map[string]int)
+	}
+	r.Sizes[file] = size
+	r.mu.Unlock()
+}
+
+func (r *runResult) addGenerated(file string) {
+	r.mu.Lock()
+	r.Generated = append(r.Generated, file)
+	r.mu.Unlock()
+}
+
+func (r *runResult) addError(file string, err error) {
+	r.mu.Lock()
+	r.Errors = append(r.Errors, fileError{file, err})
+	r.mu.Unlock()
+}
+
+// deleteStale removes the stale generated file and records the outcome. A file
+// that can't be removed is left behind with a warning; it would still declare
+// the shader of its source, so the package may not build until it is gone.
+func (r *runResult) deleteStale(file string) {
+	err := os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		r.DeleteErrors = append(r.DeleteErrors, fileError{file, err})
+		printSummary("WARN", fmt.Sprintf("cannot delete stale file %s: %v", file, err))
+		return
+	}
+	r.Deleted = append(r.Deleted, file)
+	printDeleted(file)
+}
+
+func (r *runResult) errorCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Errors)
+}
+
+// sort orders the results by file so that the output is deterministic.
+func (r *runResult) sort() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Strings(r.Generated)
+	sort.Strings(r.Deleted)
+	sort.Slice(r.Errors, func(i, j int) bool { return r.Errors[i].File < r.Errors[j].File })
+}
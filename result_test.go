@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, dir, file string)
+		deleted bool
+	}{
+		{"writable", func(t *testing.T, dir, file string) {
+			writeFiles(t, dir, map[string]string{filepath.Base(file): "package x\n"})
+		}, true},
+		{"already gone", func(t *testing.T, dir, file string) {}, true},
+		{"read-only file", func(t *testing.T, dir, file string) {
+			writeFiles(t, dir, map[string]string{filepath.Base(file): "package x\n"})
+			if err := os.Chmod(file, 0444); err != nil {
+				t.Fatal(err)
+			}
+		}, true}, // removing needs a writable directory, not file
+		{"read-only directory", func(t *testing.T, dir, file string) {
+			if os.Geteuid() == 0 {
+				t.Skip("root can remove files from read-only directories")
+			}
+			writeFiles(t, dir, map[string]string{filepath.Base(file): "package x\n"})
+			if err := os.Chmod(dir, 0555); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+		{"non-empty directory in its place", func(t *testing.T, dir, file string) {
+			if err := os.Mkdir(file, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, file, map[string]string{"keep": ""})
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := ioutil.TempDir("", "spv-test-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(base)
+			dir := filepath.Join(base, "out")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chmod(dir, 0755)
+			file := filepath.Join(dir, "stale.frag.gen.go")
+			tt.setup(t, dir, file)
+
+			var r runResult
+			r.deleteStale(file)
+			if tt.deleted {
+				if len(r.Deleted) != 1 || len(r.DeleteErrors) != 0 {
+					t.Errorf("deleted %q with errors %v, want it deleted", r.Deleted, r.DeleteErrors)
+				}
+				if _, err := os.Lstat(file); !os.IsNotExist(err) {
+					t.Errorf("%s is still there", file)
+				}
+				return
+			}
+			if len(r.Deleted) != 0 || len(r.DeleteErrors) != 1 || r.DeleteErrors[0].File != file || r.DeleteErrors[0].Err == nil {
+				t.Errorf("deleted %q with errors %v, want an error for %s", r.Deleted, r.DeleteErrors, file)
+			}
+			if _, err := os.Lstat(file); err != nil {
+				t.Errorf("%s is gone: %v", file, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// scanEntry is what -fast-scan remembers about a source directory: the
+// modification times of the directories scanned and the sources found.
+type scanEntry struct {
+	Dirs    map[string]int64 `json:"dirs"`
+	Sources []string         `json:"sources"`
+}
+
+// scanCachePath returns the path of the file the scan entries of all source
+// directories are kept in.
+func scanCachePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "spv", "scan.json"), nil
+}
+
+// scanKey returns the key of the entry for the current directory. The flags
+// that change which directories are scanned are part of it.
+func scanKey() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if recursive {
+		wd += "\x00recursive"
+		if followSymlinks {
+			wd += "\x00follow-symlinks"
+		}
+	}
+	// Changed flags can make the files stale without touching any directory
+	return wd + "\x00" + globalFingerprint(), nil
+}
+
+func loadScanCache() map[string]scanEntry {
+	cache := make(map[string]scanEntry)
+	name, err := scanCachePath()
+	if err != nil {
+		return cache
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return cache
+	}
+	// A corrupt cache is as good as none
+	json.Unmarshal(data, &cache)
+	return cache
+}
+
+// scanUnchanged returns true if no directory scanned in the last successful
+// run has changed since and the manifest is as expected, in which case there's
+// nothing to do. Sources that were modified without touching their directory
+// are not noticed.
+func scanUnchanged() bool {
+	key, err := scanKey()
+	if err != nil {
+		return false
+	}
+	ent, found := loadScanCache()[key]
+	if !found || len(ent.Dirs) == 0 {
+		return false
+	}
+	// The manifest must exist, or with -no-manifest not exist
+	if _, err := os.Stat(manifestPath()); (err == nil) == noManifest {
+		return false
+	}
+
+	bySource := make(map[string][]string)
+	for _, src := range ent.Sources {
+		d := path.Dir(src)
+		bySource[d] = append(bySource[d], path.Base(src))
+	}
+	for d, mtime := range ent.Dirs {
+		fi, err := os.Stat(d)
+		if err != nil || fi.ModTime().UnixNano() != mtime {
+			return false
+		}
+		// Guards against coarse modification times
+		names, err := sourceNames(d)
+		if err != nil || !equalStrings(names, bySource[d]) {
+			return false
+		}
+	}
+	return true
+}
+
+// saveScanCache records the directories scanned and the sources found in
+// this run.
+func saveScanCache() {
+	key, err := scanKey()
+	if err != nil {
+		return
+	}
+	ent := scanEntry{Dirs: make(map[string]int64), Sources: filesTotal}
+	for _, d := range scannedDirs {
+		// Generating files changes the modification time of the directory
+		fi, err := os.Stat(d)
+		if err != nil {
+			return
+		}
+		ent.Dirs[d] = fi.ModTime().UnixNano()
+	}
+
+	name, err := scanCachePath()
+	if err != nil {
+		return
+	}
+	cache := loadScanCache()
+	cache[key] = ent
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return
+	}
+	ioutil.WriteFile(name, data, 0644)
+}
+
+// sourceNames returns the sorted names of the sources in dir, without
+// stat-ing every file.
+func sourceNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	all, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range all {
+		if isSourceFile(n) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
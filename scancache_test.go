@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkFastScan checks a directory of 5000 up-to-date shaders for
+// changes with the full scan, which stats every source and generated file,
+// and with -fast-scan, which only looks at the directory.
+func BenchmarkFastScan(b *testing.B) {
+	const shaders = 5000
+	dir, err := ioutil.TempDir("", "spv-bench-*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+
+	src := filepath.Join(dir, "shaders")
+	if err := os.Mkdir(src, 0755); err != nil {
+		b.Fatal(err)
+	}
+	module := string(spirvBytes([]uint32{spirvMagic, 0x00010000, 0x00080001, 10, 0, 0x00020011, 1, 0x0003000e, 0, 1}))
+	files := make(map[string]string)
+	for i := 0; i < shaders; i++ {
+		files[fmt.Sprintf("shader%04d.comp.spv", i)] = module
+	}
+	writeFiles(b, src, files)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(src); err != nil {
+		b.Fatal(err)
+	}
+	defer func(c, p, mode string, n, perLine int, fast bool) {
+		cc, pkg, outputMode, jobs, wordsPerLine, fastScan = c, p, mode, n, perLine, fast
+	}(cc, pkg, outputMode, jobs, wordsPerLine, fastScan)
+	cc, pkg, outputMode, jobs, wordsPerLine, fastScan = "true", "x", "words", 4, 8, true
+	resetState()
+	if c := generate(); c != 0 {
+		b.Fatalf("generating %d modules exited with %d", shaders, c)
+	}
+
+	b.Run("full scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resetState()
+			if c := getFiles(); c != 0 || len(filesToGenerate) > 0 {
+				b.Fatalf("getFiles exited with %d, %d files to generate", c, len(filesToGenerate))
+			}
+		}
+	})
+	b.Run("fast scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !scanUnchanged() {
+				b.Fatal("unchanged directory scanned again")
+			}
+		}
+	})
+}
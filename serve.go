@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// changesTimeout is how long a request for changes waits for one.
+const changesTimeout = 30 * time.Second
+
+// servedShader is the latest successfully compiled module of a shader.
+type servedShader struct {
+	data       []byte // SPIR-V in little-endian byte order
+	generation int    // generation in which it was last compiled
+}
+
+// shaderStore holds the latest modules for -serve. It is updated by the
+// compiling goroutines and read by the HTTP handlers.
+type shaderStore struct {
+	mu         sync.Mutex
+	shaders    map[string]servedShader
+	generation int
+	updated    chan e // closed and replaced whenever generation grows
+}
+
+func newShaderStore() *shaderStore {
+	return &shaderStore{shaders: make(map[string]servedShader), updated: make(chan e)}
+}
+
+// set stores the module compiled from src in the current generation.
+func (s *shaderStore) set(src string, words []uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shaders[src] = servedShader{spirvBytes(words), s.generation + 1}
+}
+
+// commit ends the current generation after a run, dropping deleted shaders,
+// and wakes up the requests waiting for changes if anything changed.
+func (s *shaderStore) commit(sources []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	current := make(map[string]e)
+	for _, src := range sources {
+		current[src] = e{}
+	}
+	for src, sh := range s.shaders {
+		if _, found := current[src]; !found {
+			delete(s.shaders, src)
+			changed = true
+		} else if sh.generation > s.generation {
+			changed = true
+		}
+	}
+	if changed {
+		s.generation++
+		close(s.updated)
+		s.updated = make(chan e)
+	}
+}
+
+// changedSince returns the current generation and the shaders compiled after
+// generation since, along with a channel closed on the next change.
+func (s *shaderStore) changedSince(since int) (int, []string, chan e) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for src, sh := range s.shaders {
+		if sh.generation > since && sh.generation <= s.generation {
+			names = append(names, src)
+		}
+	}
+	sort.Strings(names)
+	return s.generation, names, s.updated
+}
+
+func (s *shaderStore) get(src string) (servedShader, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, found := s.shaders[src]
+	return sh, found && sh.generation <= s.generation
+}
+
+// serve keeps generating the package like watch and serves the latest
+// modules over HTTP on serveAddr, a TCP address or "unix:" and a socket path:
+//
+//	GET /shader/<source>     the module compiled from source
+//	GET /changes?since=<n>   waits for shaders compiled after generation n
+func serve() int {
+	store := newShaderStore()
+	compiledHook = store.set
+
+	network, addr := "tcp", serveAddr
+	if strings.HasPrefix(serveAddr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(serveAddr, "unix:")
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+	defer ln.Close() // also removes a Unix socket
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shader/", func(w http.ResponseWriter, r *http.Request) {
+		sh, found := store.get(strings.TrimPrefix(r.URL.Path, "/shader/"))
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Spv-Generation", strconv.Itoa(sh.generation))
+		w.Write(sh.data)
+	})
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		since, err := strconv.Atoi(r.URL.Query().Get("since"))
+		if err != nil {
+			http.Error(w, "since must be a generation number", http.StatusBadRequest)
+			return
+		}
+		gen, names, updated := store.changedSince(since)
+		if len(names) == 0 && gen <= since {
+			select {
+			case <-updated:
+				gen, names, _ = store.changedSince(since)
+			case <-time.After(changesTimeout):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Generation int      `json:"generation"`
+			Shaders    []string `json:"shaders"`
+		}{gen, names})
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	defer srv.Close()
+	fmt.Printf("%s: serving shaders on %s\n", os.Args[0], serveAddr)
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	// The first run compiles everything so that every shader can be served
+	forced := force
+	force = true
+	return watch(ctx, func(exitcode int, res *runResult) {
+		force = forced
+		store.commit(filesTotal)
+	})
+}
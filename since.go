@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sinceRef is the git ref given with -since. Only sources that changed since
+// it, or whose includes did, are regenerated.
+var sinceRef string
+
+// changedSince returns the absolute paths of the files that differ from ref
+// in the git work tree, including untracked ones.
+func changedSince(ref string) (map[string]bool, error) {
+	top, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	root := strings.TrimSpace(top)
+
+	diff, err := gitOutput("diff", "--name-only", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitOutput("ls-files", "--others", "--exclude-standard", "--full-name")
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool)
+	for _, name := range strings.Fields(diff + "\n" + untracked) {
+		changed[filepath.Join(root, filepath.FromSlash(name))] = true
+	}
+	return changed, nil
+}
+
+// gitOutput runs git with args in the current directory and returns its
+// output; the error includes what git printed to stderr.
+func gitOutput(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return string(out), nil
+}
+
+// restrictToChanged drops the sources that didn't change since sinceRef from
+// filesToGenerate, unless they include a file that did. Sources without a
+// generated file are kept, as the manifest refers to every source. If git
+// can't tell what changed, every stale file is generated as usual.
+func restrictToChanged() {
+	changed, err := changedSince(sinceRef)
+	if err != nil {
+		fmt.Printf("%s warning: -since %s: %v; regenerating every stale file\n", os.Args[0], sinceRef, err)
+		return
+	}
+
+	isChanged := func(p string) bool {
+		abs, err := filepath.Abs(p)
+		return err == nil && changed[abs]
+	}
+	affected := func(src string) bool {
+		if isChanged(src) || isChanged(sourcePath(src)) {
+			return true
+		}
+		if _, err := os.Stat(generatedName(src)); err != nil {
+			return true
+		}
+		if isSPIRVFile(src) {
+			return false
+		}
+		deps, err := includes.deps(src)
+		if err != nil {
+			return true // left for the compiler to report
+		}
+		for _, dep := range deps {
+			if isChanged(dep) {
+				return true
+			}
+		}
+		return false
+	}
+
+	kept := filesToGenerate[:0]
+	for _, src := range filesToGenerate {
+		if affected(src) {
+			kept = append(kept, src)
+		} else if verbosity >= 1 && !quietSkip {
+			fmt.Printf("%s: %s is unchanged since %s; skipping\n", os.Args[0], src, sinceRef)
+		}
+	}
+	filesToGenerate = kept
+}
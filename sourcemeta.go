@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// sourceMeta adds the ShaderMeta map identifying the source revisions to the
+// manifest.
+var sourceMeta bool
+
+// sourceHash returns the hash identifying the revision of the source src
+// that its generated file was built from. For GLSL sources it is the hash
+// recorded in the generated file, covering the includes too, so it equals
+// that of the build and is the same on every machine. Precompiled modules are
+// hashed as they are.
+func sourceHash(src string) (string, error) {
+	if !isSPIRVFile(src) {
+		m, err := readSourceMeta(src, generatedName(src))
+		if err != nil || m.Hash != "" {
+			return m.Hash, err
+		}
+	}
+	data, err := loader.read(src)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	opSpecConstantTrue  = 48
+	opSpecConstantFalse = 49
+	opSpecConstant      = 50
+
+	decorationSpecID = 1
+)
+
+// specConstant is a scalar specialization constant of a module.
+type specConstant struct {
+	id   uint32 // the constant_id given in the source
+	name string
+	typ  string // Go name of the type, e.g. "float32"; "bool" for VkBool32
+	size uint32 // in bytes, as in VkSpecializationMapEntry
+	bits uint64 // the default value as raw bits
+}
+
+// specConstants returns the specialization constants of the module that can
+// be specialized, i.e. have a SpecId, sorted by their IDs.
+func (m *spirvModule) specConstants() []specConstant {
+	names := make(map[uint32]string)
+	for _, in := range m.instrs {
+		if in.opcode == opName && len(in.operands) > 1 {
+			names[in.operands[0]] = spirvString(in.operands[1:])
+		}
+	}
+
+	var cs []specConstant
+	for _, in := range m.instrs {
+		ops := in.operands
+		if len(ops) < 2 {
+			continue
+		}
+		id, found := m.decoration(ops[1], decorationSpecID)
+		if !found {
+			continue
+		}
+		c := specConstant{id: id, name: names[ops[1]]}
+		switch in.opcode {
+		case opSpecConstantTrue:
+			c.typ, c.size, c.bits = "bool", 4, 1
+		case opSpecConstantFalse:
+			c.typ, c.size = "bool", 4
+		case opSpecConstant:
+			t := m.types[ops[0]]
+			if len(t.operands) < 2 || len(ops) < 3 {
+				continue
+			}
+			width := t.operands[1]
+			switch {
+			case t.opcode == opTypeFloat:
+				c.typ = "float"
+			case t.opcode == opTypeInt && len(t.operands) > 2 && t.operands[2] == 1:
+				c.typ = "int"
+			case t.opcode == opTypeInt:
+				c.typ = "uint"
+			default:
+				continue
+			}
+			c.typ = fmt.Sprintf("%s%d", c.typ, width)
+			c.size = (width + 7) / 8
+			c.bits = uint64(ops[2])
+			if width > 32 && len(ops) > 3 {
+				c.bits |= uint64(ops[3]) << 32
+			}
+			if width < 64 {
+				// Narrow signed values are sign-extended to the word
+				c.bits &= 1<<width - 1
+			}
+		default:
+			continue
+		}
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].id < cs[j].id })
+	return cs
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const spirvMagic = 0x07230203
+
+// maxModuleSize is the largest module in bytes that a codeSize (a size_t) can
+// describe on 32-bit platforms, rounded down to whole words.
+const maxModuleSize = 1<<32 - 4
+
+// readSPIRV reads a SPIR-V module of either endianness and returns its words
+// in host order.
+func readSPIRV(r io.Reader) ([]uint32, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("file too short for a SPIR-V module (%d bytes)", len(data))
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("SPIR-V size %d is not a multiple of 4", len(data))
+	}
+	if err := checkModuleSize(int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(data) == spirvMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data) == spirvMagic:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid magic number %x", data[:4])
+	}
+
+	words := make([]uint32, len(data)/4)
+	for i := range words {
+		words[i] = order.Uint32(data[i*4:])
+	}
+	return words, nil
+}
+
+func readSPIRVFile(name string) ([]uint32, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Refuse pathologically large modules before reading them
+	if fi, err := f.Stat(); err == nil {
+		if err := checkModuleSize(fi.Size()); err != nil {
+			return nil, err
+		}
+	}
+	return readSPIRV(f)
+}
+
+// spirvBytes returns the module as little-endian bytes, which is how Vulkan
+// expects it on little-endian hosts.
+func spirvBytes(words []uint32) []byte {
+	b := make([]byte, len(words)*4)
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+	return b
+}
+
+// checkModuleSize returns an error if a module of size bytes is too large to
+// be loaded by Vulkan everywhere.
+func checkModuleSize(size int64) error {
+	if size > maxModuleSize {
+		return fmt.Errorf("SPIR-V module of %d bytes (%d words) is larger than the %d bytes Vulkan can load on 32-bit platforms",
+			size, size/4, int64(maxModuleSize))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// stageExt holds the -stage-ext mappings of custom source file extensions to
+// stages, e.g. ".vs=Vertex".
+var stageExt stringList
+
+// customExtensions are the extensions added to validExtensions by -stage-ext.
+// The compiler can't tell their stage from the file name, so it is passed
+// explicitly.
+var customExtensions = make(map[string]string)
+
+// forcedStage is the -S stage of the .glsl sources whose names don't give
+// one, e.g. "lighting.glsl", which are taken for includes without it.
+// registerStageExtensions turns an extension such as "frag" into the name.
+var forcedStage string
+
+// hasForcedStage returns true if filename is a .glsl file that gets its stage
+// from -S rather than from its name.
+func hasForcedStage(filename string) bool {
+	if forcedStage == "" || filepath.Ext(filename) != ".glsl" {
+		return false
+	}
+	_, found := validExtensions[stageExtension(filename)]
+	return !found
+}
+
+// registerStageExtensions adds the -stage-ext mappings to validExtensions,
+// replacing those registered for an earlier config target. A stage can be
+// given by name or by its standard extension, e.g. "Vertex" or "vert".
+func registerStageExtensions() error {
+	for ext := range customExtensions {
+		delete(validExtensions, ext)
+	}
+	customExtensions = make(map[string]string)
+
+	for _, m := range stageExt {
+		i := strings.IndexByte(m, '=')
+		if i < 0 {
+			return fmt.Errorf("invalid -stage-ext %q; expected .ext=stage", m)
+		}
+		ext, stage := m[:i], m[i+1:]
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext[1:], "./\\") {
+			return fmt.Errorf("invalid extension %q in -stage-ext; expected e.g. .vs", ext)
+		}
+		switch ext {
+		case ".glsl", ".spv", ".go":
+			return fmt.Errorf("%s can't be used as a stage extension", ext)
+		}
+		if _, found := validExtensions[ext]; found {
+			return fmt.Errorf("-stage-ext %s: the extension already belongs to a stage", ext)
+		}
+		if s, found := validExtensions["."+stage]; found {
+			stage = s
+		}
+		if !isStage(stage) {
+			return fmt.Errorf("invalid stage %q in -stage-ext; expected one of %s", stage, strings.Join(stages, ", "))
+		}
+		validExtensions[ext] = stage
+		customExtensions[ext] = stage
+	}
+
+	if s, found := validExtensions["."+forcedStage]; found {
+		forcedStage = s
+	}
+	if forcedStage != "" && !isStage(forcedStage) {
+		return fmt.Errorf("invalid stage %q for -S; expected one of %s", forcedStage, strings.Join(stages, ", "))
+	}
+	return nil
+}
+
+// stageFlagArgs returns the glslangValidator arguments giving the stage of src
+// if its extension is a custom one, e.g. "-S vert" for a .vs file, or if it
+// has its stage from -S.
+func stageFlagArgs(src string) []string {
+	stage, found := customExtensions[stageExtension(src)]
+	if !found && hasForcedStage(src) {
+		stage, found = forcedStage, true
+	}
+	if !found {
+		return nil
+	}
+	for ext, s := range validExtensions {
+		if _, custom := customExtensions[ext]; !custom && s == stage {
+			return []string{"-S", ext[1:]}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCustomStageExtension generates a package with sources of custom
+// extensions and checks that their files are named, given their stage and
+// removed like those of the standard ones.
+func TestCustomStageExtension(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.frag": "void main() {}\n",
+		"sky.vs": "void main() {}\n",
+		"sky.ps": "void main() {}\n",
+	})
+	exts := []string{"-stage-ext", ".vs=Vertex", "-stage-ext", ".ps=frag"}
+
+	steps := []struct {
+		name  string
+		do    func()
+		args  []string
+		files string
+	}{
+		{"generate", nil, exts, "a.frag a.frag.gen.go shaders.gen.go sky.ps sky.ps.gen.go sky.vs sky.vs.gen.go"},
+		{"up to date", nil, exts, "a.frag a.frag.gen.go shaders.gen.go sky.ps sky.ps.gen.go sky.vs sky.vs.gen.go"},
+		{"source removed", func() { os.Remove(filepath.Join(dir, "sky.ps")) }, exts, "a.frag a.frag.gen.go shaders.gen.go sky.vs sky.vs.gen.go"},
+		{"clean", nil, append([]string{"-clean"}, exts...), "a.frag sky.vs"},
+	}
+	for _, step := range steps {
+		if step.do != nil {
+			step.do()
+		}
+		if code := runSPV(t, dir, step.args...); code != 0 {
+			t.Fatalf("%s: exit code %d", step.name, code)
+		}
+		if got := strings.Join(listFiles(t, dir), " "); got != step.files {
+			t.Fatalf("%s: files %s, want %s", step.name, got, step.files)
+		}
+		if step.name == "generate" {
+			for src, stage := range map[string]string{"sky.vs": "Vertex", "sky.ps": "Fragment"} {
+				m, err := readMeta(filepath.Join(dir, src+genExtension))
+				if err != nil || m.Source != src || m.Stage != stage {
+					t.Errorf("%s: metadata %+v, want the stage %s (%v)", src, m, stage, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import "sync"
+
+// sourceState is what getFiles finds out about a source and its generated
+// file.
+type sourceState struct {
+	found         bool // the generated file holds the source
+	disabled      bool
+	stale         bool // it has to be compiled
+	manifestStale bool // the generated file is newer than the manifest
+
+	// compilerChanged is true if the source is only stale because it was
+	// compiled by another compiler.
+	compilerChanged bool
+
+	// err is why the source or its generated file couldn't be stat'ed, e.g.
+	// because it was removed since the directory was scanned.
+	err error
+}
+
+// checkSources returns the state of each source in filesTotal, in the same
+// order. The stats and reads it takes for a source are independent of the
+// others, so the sources are checked by up to -jobs goroutines at once, which
+// matters for large trees on slow file systems.
+func checkSources(generated map[string]e, held map[string]map[string]bool) []sourceState {
+	states := make([]sourceState, len(filesTotal))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(filesTotal); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				states[i] = checkSource(filesTotal[i], generated, held)
+			}
+		}()
+	}
+	for i := range filesTotal {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return states
+}
+
+// checkSource returns the state of the source src; see checkSources.
+func checkSource(src string, generated map[string]e, held map[string]map[string]bool) sourceState {
+	var s sourceState
+	gen := generatedName(src)
+	_, s.found = generated[gen]
+	if bucketCount > 0 {
+		s.found = held[gen][src]
+	}
+	if s.disabled = isDisabled(src); s.disabled {
+		return s
+	}
+	newer := func(this, that string) bool {
+		n, err := isNewer(this, that)
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+		return n
+	}
+	// An earlier run may have failed before rewriting the manifest
+	s.manifestStale = s.found && manifestFound && newer(gen, manifestPath())
+	s.stale = force || verifyMode || !s.found || newer(sourcePath(src), gen) || depsNewer(src, gen) ||
+		(!isSPIRVFile(src) && argsChanged(src, gen)) || optionsChanged(src, gen)
+	if !s.stale && !isSPIRVFile(src) && compilerChanged(src, gen) {
+		s.stale, s.compilerChanged = true, true
+	}
+	return s
+}
+
+// optionsChanged returns true if the file gen was generated from src with
+// other output options than the current ones, or if its metadata can't be
+// read. The manifest is written for the current options, so such a file has
+// to be regenerated along with it even though its source is unchanged.
+func optionsChanged(src, gen string) bool {
+	if identifierChanged(src, gen) || registerChanged(src, gen) {
+		return true
+	}
+	m, err := readSourceMeta(src, gen)
+	return err != nil || m.Reflect != reflect || m.EmbedSource != embedSource || m.Checksums != checksums || m.As != outputMode ||
+		m.Inline != isInlineFS(src)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// stalenessTree generates a package of n precompiled modules in a temporary
+// directory, changes into it and sets filesTotal to its sources, as getFiles
+// does before checking them. It returns the generated files found and a
+// function that changes back and removes the directory.
+func stalenessTree(tb testing.TB, n int) (map[string]e, func()) {
+	tb.Helper()
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	module := string(spirvBytes([]uint32{spirvMagic, 0x00010000, 0x00080001, 10, 0, 0x00020011, 1, 0x0003000e, 0, 1}))
+	files := make(map[string]string)
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("shader%05d.comp.spv", i)] = module
+	}
+	writeFiles(tb, dir, files)
+	if c := runSPV(tb, dir, "-jobs", "16"); c != 0 {
+		os.RemoveAll(dir)
+		tb.Fatalf("generating %d modules exited with %d", n, c)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		tb.Fatal(err)
+	}
+	resetState()
+	sources, generated := make(map[string]e), make(map[string]e)
+	if err := scanDir(".", make(map[string]string), sources, generated); err != nil {
+		tb.Fatal(err)
+	}
+	for src := range sources {
+		filesTotal = append(filesTotal, src)
+	}
+	sort.Strings(filesTotal)
+	return generated, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCheckSourcesJobs(t *testing.T) {
+	generated, done := stalenessTree(t, 50)
+	defer done()
+	defer func(n int) { jobs = n }(jobs)
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filesTotal[17], future, future); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1, 4, 100} {
+		jobs = n
+		includes = includeScanner{}
+		states := checkSources(generated, nil)
+		if len(states) != len(filesTotal) {
+			t.Fatalf("-jobs %d: %d states for %d sources", n, len(states), len(filesTotal))
+		}
+		for i, s := range states {
+			if !s.found || s.stale != (i == 17) || s.err != nil {
+				t.Errorf("-jobs %d: %s found %v, stale %v, error %v", n, filesTotal[i], s.found, s.stale, s.err)
+			}
+		}
+	}
+
+	// A source removed since the scan is an error rather than a panic
+	if err := os.Remove(filesTotal[23]); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range checkSources(generated, nil) {
+		if (s.err != nil) != (i == 23) {
+			t.Errorf("%s: error %v", filesTotal[i], s.err)
+		}
+	}
+}
+
+// BenchmarkCheckSources checks a tree of 20000 up-to-date sources, 40000
+// files with the generated ones, sequentially and in parallel.
+func BenchmarkCheckSources(b *testing.B) {
+	generated, done := stalenessTree(b, 20000)
+	defer done()
+	defer func(n int) { jobs = n }(jobs)
+
+	for _, n := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("jobs=%d", n), func(b *testing.B) {
+			jobs = n
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				includes = includeScanner{}
+				for _, s := range checkSources(generated, nil) {
+					if s.stale {
+						b.Fatal("stale source in an up-to-date tree")
+					}
+				}
+			}
+		})
+	}
+}
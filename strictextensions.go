@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// strictExtensions fails the GLSL sources that use extensions they don't
+// declare with an #extension directive, which permissive toolchains enable
+// implicitly but others reject.
+var strictExtensions bool
+
+// preprocessorExtensions are added by the compilers themselves for #include
+// and #line, so they are not required to be declared.
+var preprocessorExtensions = map[string]bool{
+	"GL_GOOGLE_include_directive":        true,
+	"GL_GOOGLE_cpp_style_line_directive": true,
+}
+
+var (
+	extensionDirective = regexp.MustCompile(`^\s*#\s*extension\s+(GL_[A-Za-z0-9_]+)\s*:\s*(require|enable|warn)\b`)
+	notRequested       = regexp.MustCompile(`extension not requested:\s*(GL_[A-Za-z0-9_]+)`)
+)
+
+// declaredExtensions returns the extensions the source file src declares in
+// itself, its includes and the -prelude, together with those its compiler
+// arguments enable, e.g. with -enable-ext.
+func declaredExtensions(src string) (map[string]bool, error) {
+	declared := make(map[string]bool)
+	scan := func(data []byte) {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			if m := extensionDirective.FindStringSubmatch(sc.Text()); m != nil {
+				declared[m[1]] = true
+			}
+		}
+	}
+
+	deps, err := includes.deps(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range append([]string{src}, deps...) {
+		data, err := loader.read(name)
+		if err != nil {
+			return nil, err
+		}
+		scan(data)
+	}
+	scan(preludeText)
+	for _, a := range compilerArgs(src, src) {
+		if strings.HasPrefix(a, "-P") {
+			scan([]byte(a[2:]))
+		}
+	}
+	return declared, nil
+}
+
+// implicitExtensions returns the sorted extensions the source file src uses
+// without declaring them: those the compiler reports as not requested in its
+// warnings, as it does with -relaxed, and those the module was compiled with
+// according to its OpSourceExtension instructions.
+func implicitExtensions(src string, words []uint32, warnings []string) ([]string, error) {
+	declared, err := declaredExtensions(src)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseSPIRV(words)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	for _, w := range warnings {
+		for _, match := range notRequested.FindAllStringSubmatch(w, -1) {
+			used[match[1]] = true
+		}
+	}
+	for _, in := range m.instrs {
+		if in.opcode == opSourceExtension && len(in.operands) > 0 {
+			used[spirvString(in.operands)] = true
+		}
+	}
+
+	var implicit []string
+	for ext := range used {
+		if !declared[ext] && !preprocessorExtensions[ext] {
+			implicit = append(implicit, ext)
+		}
+	}
+	sort.Strings(implicit)
+	return implicit, nil
+}
+
+// checkImplicitExtensions returns an error naming each extension the source
+// file src relies on implicitly, for -strict-extensions.
+func checkImplicitExtensions(src string, words []uint32, warnings []string) error {
+	implicit, err := implicitExtensions(src, words, warnings)
+	if err != nil || len(implicit) == 0 {
+		return err
+	}
+	var sb strings.Builder
+	for _, ext := range implicit {
+		fmt.Fprintf(&sb, "\nuses %s without declaring it; add #extension %s : require", ext, ext)
+	}
+	return fmt.Errorf("implicitly enabled extensions (-strict-extensions):%s", sb.String())
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// checkSyntax runs the compiler on every GLSL source found by getFiles,
+// discarding the modules, and reports the errors. Nothing is written, so it
+// is quick enough for editor save hooks.
+func checkSyntax() int {
+	return compileAll("checked", checkSourceSyntax)
+}
+
+// checkSourceSyntax compiles the source f to the null device for checkSyntax, for
+// each of the -multi-target environments or its variants too.
+func checkSourceSyntax(ctx context.Context, f string, statusChan chan status) ([]string, error) {
+	targets, err := sourceTargets(f)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := runCompiler(ctx, f, os.DevNull, target{}, statusChan)
+	for _, t := range targets {
+		if err != nil {
+			break
+		}
+		var w []string
+		if w, err = runCompiler(ctx, f, os.DevNull, t, statusChan); err != nil && err != errInterrupted {
+			err = fmt.Errorf("for %s: %v", t, err)
+		}
+		warnings = addTargetWarnings(warnings, t, w)
+	}
+	return warnings, err
+}
+
+// compileAll runs compile on every GLSL source found by getFiles, up to -jobs
+// at once, and reports the warnings and errors. done says what happened to
+// the sources, for the message about those skipped after -max-errors.
+func compileAll(done string, compile func(ctx context.Context, f string, statusChan chan status) ([]string, error)) int {
+	if _, err := exec.LookPath(cc); err != nil {
+		fmt.Printf("%s error: Cannot find GLSL compiler %s\n", os.Args[0], cc)
+		return 1
+	}
+
+	td, err := makeTempDir()
+	if err != nil {
+		fmt.Printf("%s error: Cannot create temp directory: %v\n", os.Args[0], err)
+		return 1
+	}
+	tempDir = td // for response files and modules
+	defer os.RemoveAll(tempDir)
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	statusChan := make(chan status)
+	statusChanClosed := make(chan e)
+	go func() {
+		defer close(statusChanClosed)
+		for s := range statusChan {
+			printStatus(s)
+		}
+	}()
+
+	res := &runResult{}
+	var abandoned uint32 // files not checked because of -max-errors
+	sem := make(chan e, jobs)
+	var wg sync.WaitGroup
+	for _, f := range filesTotal {
+		if isSPIRVFile(f) {
+			continue
+		}
+		f := f
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- e{}
+			defer func() { <-sem }()
+
+			if maxErrors > 0 && res.errorCount() >= maxErrors {
+				atomic.AddUint32(&abandoned, 1)
+				return
+			}
+
+			warnings, err := compile(ctx, f, statusChan)
+			if err == errInterrupted {
+				return
+			}
+			if err == nil && werror && len(warnings) > 0 {
+				err, warnings = errors.New("\n"+strings.Join(warnings, "\n")), nil
+			}
+			if err != nil {
+				res.addError(f, err)
+			}
+			for _, w := range warnings {
+				statusChan <- status{0, fmt.Sprintf("%s warning in file %s: %s", os.Args[0], f, w), false, f}
+			}
+		}()
+	}
+	wg.Wait()
+	close(statusChan)
+	<-statusChanClosed
+	res.sort()
+
+	for _, fe := range res.Errors {
+		printFileError(fe.File, fe.Err)
+	}
+	if abandoned > 0 {
+		printSummary("ERROR", fmt.Sprintf("stopped after %d errors; %d files were not %s", len(res.Errors), abandoned, done))
+	}
+	if ctx.Err() != nil {
+		printSummary("WARN", "interrupted")
+		return exitInterrupted
+	}
+	if len(res.Errors) > 0 {
+		printSummary("ERROR", fmt.Sprintf("errors in %d files", len(res.Errors)))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// tempBase is the directory given with -tmp to create the temp directory in,
+// instead of the default of the OS. It is made absolute by finishArgs, so
+// that it refers to the same place after changing into -dir.
+var tempBase string
+
+// makeTempDir creates the directory the compilers write their modules into,
+// in tempBase if given. If the default temp directory of the OS can't be
+// written to, the output directory is used instead; the name starting with a
+// dot keeps the go command from looking into it. The caller removes it when
+// done.
+func makeTempDir() (string, error) {
+	if tempBase != "" {
+		return ioutil.TempDir(tempBase, "go-spv-*")
+	}
+	td, err := ioutil.TempDir("", "go-spv-*")
+	if err == nil {
+		return td, nil
+	}
+	if err := os.MkdirAll(outputDir(), 0755); err != nil {
+		return "", err
+	}
+	fallback, err2 := ioutil.TempDir(outputDir(), ".go-spv-*")
+	if err2 != nil {
+		return "", err
+	}
+	if verbosity >= 1 {
+		fmt.Printf("%s: %v; using %s instead\n", os.Args[0], err, fallback)
+	}
+	if abs, err := filepath.Abs(fallback); err == nil {
+		fallback = abs
+	}
+	return fallback, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// toolsDir is the directory under the module root that a compiler given by
+// name is looked up in before the PATH, for projects that keep the compiler
+// next to their code.
+const toolsDir = "tools"
+
+// ccGiven is whether the compiler was given with -cc, which is then run as
+// given, rather than being the default or the one of -cc-template.
+var ccGiven bool
+
+// resolveToolsCompiler replaces a compiler given by name, like the default
+// glslangValidator, with the one in the tools directory of the module
+// containing the source directory, if there is one there. The module root
+// comes from go env GOMOD. On Windows, the name is also tried with .exe. The
+// path is made relative to the source directory, where the compiler runs, so
+// that the fingerprints don't depend on where the module is checked out.
+func resolveToolsCompiler() error {
+	if ccGiven || cc == "" || strings.ContainsRune(cc, '/') || strings.ContainsRune(cc, filepath.Separator) {
+		return nil
+	}
+	root := moduleRoot()
+	if root == "" {
+		return nil
+	}
+	names := []string{cc}
+	if runtime.GOOS == "windows" && !strings.EqualFold(filepath.Ext(cc), ".exe") {
+		names = append(names, cc+".exe")
+	}
+	var candidate string
+	for _, name := range names {
+		path := filepath.Join(root, toolsDir, name)
+		fi, err := os.Stat(path)
+		if err == nil && fi.Mode().IsRegular() && (runtime.GOOS == "windows" || fi.Mode()&0111 != 0) {
+			candidate = path
+			break
+		}
+	}
+	if candidate == "" {
+		return nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(wd, candidate)
+	if err != nil {
+		return err
+	}
+	if !strings.ContainsRune(rel, filepath.Separator) {
+		rel = "." + string(filepath.Separator) + rel // not looked up in the PATH
+	}
+	if verbosity >= 1 {
+		fmt.Printf("%s: using the compiler %s of the module\n", os.Args[0], rel)
+	}
+	cc = rel
+	return nil
+}
+
+// moduleRoot returns the directory of the go.mod file of the module
+// containing the current directory, or "" if it isn't in one or the go
+// command isn't available.
+func moduleRoot() string {
+	out, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		return ""
+	}
+	gomod := string(bytes.TrimSpace(out))
+	if gomod == "" || gomod == os.DevNull {
+		return ""
+	}
+	return filepath.Dir(gomod)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolveToolsCompiler(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+	// The compiler is named without .exe, which is tried on Windows
+	name, file := "glslangValidator", "glslangValidator"
+	if runtime.GOOS == "windows" {
+		file += ".exe"
+	}
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"shaders", "tools"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, dir, map[string]string{
+		"go.mod":        "module example.com/x\n",
+		"tools/" + file: "",
+	})
+	if err := os.Chmod(filepath.Join(dir, "tools", file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(filepath.Join(dir, "shaders")); err != nil {
+		t.Fatal(err)
+	}
+	defer func(name string, given bool) { cc, ccGiven = name, given }(cc, ccGiven)
+
+	for _, given := range []bool{false, true} {
+		cc, ccGiven = name, given
+		if err := resolveToolsCompiler(); err != nil {
+			t.Fatal(err)
+		}
+		want := name
+		if !given {
+			want = filepath.Join("..", "tools", file)
+		}
+		if cc != want {
+			t.Errorf("given %v: compiler %s, want %s", given, cc, want)
+		}
+	}
+}
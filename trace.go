@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// traceFile is the file -trace writes the Chrome trace of the build to.
+var traceFile string
+
+// traceEvent is a complete event ("ph": "X") of the Chrome Trace Event
+// Format, which chrome://tracing and Perfetto can display.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`  // microseconds since the start of the build
+	Dur  int64                  `json:"dur"` // microseconds
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// buildTrace collects the trace events of the workers. Each worker runs in
+// the lane it takes from lanes, one per -jobs slot, so that the trace shows a
+// row for every compiler that can run at once. It is safe for concurrent use.
+type buildTrace struct {
+	start time.Time
+	lanes chan int
+
+	mu     sync.Mutex
+	events []traceEvent
+}
+
+func newBuildTrace(jobs int) *buildTrace {
+	t := &buildTrace{start: time.Now(), lanes: make(chan int, jobs)}
+	for i := 0; i < jobs; i++ {
+		t.lanes <- i + 1
+	}
+	return t
+}
+
+// lane takes a free lane for a worker that holds a -jobs slot. The lane has to
+// be given back with done.
+func (t *buildTrace) lane() int {
+	return <-t.lanes
+}
+
+// done records that the worker in lane processed the source file f from
+// start to now, after waiting for its -jobs slot since queued.
+func (t *buildTrace) done(lane int, f string, queued, start time.Time, changed bool, err error) {
+	args := map[string]interface{}{
+		"wait_ms": float64(start.Sub(queued).Microseconds()) / 1000,
+		"changed": changed,
+	}
+	if err != nil {
+		args["error"] = err.Error()
+	}
+	ev := traceEvent{
+		Name: f,
+		Cat:  stageOf(f),
+		Ph:   "X",
+		Ts:   start.Sub(t.start).Microseconds(),
+		Dur:  time.Since(start).Microseconds(),
+		Pid:  1,
+		Tid:  lane,
+		Args: args,
+	}
+
+	t.mu.Lock()
+	t.events = append(t.events, ev)
+	t.mu.Unlock()
+	t.lanes <- lane
+}
+
+// save writes the trace to traceFile, relative to the directory base.
+func (t *buildTrace) save(base string) error {
+	name := traceFile
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(base, name)
+	}
+	return writeAtomic(name, t.write)
+}
+
+// write writes the trace as a JSON object with a traceEvents array.
+func (t *buildTrace) write(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := t.events
+	if events == nil {
+		events = []traceEvent{}
+	}
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
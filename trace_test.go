@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTraceWriteFailure checks that a trace that can't be written fails the
+// run only after the package is complete.
+func TestTraceWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spv-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"a.frag": "void main() {}\n"})
+
+	trace := filepath.Join(dir, "missing", "trace.json")
+	if code := runSPV(t, dir, "-trace", trace); code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	for _, name := range []string{"a.frag" + genExtension, manifestFilename} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
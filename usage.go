@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// commandMode is a flag that makes spv do something other than generating
+// the package, and exit.
+type commandMode struct {
+	flag  string
+	set   *bool
+	usage string // the flags it takes, after its own
+	doc   string
+}
+
+// commandModes are the modes in the order of the usage message.
+var commandModes = []commandMode{
+	{"init", &initMode, "[-pkg name]", "Add a go:generate directive for spv to doc.go"},
+	{"clean", &cleanMode, "[-recursive] [-internal]", "Remove all generated files"},
+	{"update-lock", &updateLock, "[-cc compiler]", "Pin the installed compiler in " + lockFilename},
+	{"doctor", &doctorMode, "[flags]", "Check that the compiler and tools the flags need are installed"},
+}
+
+// checkModes returns an error if more than one command mode is given, or one
+// with -watch or -serve, which would ignore it.
+func checkModes() error {
+	var given []string
+	for _, m := range commandModes {
+		if *m.set {
+			given = append(given, "-"+m.flag)
+		}
+	}
+	switch {
+	case len(given) > 1:
+		return fmt.Errorf("%s can't be used together", strings.Join(given, ", "))
+	case len(given) == 1 && (watchMode || serveAddr != ""):
+		return fmt.Errorf("%s can't be used with -watch or -serve", given[0])
+	}
+	return nil
+}
+
+// usage prints the modes and the flags. No flag is required: the package
+// name is only needed for generating, and is detected unless -pkg gives it.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [source...]\n\n", flag.CommandLine.Name())
+	fmt.Fprintf(out, "Compiles the GLSL shaders in a directory and generates Go files embedding them.\n\n")
+	fmt.Fprintf(out, "Modes:\n")
+	fmt.Fprintf(out, "  %-40s %s\n", "[-pkg name] [-dir dir] [flags]", "Generate the package (the default)")
+	fmt.Fprintf(out, "  %-40s %s\n", "[flags] source...", "Compile only the named sources, updating the manifest")
+	fmt.Fprintf(out, "  %-40s %s\n", "-verify [flags]", "Check that the generated files are up to date, writing nothing")
+	fmt.Fprintf(out, "  %-40s %s\n", "-syntax-only [flags]", "Only check that the sources compile, writing nothing")
+	fmt.Fprintf(out, "  %-40s %s\n", "-print-deps[=tree] [flags] [source...]", "Print the files the sources include, without compiling")
+	fmt.Fprintf(out, "  %-40s %s\n", "-manifest-only [-pkg name]", "Rewrite the manifest from the generated files")
+	fmt.Fprintf(out, "  %-40s %s\n", "-watch [flags]", "Keep regenerating whenever the sources change")
+	fmt.Fprintf(out, "  %-40s %s\n", "-serve addr [flags]", "Keep compiling and serve the modules over HTTP")
+	for _, m := range commandModes {
+		fmt.Fprintf(out, "  %-40s %s\n", "-"+m.flag+" "+m.usage, m.doc+" and exit")
+	}
+	fmt.Fprintf(out, "\nNo flag is required. Without -pkg, generating uses the package of the Go files\n")
+	fmt.Fprintf(out, "in the directory, or else the directory name; the other modes don't need it.\n")
+	fmt.Fprintf(out, "-dir applies to every mode.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// target is what a module is compiled for besides the compiler arguments: a
+// -multi-target environment, which replaces theirs, or a variant declared
+// with a "// spv:variant" comment, which adds its define. The zero target is
+// the default module.
+type target struct {
+	env     string
+	variant string
+}
+
+func (t target) String() string {
+	if t.variant != "" {
+		return "variant " + t.variant
+	}
+	return "target " + t.env
+}
+
+// suffix returns the suffix of the identifiers of the module compiled for t,
+// e.g. "Vulkan12" for vulkan1.2 or "Nvidia" for the nvidia variant.
+func (t target) suffix() string {
+	if t.variant != "" {
+		return targetSuffix(t.variant)
+	}
+	return targetSuffix(t.env)
+}
+
+// args returns the compiler arguments for compiling the source file src into
+// the SPIR-V file out for t.
+func (t target) args(src, out string) []string {
+	args := compilerArgs(src, out)
+	if t.env != "" {
+		args = withTargetEnv(args, t.env)
+	}
+	if t.variant != "" {
+		// Before the trailing -o out src
+		n := len(args) - 3
+		args = append(append(args[:n:n], variantDefine(t.variant)), args[n:]...)
+	}
+	return args
+}
+
+// variantDefine returns the compiler argument defining the macro that tells a
+// source which variant it is compiled for, e.g. -DSPV_VARIANT_NVIDIA.
+func variantDefine(variant string) string {
+	return "-DSPV_VARIANT_" + strings.ToUpper(variant)
+}
+
+var variantName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// sourceVariants returns the variants declared by the "// spv:variant"
+// directive of the source file src, e.g. "// spv:variant nvidia amd", in the
+// order given. Precompiled modules have none.
+func sourceVariants(src string) ([]string, error) {
+	if isSPIRVFile(src) {
+		return nil, nil
+	}
+	directives, err := sourceDirectives(src)
+	if err != nil {
+		return nil, err
+	}
+	value, found := directives["variant"]
+	if !found {
+		return nil, nil
+	}
+	names := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%svariant needs the names of the variants, e.g. nvidia amd", directivePrefix)
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		switch {
+		case !variantName.MatchString(name):
+			return nil, fmt.Errorf("invalid variant %q; variants are lower case letters, digits and underscores", name)
+		case seen[name]:
+			return nil, fmt.Errorf("variant %s is given twice", name)
+		}
+		seen[name] = true
+	}
+	switch {
+	case len(multiTarget) > 0:
+		return nil, fmt.Errorf("%svariant can't be used with -multi-target", directivePrefix)
+	case isEmbedded(src):
+		return nil, fmt.Errorf("%svariant can't be used with embedded modules; add %soutput inline", directivePrefix, directivePrefix)
+	case inBlob(src):
+		return nil, fmt.Errorf("%svariant can't be used with -as blob; add %soutput inline", directivePrefix, directivePrefix)
+	}
+	return names, nil
+}
+
+// sourceTargets returns the targets the source file src is compiled for
+// besides its default module: the -multi-target environments, or else its
+// variants. They can't be combined, so that each module has a single
+// identifier suffix.
+func sourceTargets(src string) ([]target, error) {
+	var targets []target
+	for _, env := range multiTarget {
+		targets = append(targets, target{env: env})
+	}
+	variants, err := sourceVariants(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range variants {
+		targets = append(targets, target{variant: v})
+	}
+	return targets, nil
+}
+
+// variantModules returns the variants of src with the identifiers of their
+// modules, for the manifest.
+func variantModules(src string) ([]variantModule, error) {
+	variants, err := sourceVariants(src)
+	if err != nil {
+		return nil, err
+	}
+	var modules []variantModule
+	for _, v := range variants {
+		modules = append(modules, variantModule{v, makeSliceIdentifier(src) + target{variant: v}.suffix()})
+	}
+	return modules, nil
+}
+
+// variantModule is a variant of a shader in the manifest.
+type variantModule struct {
+	Name string
+	Data string // identifier of the module
+}
+
+// allVariants returns the names of the variants of all the sources, sorted.
+func allVariants(modules [][]variantModule) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, ms := range modules {
+		for _, m := range ms {
+			if !seen[m.Name] {
+				seen[m.Name] = true
+				names = append(names, m.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// verifyFile renders a file with write and compares it with the file name,
+// returning an error summarizing the differences if they don't match.
+func verifyFile(name string, write func(*bufio.Writer) error) error {
+	want, err := renderFile(write)
+	if err != nil {
+		return err
+	}
+
+	have, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is missing", name)
+	} else if err != nil {
+		return err
+	}
+	if !bytes.Equal(have, want) {
+		return fmt.Errorf("%s doesn't match a fresh build: %s", name, diffSummary(have, want))
+	}
+	return nil
+}
+
+// renderFile returns the contents that writeFileAtomic would write with write.
+func renderFile(write func(*bufio.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeText(&buf, write); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// diffSummary briefly describes how have differs from want: the number of
+// differing lines and an excerpt of the first one.
+func diffSummary(have, want []byte) string {
+	haveLines := strings.Split(string(have), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	n := len(haveLines)
+	if len(wantLines) > n {
+		n = len(wantLines)
+	}
+
+	first, differing := -1, 0
+	for i := 0; i < n; i++ {
+		h, hok := lineAt(haveLines, i)
+		w, wok := lineAt(wantLines, i)
+		if h != w || hok != wok {
+			if first < 0 {
+				first = i
+			}
+			differing++
+		}
+	}
+
+	h, _ := lineAt(haveLines, first)
+	w, _ := lineAt(wantLines, first)
+	col := 0
+	for col < len(h) && col < len(w) && h[col] == w[col] {
+		col++
+	}
+	excerpt := func(lines []string) string {
+		l, ok := lineAt(lines, first)
+		if !ok {
+			return "(end of file)"
+		}
+		start, end := col-30, col+30
+		prefix, suffix := "...", "..."
+		if start <= 0 {
+			start, prefix = 0, ""
+		}
+		if end >= len(l) {
+			end, suffix = len(l), ""
+		}
+		return prefix + strconv.Quote(l[start:end]) + suffix
+	}
+	return fmt.Sprintf("%d of %d lines differ, first at line %d\n\thave %s\n\twant %s",
+		differing, len(wantLines), first+1, excerpt(haveLines), excerpt(wantLines))
+}
+
+// lineAt returns line i and whether there is such a line.
+func lineAt(lines []string, i int) (string, bool) {
+	if i < len(lines) {
+		return lines[i], true
+	}
+	return "", false
+}
+
+// verifyOutputs reports generated files that would be deleted and checks the
+// manifest against a fresh build, after the shaders themselves were verified.
+// shadersOK is false if some of them didn't match.
+func verifyOutputs(shadersOK bool) int {
+	var failed int
+	if bucketCount > 0 {
+		failed += verifyBuckets()
+	}
+	for _, file := range filesToDelete {
+		fmt.Printf("%s: %s is stale and would be deleted\n", os.Args[0], file)
+		failed++
+	}
+	if noManifest {
+		if manifestFound {
+			fmt.Printf("%s: %s would be removed with -no-manifest\n", os.Args[0], manifestPath())
+			failed++
+		}
+	} else if parts, err := manifestParts(); err != nil {
+		fmt.Printf("%s: %v\n", os.Args[0], err)
+		failed++
+	} else {
+		for _, part := range parts {
+			if err := verifyFile(part.path, part.execute); err != nil {
+				fmt.Printf("%s: %v\n", os.Args[0], err)
+				failed++
+			}
+		}
+	}
+	if outputMode == "blob" {
+		if err := verifyBlob(); err != nil {
+			fmt.Printf("%s: %v\n", os.Args[0], err)
+			failed++
+		}
+	}
+	if genTests {
+		if err := verifyFile(testPath(), executeTest); err != nil {
+			fmt.Printf("%s: %v\n", os.Args[0], err)
+			failed++
+		}
+	}
+	if internal {
+		if err := verifyFile(manifestFilename, executeFacade); err != nil {
+			fmt.Printf("%s: %v\n", os.Args[0], err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	if verbosity >= 1 && shadersOK {
+		fmt.Printf("%s: all %d generated files match a fresh build\n", os.Args[0], len(filesTotal))
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pollInterval is how often watch looks for changed sources.
+const pollInterval = 500 * time.Millisecond
+
+// watch generates the package in dir, and again whenever the files it is
+// generated from change, until ctx is done or a run is interrupted. onRun is
+// called with the exit code and result of each run. It is called from the
+// watching goroutine, which waits for it, so it must not block; hand the
+// result to another goroutine for anything slow. The files are polled every
+// pollInterval; a failed file is only retried once something changed.
+func watch(ctx context.Context, onRun func(exitcode int, res *runResult)) int {
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("%s error: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var last string
+	for first := true; ; first = false {
+		snap := snapshot()
+		if !first && snap == last {
+			select {
+			case <-ctx.Done():
+				return exitInterrupted
+			case <-ticker.C:
+			}
+			continue
+		}
+		last = snap
+
+		resetState()
+		code := generate()
+		if err := os.Chdir(wd); err != nil {
+			fmt.Printf("%s error: %v\n", os.Args[0], err)
+			return 1
+		}
+		if code == exitInterrupted {
+			return code
+		}
+		onRun(code, lastResult)
+
+		select {
+		case <-ctx.Done():
+			return exitInterrupted
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchAndReport is -watch: it regenerates on changes until interrupted and
+// prints a summary of every run that did something.
+func watchAndReport() int {
+	ctx, stop := interruptContext()
+	defer stop()
+
+	fmt.Printf("%s: watching for changes\n", os.Args[0])
+	return watch(ctx, func(exitcode int, res *runResult) {
+		if len(res.Generated)+len(res.Deleted)+len(res.DeleteErrors)+len(res.Errors) == 0 {
+			return
+		}
+		fmt.Printf("%s %s: generated %d, deleted %d, %d errors\n", os.Args[0],
+			time.Now().Format("15:04:05"), len(res.Generated), len(res.Deleted), len(res.Errors)+len(res.DeleteErrors))
+	})
+}
+
+// interruptContext returns a context that is cancelled on SIGINT or SIGTERM,
+// and a function releasing it.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigChan)
+		cancel()
+	}
+}
+
+// snapshot describes the modification times and sizes of the files in the
+// source directory, the include directories and the overlay, apart from
+// generated ones and the other files spv writes, so that comparing snapshots
+// tells whether to regenerate.
+func snapshot() string {
+	root := dir
+	if root == "" {
+		root = "."
+	}
+	own := ownOutputs(root)
+
+	var sb strings.Builder
+	var walk func(d string, deep bool)
+	walk = func(d string, deep bool) {
+		fs, err := ioutil.ReadDir(d)
+		if err != nil {
+			return
+		}
+		for _, f := range fs {
+			name := filepath.Join(d, f.Name())
+			switch {
+			case own[absPath(name)]:
+			case f.IsDir():
+				if deep && !strings.HasPrefix(f.Name(), ".") && !isEmbedDir(name) {
+					walk(name, deep)
+				}
+			case strings.HasSuffix(f.Name(), ".go"):
+			default:
+				fmt.Fprintf(&sb, "%s %d %d\n", name, f.ModTime().UnixNano(), f.Size())
+			}
+		}
+	}
+
+	walk(root, recursive)
+	for _, inc := range includeDirs() {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(root, inc)
+		}
+		walk(inc, true)
+	}
+	for _, actual := range overlay {
+		if fi, err := os.Stat(actual); err == nil {
+			fmt.Fprintf(&sb, "%s %d %d\n", actual, fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	if overlayFile != "" {
+		if fi, err := os.Stat(overlayFile); err == nil {
+			fmt.Fprintf(&sb, "%s %d\n", overlayFile, fi.ModTime().UnixNano())
+		}
+	}
+	if preludeFile != "" {
+		if fi, err := os.Stat(preludeFile); err == nil {
+			fmt.Fprintf(&sb, "%s %d %d\n", preludeFile, fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return sb.String()
+}
+
+// ownOutputs returns the absolute paths of the files and directories other
+// than generated Go files that a run in the source directory root writes,
+// such as the -depfile and the -log, so that writing them doesn't look like a
+// change. spv.lock is among them, as -update-lock is the way to change it.
+func ownOutputs(root string) map[string]bool {
+	own := make(map[string]bool)
+	for _, name := range []string{depFile, logFile, reportFile, traceFile, cacheDir, filepath.Join(root, lockFilename)} {
+		if name != "" {
+			own[absPath(name)] = true
+		}
+	}
+	return own
+}
+
+// absPath returns the absolute form of name, or name itself if there is none.
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}